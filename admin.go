@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"personalisation-poc/repository"
+	"personalisation-poc/repository/consistency"
+	"personalisation-poc/repository/convert"
+	"strconv"
+)
+
+func handleConvertProfile(converter *convert.Converter, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, err := convert.ParseDirection(r.PathValue("direction"))
+		if err != nil {
+			httpError(w, r, log, err, "invalid direction", http.StatusBadRequest)
+			return
+		}
+		id := r.PathValue("id")
+
+		if err := converter.Convert(r.Context(), dir, id); err != nil {
+			httpError(w, r, log, err, "error converting profile", http.StatusInternalServerError)
+			return
+		}
+
+		log.InfoContext(r.Context(), "profile converted", "id", id, "direction", dir)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleStartConvertJob(converter *convert.Converter, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, err := convert.ParseDirection(r.PathValue("direction"))
+		if err != nil {
+			httpError(w, r, log, err, "invalid direction", http.StatusBadRequest)
+			return
+		}
+
+		job, err := converter.StartJob(dir)
+		if err != nil {
+			httpError(w, r, log, err, "error starting job", http.StatusInternalServerError)
+			return
+		}
+
+		log.InfoContext(r.Context(), "conversion job started", "job", job.ID, "direction", dir)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", apiBasePath+"/admin/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+func handleListJobs(converter *convert.Converter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(converter.Jobs())
+	}
+}
+
+func handleGetJob(converter *convert.Converter, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job, ok := converter.Job(r.PathValue("jobID"))
+		if !ok {
+			httpError(w, r, log, errors.New("job not found"), "job not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+func handleCancelJob(converter *convert.Converter, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !converter.CancelJob(r.PathValue("jobID")) {
+			httpError(w, r, log, errors.New("job not found"), "job not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleCheckProfile(checker *consistency.Checker, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err := checker.Check(r.Context(), r.PathValue("id"))
+		if err != nil {
+			httpError(w, r, log, err, "error checking profile", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// handleCheckAll streams the reports of the inconsistent profiles as NDJSON while scanning the table.
+func handleCheckAll(checker *consistency.Checker, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		rc := http.NewResponseController(w)
+		err := checker.CheckAll(r.Context(), func(report consistency.Report) error {
+			if report.Status == consistency.Consistent && !all {
+				return nil
+			}
+			if err := enc.Encode(report); err != nil {
+				return err
+			}
+			return rc.Flush()
+		})
+		if err != nil {
+			// the status is already sent, the truncated stream tells the client the scan failed
+			log.ErrorContext(r.Context(), "error checking profiles", "error", err)
+		}
+	}
+}
+
+// withExpired serves h with the expired items that DynamoDB hasn't deleted yet, for debugging.
+func withExpired(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(repository.IncludeExpired(r.Context())))
+	}
+}
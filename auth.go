@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+)
+
+// adminClientKey is the context key of the name of the admin client sending a request.
+type adminClientKey struct{}
+
+// adminClient returns the name of the admin client authenticated by adminAuth.
+func adminClient(ctx context.Context) string {
+	name, _ := ctx.Value(adminClientKey{}).(string)
+	return name
+}
+
+// adminAuth rejects with 401 Unauthorized the requests without the API key of an admin client,
+// keys mapping client names to their API key. The name of the client is added to the request context.
+func adminAuth(keys map[string]string, log *slog.Logger) func(http.Handler) http.Handler {
+	// keys are compared by hash, so that the comparisons take the same time whatever their length
+	hashes := make(map[string][sha256.Size]byte, len(keys))
+	for name, key := range keys {
+		hashes[name] = sha256.Sum256([]byte(key))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sent := sha256.Sum256([]byte(r.Header.Get(apiKeyHeader)))
+			var client string
+			for name, hash := range hashes {
+				// every key is compared, so that the time taken doesn't tell which one matched
+				if subtle.ConstantTimeCompare(sent[:], hash[:]) == 1 {
+					client = name
+				}
+			}
+			if client == "" || r.Header.Get(apiKeyHeader) == "" {
+				log.WarnContext(r.Context(), "unauthorized admin request", "path", r.URL.Path)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminClientKey{}, client)))
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAuth(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := adminAuth(map[string]string{"ops": "ops-key", "ci": "ci-key"}, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, adminClient(r.Context()))
+	}))
+
+	do := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/jobs", nil)
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for key, client := range map[string]string{"ops-key": "ops", "ci-key": "ci"} {
+		rec := do(key)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, client, rec.Body.String())
+	}
+	for _, key := range []string{"", "ops", "ops-key2"} {
+		require.Equal(t, http.StatusUnauthorized, do(key).Code, key)
+	}
+
+	// without admin clients every request is rejected
+	handler = adminAuth(nil, log)(http.NotFoundHandler())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/jobs", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"personalisation-poc/repository/ddb"
+	"strconv"
+)
+
+const consumedCapacityHeader = "X-Consumed-Capacity"
+
+// consumedCapacity records the DynamoDB capacity consumed by each request, and returns it in the X-Consumed-Capacity header,
+// e.g. "read=1.5, write=0". Streamed responses only report the capacity consumed before their first byte,
+// the access log has the whole of it.
+func consumedCapacity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, capacity := ddb.RecordCapacity(r.Context())
+		cw := &capacityWriter{ResponseWriter: w, capacity: capacity}
+		next.ServeHTTP(cw, r.WithContext(ctx))
+		// handlers that write nothing get an implicit 200 once they return
+		cw.setHeader()
+	})
+}
+
+// capacityWriter sets the consumed capacity header right before the response headers are written.
+type capacityWriter struct {
+	http.ResponseWriter
+	capacity *ddb.Capacity
+	written  bool
+}
+
+func (cw *capacityWriter) setHeader() {
+	if cw.written {
+		return
+	}
+	cw.written = true
+	cw.Header().Set(consumedCapacityHeader, formatCapacity(cw.capacity))
+}
+
+func (cw *capacityWriter) WriteHeader(status int) {
+	cw.setHeader()
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *capacityWriter) Write(b []byte) (int, error) {
+	cw.setHeader()
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *capacityWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func formatCapacity(c *ddb.Capacity) string {
+	read, write := c.Units()
+	return fmt.Sprintf("read=%s, write=%s", strconv.FormatFloat(read, 'f', -1, 64), strconv.FormatFloat(write, 'f', -1, 64))
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumedCapacityHeader(t *testing.T) {
+	for name, h := range map[string]http.HandlerFunc{
+		"WriteHeader": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusCreated) },
+		"Write":       func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) },
+		"Implicit":    func(w http.ResponseWriter, r *http.Request) {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			consumedCapacity(h).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			require.Equal(t, "read=0, write=0", rec.Header().Get(consumedCapacityHeader))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"personalisation-poc/repository/consistency"
+)
+
+// runCheck compares the normalized and blob copies of one or every profile, writing the reports to out as NDJSON.
+// It fails when a profile is inconsistent.
+func runCheck(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	id := fs.String("id", "", "check a single profile instead of scanning the table")
+	all := fs.Bool("all", false, "also report consistent profiles")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	repo, err := newRepo(ctx, conf)
+	if err != nil {
+		return err
+	}
+	checker := consistency.New(repo)
+
+	enc := json.NewEncoder(out)
+	var checked, inconsistent int
+	report := func(r consistency.Report) error {
+		checked++
+		if r.Status == consistency.Consistent && !*all {
+			return nil
+		}
+		if r.Status != consistency.Consistent {
+			inconsistent++
+		}
+		return enc.Encode(r)
+	}
+
+	if *id != "" {
+		r, err := checker.Check(ctx, *id)
+		if err != nil {
+			return err
+		}
+		err = report(r)
+	} else {
+		err = checker.CheckAll(ctx, report)
+	}
+	if err != nil {
+		return err
+	}
+
+	if inconsistent > 0 {
+		return fmt.Errorf("%d of %d profiles are inconsistent", inconsistent, checked)
+	}
+	return nil
+}
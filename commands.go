@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"personalisation-poc/model"
+	"personalisation-poc/repository/ddb"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// command is a subcommand of the binary. Its flags are parsed from args, and its output is written to out.
+type command struct {
+	name  string
+	usage string
+	run   func(conf *Config, args []string, out io.Writer) error
+}
+
+// commands are the subcommands of the binary. Without one, the server is started.
+var commands = []command{
+	{name: "serve", usage: "start the HTTP server", run: runServe},
+	{name: "migrate", usage: "create the table or update it to the expected definition", run: runMigrate},
+	{name: "check", usage: "compare the normalized and blob copies of the profiles", run: runCheck},
+	{name: "get-profile", usage: "print a profile", run: runGetProfile},
+	{name: "put-profile", usage: "create or replace a profile read as JSON", run: runPutProfile},
+	{name: "delete-profile", usage: "delete every item of a profile", run: runDeleteProfile},
+	{name: "export", usage: "write the profiles as NDJSON", run: runExport},
+	{name: "import", usage: "write the profiles read as NDJSON", run: runImport},
+	{name: "seed", usage: "write fake profiles", run: runSeed},
+	{name: "loadtest", usage: "send a workload to a running service and report its latencies", run: runLoadTest},
+	{name: "cost", usage: "estimate the capacity and monthly cost of a traffic profile for both designs", run: runCost},
+	{name: "stream", usage: "send the changes to the profiles, read from the table's stream, to sinks", run: runStream},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: main [command] [flags]\n\ncommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.usage)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun main <command> -h for the flags of a command.\n")
+}
+
+func runServe(conf *Config, args []string, _ io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return run(slog.Default(), conf)
+}
+
+// newRepo returns the repository configured by conf. The operator commands don't enable the sliding expiry,
+// so that inspecting a profile doesn't extend it.
+func newRepo(ctx context.Context, conf *Config, opts ...ddb.Option) (*ddb.DB, error) {
+	db, err := newDynamoDB(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]ddb.Option{
+		ddb.WithMaxInFlight(conf.DynamoDB.MaxInFlight),
+		ddb.WithRetryPolicy(retryPolicy(conf.DynamoDB)),
+		ddb.WithUserTTL(conf.TTL.User),
+		ddb.WithSegmentTTL(conf.TTL.Segment),
+		ddb.WithBlobTTL(conf.TTL.Blob),
+	}, opts...)
+	return ddb.NewDB(db.Table(conf.TableName), opts...), nil
+}
+
+// outputFormat is how the commands print profiles.
+type outputFormat string
+
+const (
+	outputJSON  outputFormat = "json"
+	outputTable outputFormat = "table"
+)
+
+func parseOutputFormat(s string) (outputFormat, error) {
+	switch f := outputFormat(s); f {
+	case outputJSON, outputTable:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q, expected json or table", s)
+	}
+}
+
+// writeProfile prints a profile as indented JSON, or as a summary table followed by a table of its segments.
+func writeProfile(w io.Writer, profile *model.Profile, format outputFormat) error {
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(profile)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", profile.ID)
+	fmt.Fprintf(tw, "TAGS\t%s\n", strings.Join(profile.Tags, ", "))
+	fmt.Fprintf(tw, "CREATED AT\t%s\n", formatTime(profile.CreatedAt))
+	fmt.Fprintf(tw, "UPDATED AT\t%s\n", formatTime(profile.UpdatedAt))
+	fmt.Fprintf(tw, "EXPIRES AT\t%s\n", formatTime(profile.ExpiresAt))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(profile.Segments) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEGMENT\tCREATED AT\tEXPIRES AT\tTOP CATEGORIES\tCATEGORIES")
+	for _, seg := range profile.Segments {
+		categories := make([]string, len(seg.Categories))
+		for i, c := range seg.Categories {
+			categories[i] = c.ID + "=" + strconv.FormatFloat(c.Score, 'f', 2, 64)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", seg.Type, formatTime(seg.CreatedAt), formatTime(seg.ExpiresAt),
+			strings.Join(seg.TopCategories, ", "), strings.Join(categories, " "))
+	}
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
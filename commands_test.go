@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/schema"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// memRepo stores both representations in memory.
+type memRepo struct {
+	repository.ProfilesRepo // unimplemented methods panic
+
+	mu          sync.Mutex
+	profiles    map[string]model.Profile
+	blobs       map[string][]byte
+	blobTypes   map[string]string
+	blobExpires map[string]time.Time
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{
+		profiles:    make(map[string]model.Profile),
+		blobs:       make(map[string][]byte),
+		blobTypes:   make(map[string]string),
+		blobExpires: make(map[string]time.Time),
+	}
+}
+
+func (m *memRepo) GetProfileByID(ctx context.Context, id string) (*model.Profile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.profiles[id]
+	if !ok {
+		return nil, repository.ErrNoProfileFound
+	}
+	return &p, nil
+}
+
+func (m *memRepo) UpsertProfile(ctx context.Context, profile model.Profile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.profiles[profile.ID.String()] = profile
+	return nil
+}
+
+func (m *memRepo) GetBlob(ctx context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[id]
+	if !ok {
+		return nil, repository.ErrNoProfileFound
+	}
+	return b, nil
+}
+
+func (m *memRepo) BlobExpiresAt(ctx context.Context, id string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[id]; !ok {
+		return time.Time{}, repository.ErrNoProfileFound
+	}
+	return m.blobExpires[id], nil
+}
+
+func (m *memRepo) UpsertBlob(ctx context.Context, id string, data []byte, opts ...repository.BlobOption) error {
+	var options repository.BlobOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[id] = data
+	m.blobTypes[id] = options.Type
+	m.blobExpires[id] = options.ExpiresAt
+	return nil
+}
+
+func (m *memRepo) ScanBlobIDs(ctx context.Context, blobType string, fn func(id string) error) error {
+	m.mu.Lock()
+	var ids []string
+	for id := range m.blobs {
+		if m.blobTypes[id] == blobType {
+			ids = append(ids, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memRepo) ScanProfileIDs(ctx context.Context, rep repository.Representation, fn func(id string) error) error {
+	if rep == repository.RepresentationBlob {
+		return m.ScanBlobIDs(ctx, "", fn)
+	}
+
+	m.mu.Lock()
+	var ids []string
+	for id := range m.profiles {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := newMemRepo()
+	now := time.Now().UTC().Truncate(time.Second)
+	for range 3 {
+		p := &model.Profile{
+			ID:   uuid.New(),
+			Tags: []string{"sports_fan"},
+			Segments: []model.Segment{{
+				Type:          model.MorningSegmentType,
+				Categories:    []model.Category{{ID: "sports", Score: 0.9}},
+				TopCategories: []string{"sports"},
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}},
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		require.NoError(t, src.UpsertProfile(ctx, *p))
+		require.NoError(t, src.UpsertBlob(ctx, p.ID.String(), []byte(`{"id":"`+p.ID.String()+`"}`), repository.WithBlobExpiresAt(now)))
+	}
+	// a blob of another type, exported on its own
+	order := uuid.NewString()
+	require.NoError(t, src.UpsertBlob(ctx, order, []byte(`{"id":"`+order+`","total":42}`), repository.WithBlobType("order")))
+
+	for _, rep := range []repository.Representation{repository.RepresentationProfile, repository.RepresentationBlob} {
+		t.Run(string(rep), func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := exportProfiles(ctx, src, rep, "", &buf)
+			require.NoError(t, err)
+			require.Equal(t, 3, n)
+			require.Equal(t, 3, strings.Count(buf.String(), "\n"))
+
+			dst := newMemRepo()
+			n, err = importProfiles(ctx, dst, schema.Default(), rep, &buf)
+			require.NoError(t, err)
+			require.Equal(t, 3, n)
+			if rep == repository.RepresentationBlob {
+				require.Len(t, dst.blobs, 3)
+				require.NotContains(t, dst.blobs, order)
+				for id := range dst.blobs {
+					require.Empty(t, dst.blobTypes[id])
+					require.Equal(t, now, dst.blobExpires[id]) // the explicit expiry is kept
+				}
+			} else {
+				require.Equal(t, src.profiles, dst.profiles)
+			}
+		})
+	}
+
+	t.Run("BlobType", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := exportProfiles(ctx, src, repository.RepresentationBlob, "order", &buf)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		schemas := schemaRegistry(t, map[string]string{"order": `{"type":"object","required":["id","total"]}`})
+		dst := newMemRepo()
+		_, err = importProfiles(ctx, dst, schemas, repository.RepresentationBlob, &buf)
+		require.NoError(t, err)
+		require.JSONEq(t, string(src.blobs[order]), string(dst.blobs[order]))
+		require.Equal(t, "order", dst.blobTypes[order])
+		require.True(t, dst.blobExpires[order].IsZero()) // expires after the TTL of blobs
+	})
+
+	t.Run("InvalidBlob", func(t *testing.T) {
+		_, err := importProfiles(ctx, newMemRepo(), schema.Default(), repository.RepresentationBlob, strings.NewReader(`{"data":{"tags":[]}}`))
+		require.ErrorIs(t, err, schema.ErrInvalid)
+		require.ErrorContains(t, err, "record 1")
+
+		// the types without a schema are refused
+		record := `{"id":"` + order + `","type":"order","data":{"id":"` + order + `"}}`
+		_, err = importProfiles(ctx, newMemRepo(), schema.Default(), repository.RepresentationBlob, strings.NewReader(record))
+		require.ErrorIs(t, err, schema.ErrUnknownType)
+	})
+}
+
+// schemaRegistry returns a registry with the schemas of the given types besides the profile one.
+func schemaRegistry(t *testing.T, schemas map[string]string) *schema.Registry {
+	dir := t.TempDir()
+	for name, s := range schemas {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name+".schema.json"), []byte(s), 0o600))
+	}
+	registry, err := schema.New(schema.WithDir(dir))
+	require.NoError(t, err)
+	return registry
+}
+
+func TestWriteProfileTable(t *testing.T) {
+	created := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	profile := &model.Profile{
+		ID:        uuid.MustParse("0b1c9f3e-7f5e-4a53-8c42-1d2f0c7e9a10"),
+		Tags:      []string{"sports_fan", "tech_geek"},
+		CreatedAt: created,
+		UpdatedAt: created,
+		Segments: []model.Segment{{
+			Type:          model.MorningSegmentType,
+			Categories:    []model.Category{{ID: "sports", Score: 0.9}, {ID: "technology", Score: 0.25}},
+			TopCategories: []string{"sports", "technology"},
+			CreatedAt:     created,
+		}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeProfile(&buf, profile, outputTable))
+	require.Equal(t, `ID          0b1c9f3e-7f5e-4a53-8c42-1d2f0c7e9a10
+TAGS        sports_fan, tech_geek
+CREATED AT  2025-01-02T03:04:05Z
+UPDATED AT  2025-01-02T03:04:05Z
+EXPIRES AT  -
+
+SEGMENT  CREATED AT            EXPIRES AT  TOP CATEGORIES      CATEGORIES
+morning  2025-01-02T03:04:05Z  -           sports, technology  sports=0.90 technology=0.25
+`, buf.String())
+}
@@ -1,27 +1,327 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 	"github.com/caarlos0/env/v11"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configFileEnv points to an optional YAML, or TOML when its extension is .toml, config file.
+	// Environment variables take precedence over it.
+	configFileEnv = "CONFIG_FILE"
+
+	// noDefaultsTag is a struct tag that no field declares, used to parse the environment without applying defaults.
+	noDefaultsTag = "envNoDefault"
 )
 
 type Config struct {
-	TableName string         `env:"TABLE_NAME" envDefault:"user_profiles"`
-	Port      string         `env:"PORT" envDefault:":8080"`
-	AWS       AWSConfig      `envPrefix:"AWS_"`
-	DynamoDB  DynamoDBConfig `envPrefix:"DYNAMO_"`
+	TableName string          `env:"TABLE_NAME" envDefault:"user_profiles" yaml:"table_name"`
+	Port      string          `env:"PORT" envDefault:":8080" yaml:"port"`
+	AWS       AWSConfig       `envPrefix:"AWS_" yaml:"aws"`
+	DynamoDB  DynamoDBConfig  `envPrefix:"DYNAMO_" yaml:"dynamodb"`
+	RateLimit RateLimitConfig `envPrefix:"RATE_LIMIT_" yaml:"rate_limit"`
+	Cache     CacheConfig     `envPrefix:"CACHE_" yaml:"cache"`
+	Blob      BlobConfig      `envPrefix:"BLOB_" yaml:"blob"`
+	TTL       TTLConfig       `envPrefix:"TTL_" yaml:"ttl"`
+	Admin     AdminConfig     `envPrefix:"ADMIN_" yaml:"admin"`
 }
 
+// AWSConfig holds the AWS region and optional static credentials.
+// When no static credentials are set, the SDK's default credential chain is used (env, shared config, IAM role...).
 type AWSConfig struct {
-	Region    string `env:"REGION" envDefault:"us-east-1"`
-	AccessKey string `env:"ACCESS_KEY_ID"`
-	SecretKey string `env:"SECRET_ACCESS_KEY"`
+	Region    string `env:"REGION" envDefault:"us-east-1" yaml:"region"`
+	AccessKey string `env:"ACCESS_KEY_ID" yaml:"access_key_id"`
+	SecretKey string `env:"SECRET_ACCESS_KEY" yaml:"secret_access_key"`
+	// AccessKeyFile and SecretKeyFile are read into AccessKey and SecretKey, e.g. for Docker or Kubernetes secrets.
+	AccessKeyFile string `env:"ACCESS_KEY_ID_FILE" yaml:"access_key_id_file"`
+	SecretKeyFile string `env:"SECRET_ACCESS_KEY_FILE" yaml:"secret_access_key_file"`
 }
 
 type DynamoDBConfig struct {
-	Endpoint string `env:"ENDPOINT" envDefault:"http://dynamodb:8000"`
+	// Endpoint overrides the DynamoDB endpoint, e.g. for DynamoDB Local. Leave empty to use AWS.
+	Endpoint string `env:"ENDPOINT" envDefault:"http://dynamodb:8000" yaml:"endpoint"`
+	// MaxInFlight caps the number of concurrent DynamoDB calls. Calls above the cap are shed. Zero disables the cap.
+	MaxInFlight int `env:"MAX_IN_FLIGHT" envDefault:"64" yaml:"max_in_flight"`
+	// MaxAttempts is the number of attempts of a call failing with a throttling or transient error. 1 disables retries.
+	MaxAttempts int `env:"MAX_ATTEMPTS" envDefault:"3" yaml:"max_attempts"`
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff between attempts, randomised with full jitter.
+	RetryBaseDelay time.Duration `env:"RETRY_BASE_DELAY" envDefault:"25ms" yaml:"retry_base_delay"`
+	RetryMaxDelay  time.Duration `env:"RETRY_MAX_DELAY" envDefault:"1s" yaml:"retry_max_delay"`
+	// CallTimeout is the deadline of a call, retries included. Zero disables it.
+	CallTimeout time.Duration `env:"CALL_TIMEOUT" envDefault:"5s" yaml:"call_timeout"`
+}
+
+// RateLimitConfig configures the per-client token buckets.
+// Clients are identified by their API key when it has a quota override, or by their IP address otherwise.
+type RateLimitConfig struct {
+	Enabled bool    `env:"ENABLED" envDefault:"true" yaml:"enabled"`
+	RPS     float64 `env:"RPS" envDefault:"20" yaml:"rps"`
+	Burst   int     `env:"BURST" envDefault:"40" yaml:"burst"`
+	// ClientRPS and ClientBurst override the default quota for specific API keys, e.g. "batch-job:2,web:50".
+	ClientRPS   map[string]float64 `env:"CLIENT_RPS" envKeyValSeparator:":" yaml:"client_rps"`
+	ClientBurst map[string]int     `env:"CLIENT_BURST" envKeyValSeparator:":" yaml:"client_burst"`
+}
+
+// CacheConfig configures the in-process read-through cache in front of DynamoDB.
+type CacheConfig struct {
+	Enabled bool          `env:"ENABLED" envDefault:"false" yaml:"enabled"`
+	Size    int           `env:"SIZE" envDefault:"10000" yaml:"size"` // maximum number of cached profiles
+	TTL     time.Duration `env:"TTL" envDefault:"30s" yaml:"ttl"`
+	// NegativeTTL is how long unknown profile IDs are remembered. Zero disables negative caching.
+	NegativeTTL time.Duration `env:"NEGATIVE_TTL" envDefault:"5s" yaml:"negative_ttl"`
+	// BloomFile is an optional profile export (NDJSON or one ID per line) used to build a bloom filter of known IDs.
+	// Only use it with an up to date export: profiles missing from it are reported as not found.
+	BloomFile   string  `env:"BLOOM_FILE" yaml:"bloom_file"`
+	BloomFPRate float64 `env:"BLOOM_FP_RATE" envDefault:"0.01" yaml:"bloom_fp_rate"`
+	// BloomRebuildInterval is how often the bloom filter is rebuilt from a scan of the table,
+	// so that the profiles created through other instances are found. Zero disables the rebuilds.
+	BloomRebuildInterval time.Duration `env:"BLOOM_REBUILD_INTERVAL" envDefault:"10m" yaml:"bloom_rebuild_interval"`
+}
+
+// BlobConfig configures the validation of the blobs stored through the blob endpoints.
+type BlobConfig struct {
+	// SchemaDir holds a {type}.schema.json JSON Schema per blob type, selected with the type query parameter.
+	// Blobs are validated against the built-in profile schema by default.
+	SchemaDir string `env:"SCHEMA_DIR" yaml:"schema_dir"`
+	// StripUnknown removes the fields not declared by the schema instead of rejecting the blob.
+	StripUnknown bool `env:"STRIP_UNKNOWN" envDefault:"false" yaml:"strip_unknown"`
 }
 
+// TTLConfig sets how long the items live, per item type. Zero disables the expiry of the item type.
+// Profiles and segments written with an expiry date keep it.
+type TTLConfig struct {
+	User    time.Duration `env:"USER" envDefault:"8760h" yaml:"user"`       // 1 year
+	Segment time.Duration `env:"SEGMENT" envDefault:"4380h" yaml:"segment"` // 6 months
+	Blob    time.Duration `env:"BLOB" envDefault:"8760h" yaml:"blob"`       // 1 year
+	// SlidingInterval extends the TTL of the items of a profile when it's read, at most once per interval,
+	// so that active users don't expire. Items written with an expiry date aren't extended. Zero disables sliding expiry.
+	SlidingInterval time.Duration `env:"SLIDING_INTERVAL" envDefault:"0" yaml:"sliding_interval"`
+}
+
+// AdminConfig configures the admin endpoints, which are only served to the clients holding an admin API key.
+type AdminConfig struct {
+	// APIKeys maps the name of each admin client to the API key it sends in the X-API-Key header, e.g. "ops:k1,ci:k2".
+	// The admin endpoints reject every request when it's empty.
+	APIKeys map[string]string `env:"API_KEYS" envKeyValSeparator:":" yaml:"api_keys"`
+	// APIKeysFile is read into APIKeys, one name:key pair per line, e.g. for Docker or Kubernetes secrets.
+	APIKeysFile string `env:"API_KEYS_FILE" yaml:"api_keys_file"`
+	// ConvertRPS caps the number of profiles converted per second by the bulk conversion jobs. Zero disables the cap.
+	ConvertRPS float64 `env:"CONVERT_RPS" envDefault:"50" yaml:"convert_rps"`
+}
+
+// LoadConfig builds the configuration from defaults, the optional config file and the environment,
+// in increasing order of precedence, then validates it.
 func LoadConfig() (*Config, error) {
 	var cfg Config
-	return &cfg, env.Parse(&cfg)
+
+	// defaults only
+	if err := env.ParseWithOptions(&cfg, env.Options{Environment: map[string]string{}}); err != nil {
+		return nil, fmt.Errorf("apply defaults: %w", err)
+	}
+
+	if path := os.Getenv(configFileEnv); path != "" {
+		if err := loadConfigFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// environment only, so that unset variables don't reset values from the config file
+	if err := env.ParseWithOptions(&cfg, env.Options{DefaultValueTagName: noDefaultsTag}); err != nil {
+		return nil, fmt.Errorf("parse environment: %w", err)
+	}
+
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &cfg, nil
+}
+
+func loadConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if data, err = tomlToYAML(data); err != nil {
+			return fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // fail on typos rather than silently ignoring them
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// tomlToYAML converts a TOML document to YAML, so that both formats share the yaml tags and their checks.
+func tomlToYAML(data []byte) ([]byte, error) {
+	var doc map[string]any
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// resolveSecrets reads secrets from the files they point to.
+// A secret set both directly and from a file is rejected rather than silently overridden.
+func (c *Config) resolveSecrets() error {
+	secrets := []struct {
+		name  string
+		path  string
+		value *string
+	}{
+		{"AWS_ACCESS_KEY_ID", c.AWS.AccessKeyFile, &c.AWS.AccessKey},
+		{"AWS_SECRET_ACCESS_KEY", c.AWS.SecretKeyFile, &c.AWS.SecretKey},
+	}
+
+	for _, secret := range secrets {
+		if secret.path == "" {
+			continue
+		}
+		if *secret.value != "" {
+			return fmt.Errorf("set either %s or %s_FILE, not both", secret.name, secret.name)
+		}
+		data, err := os.ReadFile(secret.path)
+		if err != nil {
+			return fmt.Errorf("read %s_FILE: %w", secret.name, err)
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+
+	if c.Admin.APIKeysFile != "" {
+		if len(c.Admin.APIKeys) > 0 {
+			return errors.New("set either ADMIN_API_KEYS or ADMIN_API_KEYS_FILE, not both")
+		}
+		data, err := os.ReadFile(c.Admin.APIKeysFile)
+		if err != nil {
+			return fmt.Errorf("read ADMIN_API_KEYS_FILE: %w", err)
+		}
+		c.Admin.APIKeys = make(map[string]string)
+		for n, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			name, key, ok := strings.Cut(line, ":")
+			if !ok {
+				return fmt.Errorf("read ADMIN_API_KEYS_FILE: line %d is not a name:key pair", n+1)
+			}
+			c.Admin.APIKeys[name] = key
+		}
+	}
+
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.TableName == "" {
+		errs = append(errs, errors.New("TABLE_NAME must not be empty"))
+	}
+	if _, _, err := net.SplitHostPort(c.Port); err != nil {
+		errs = append(errs, fmt.Errorf("PORT must be in the form [host]:port, got %q", c.Port))
+	}
+	if c.AWS.Region == "" {
+		errs = append(errs, errors.New("AWS_REGION must not be empty"))
+	}
+	if (c.AWS.AccessKey == "") != (c.AWS.SecretKey == "") {
+		errs = append(errs, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together, or both left empty to use the default credential chain"))
+	}
+	if c.DynamoDB.Endpoint != "" {
+		u, err := url.Parse(c.DynamoDB.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("DYNAMO_ENDPOINT must be an http(s) URL, got %q", c.DynamoDB.Endpoint))
+		}
+	}
+	if c.DynamoDB.MaxInFlight < 0 {
+		errs = append(errs, errors.New("DYNAMO_MAX_IN_FLIGHT must not be negative"))
+	}
+	if c.DynamoDB.MaxAttempts < 1 {
+		errs = append(errs, errors.New("DYNAMO_MAX_ATTEMPTS must be at least 1"))
+	}
+	if c.DynamoDB.RetryBaseDelay < 0 || c.DynamoDB.RetryMaxDelay < c.DynamoDB.RetryBaseDelay {
+		errs = append(errs, errors.New("DYNAMO_RETRY_BASE_DELAY must not be negative nor greater than DYNAMO_RETRY_MAX_DELAY"))
+	}
+	if c.DynamoDB.CallTimeout < 0 {
+		errs = append(errs, errors.New("DYNAMO_CALL_TIMEOUT must not be negative"))
+	}
+	if c.RateLimit.Enabled {
+		if c.RateLimit.RPS <= 0 {
+			errs = append(errs, errors.New("RATE_LIMIT_RPS must be positive"))
+		}
+		if c.RateLimit.Burst < 1 {
+			errs = append(errs, errors.New("RATE_LIMIT_BURST must be at least 1"))
+		}
+		for client, rps := range c.RateLimit.ClientRPS {
+			if rps <= 0 {
+				errs = append(errs, fmt.Errorf("RATE_LIMIT_CLIENT_RPS for %q must be positive", client))
+			}
+		}
+		for client, burst := range c.RateLimit.ClientBurst {
+			if burst < 1 {
+				errs = append(errs, fmt.Errorf("RATE_LIMIT_CLIENT_BURST for %q must be at least 1", client))
+			}
+		}
+	}
+	if c.TTL.User < 0 || c.TTL.Segment < 0 || c.TTL.Blob < 0 {
+		errs = append(errs, errors.New("TTL_USER, TTL_SEGMENT and TTL_BLOB must not be negative"))
+	}
+	if c.TTL.SlidingInterval < 0 {
+		errs = append(errs, errors.New("TTL_SLIDING_INTERVAL must not be negative"))
+	}
+	if c.Cache.Enabled {
+		if c.Cache.Size < 1 {
+			errs = append(errs, errors.New("CACHE_SIZE must be at least 1"))
+		}
+		if c.Cache.TTL <= 0 {
+			errs = append(errs, errors.New("CACHE_TTL must be positive"))
+		}
+		if c.Cache.NegativeTTL < 0 {
+			errs = append(errs, errors.New("CACHE_NEGATIVE_TTL must not be negative"))
+		}
+		if c.Cache.BloomFile != "" && (c.Cache.BloomFPRate <= 0 || c.Cache.BloomFPRate >= 1) {
+			errs = append(errs, errors.New("CACHE_BLOOM_FP_RATE must be between 0 and 1"))
+		}
+		if c.Cache.BloomRebuildInterval < 0 {
+			errs = append(errs, errors.New("CACHE_BLOOM_REBUILD_INTERVAL must not be negative"))
+		}
+	}
+
+	keys := make(map[string]string, len(c.Admin.APIKeys))
+	for name, key := range c.Admin.APIKeys {
+		if name == "" || key == "" {
+			errs = append(errs, errors.New("ADMIN_API_KEYS names and keys must not be empty"))
+			continue
+		}
+		if other, ok := keys[key]; ok {
+			errs = append(errs, fmt.Errorf("ADMIN_API_KEYS clients %q and %q share a key", min(name, other), max(name, other)))
+		}
+		keys[key] = name
+	}
+	if c.Admin.ConvertRPS < 0 {
+		errs = append(errs, errors.New("ADMIN_CONVERT_RPS must not be negative"))
+	}
+
+	return errors.Join(errs...)
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	secretPath := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte("from-file\n"), 0o600))
+
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+table_name: from_file
+port: ":9090"
+aws:
+  region: eu-west-1
+dynamodb:
+  endpoint: ""
+ttl:
+  segment: 720h
+`), 0o600))
+
+	t.Setenv(configFileEnv, configPath)
+	t.Setenv("PORT", ":7070")
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY_FILE", secretPath)
+
+	conf, err := LoadConfig()
+	require.NoError(t, err)
+
+	require.Equal(t, "from_file", conf.TableName) // file overrides default
+	require.Equal(t, ":7070", conf.Port)          // env overrides file
+	require.Equal(t, "eu-west-1", conf.AWS.Region)
+	require.Empty(t, conf.DynamoDB.Endpoint)
+	require.Equal(t, 64, conf.DynamoDB.MaxInFlight) // default kept
+	require.Equal(t, 720*time.Hour, conf.TTL.Segment)
+	require.Equal(t, 8760*time.Hour, conf.TTL.User)
+	require.Equal(t, "key", conf.AWS.AccessKey)
+	require.Equal(t, "from-file", conf.AWS.SecretKey)
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("PORT", "8080")
+	t.Setenv("RATE_LIMIT_RPS", "0")
+
+	_, err := LoadConfig()
+	require.ErrorContains(t, err, "PORT must be in the form")
+	require.ErrorContains(t, err, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+	require.ErrorContains(t, err, "RATE_LIMIT_RPS must be positive")
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`
+table_name = "from_toml"
+
+[dynamodb]
+max_in_flight = 8
+call_timeout = "2s"
+
+[rate_limit.client_rps]
+batch-job = 2.5
+`), 0o600))
+	t.Setenv(configFileEnv, configPath)
+
+	conf, err := LoadConfig()
+	require.NoError(t, err)
+	require.Equal(t, "from_toml", conf.TableName)
+	require.Equal(t, 8, conf.DynamoDB.MaxInFlight)
+	require.Equal(t, 2*time.Second, conf.DynamoDB.CallTimeout)
+	require.Equal(t, map[string]float64{"batch-job": 2.5}, conf.RateLimit.ClientRPS)
+
+	// unknown keys are rejected as in YAML
+	require.NoError(t, os.WriteFile(configPath, []byte("table_nme = \"typo\"\n"), 0o600))
+	_, err = LoadConfig()
+	require.ErrorContains(t, err, "table_nme")
+}
+
+func TestLoadConfigSecretSetTwice(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretPath, []byte("from-file"), 0o600))
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SECRET_ACCESS_KEY_FILE", secretPath)
+
+	_, err := LoadConfig()
+	require.ErrorContains(t, err, "set either AWS_SECRET_ACCESS_KEY or AWS_SECRET_ACCESS_KEY_FILE, not both")
+}
+
+func TestLoadConfigAdminKeysFile(t *testing.T) {
+	keysPath := filepath.Join(t.TempDir(), "admin-keys")
+	require.NoError(t, os.WriteFile(keysPath, []byte("ops:k1\n\nci:k2\n"), 0o600))
+	t.Setenv("ADMIN_API_KEYS_FILE", keysPath)
+
+	conf, err := LoadConfig()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"ops": "k1", "ci": "k2"}, conf.Admin.APIKeys)
+
+	// clients sharing a key couldn't be told apart
+	require.NoError(t, os.WriteFile(keysPath, []byte("ops:k1\nci:k1\n"), 0o600))
+	_, err = LoadConfig()
+	require.ErrorContains(t, err, `ADMIN_API_KEYS clients "ci" and "ops" share a key`)
+}
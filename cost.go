@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"personalisation-poc/cost"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"text/tabwriter"
+	"time"
+)
+
+// runCost estimates the capacity and monthly cost of a traffic profile for both designs, from the items
+// a generated profile of the given shape is stored as. It doesn't call DynamoDB.
+func runCost(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("cost", flag.ContinueOnError)
+	readProfile := fs.Float64("read-profile", 100, "whole profile reads per second")
+	readSegment := fs.Float64("read-segment", 0, "segment reads per second")
+	readTags := fs.Float64("read-tags", 0, "tag reads per second")
+	write := fs.Float64("write", 10, "profile upserts per second")
+	profiles := fs.Int("profiles", 1_000_000, "number of profiles stored")
+	consistent := fs.Bool("consistent", false, "price strongly consistent reads")
+	segmentTypes := fs.String("segment-types", model.MorningSegmentType+","+model.EveningSegmentType, "comma separated segment types of a profile")
+	categories := fs.Int("categories", 6, "categories per segment")
+	history := fs.Int("history", 1, "versions of each segment type")
+	encoding := fs.String("encoding", string(repository.BlobEncodingMap), "encoding of the blob: map, gzip or zstd")
+	pricing := cost.DefaultPricing
+	fs.Float64Var(&pricing.ReadRequestUnits, "price-read-request", pricing.ReadRequestUnits, "on-demand price per million read request units")
+	fs.Float64Var(&pricing.WriteRequestUnits, "price-write-request", pricing.WriteRequestUnits, "on-demand price per million write request units")
+	fs.Float64Var(&pricing.ReadCapacityUnitHour, "price-rcu-hour", pricing.ReadCapacityUnitHour, "provisioned price per read capacity unit and hour")
+	fs.Float64Var(&pricing.WriteCapacityUnitHour, "price-wcu-hour", pricing.WriteCapacityUnitHour, "provisioned price per write capacity unit and hour")
+	fs.Float64Var(&pricing.StorageGBMonth, "price-storage", pricing.StorageGBMonth, "price per GB stored and month")
+	output := fs.String("o", string(outputTable), "output format: json or table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+	enc, err := repository.ParseBlobEncoding(*encoding)
+	if err != nil {
+		return err
+	}
+
+	// the categories are named like actual ones, for their IDs to weigh the same
+	vocabulary := make([]string, max(*categories, 1))
+	for i := range vocabulary {
+		vocabulary[i] = fmt.Sprintf("category_%03d", i)
+	}
+	types := splitList(*segmentTypes)
+	profile := model.NewGenerator(
+		model.WithSeed(1),
+		model.WithSegmentTypes(types...),
+		model.WithCategories(vocabulary...),
+		model.WithCategoriesPerSegment(len(vocabulary), len(vocabulary)),
+		model.WithHistory(*history, 24*time.Hour),
+	).Profile()
+
+	// the repository only encodes the profile, with the TTLs of the configuration
+	repo, err := newRepo(context.Background(), conf)
+	if err != nil {
+		return err
+	}
+	sizes, err := repo.ItemSizes(*profile, enc)
+	if err != nil {
+		return err
+	}
+
+	estimates := cost.Estimates(sizes, cost.Traffic{
+		ReadProfile:     *readProfile,
+		ReadSegment:     *readSegment,
+		ReadTags:        *readTags,
+		WriteProfile:    *write,
+		Profiles:        *profiles,
+		ConsistentReads: *consistent,
+	}, pricing)
+	return writeEstimates(out, estimates, format)
+}
+
+// writeEstimates prints the estimates of the designs, costs in dollars per month.
+func writeEstimates(w io.Writer, estimates []cost.Estimate, format outputFormat) error {
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(estimates)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESIGN\tRCU/S\tWCU/S\tON-DEMAND\tPROVISIONED\tSTORAGE\tSTORAGE COST")
+	for _, e := range estimates {
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t$%.2f\t$%.2f\t%.2f GB\t$%.2f\n", e.Design, e.ReadUnits, e.WriteUnits,
+			e.OnDemand, e.Provisioned, float64(e.StorageBytes)/(1<<30), e.Storage)
+	}
+	return tw.Flush()
+}
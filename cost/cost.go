@@ -0,0 +1,158 @@
+// Package cost estimates the DynamoDB capacity and monthly cost of a traffic profile, for both the normalized and blob designs,
+// from the sizes of the items the repository actually writes.
+package cost
+
+import (
+	"math"
+	"personalisation-poc/repository/ddb"
+)
+
+const (
+	// hoursPerMonth is the AWS billing month.
+	hoursPerMonth = 730
+	// itemOverhead is the storage DynamoDB bills for every item on top of its size, for indexing.
+	itemOverhead = 100
+
+	readUnitSize  = 4 * 1024
+	writeUnitSize = 1024
+)
+
+// Traffic is the load of the service, in requests per second by access pattern, and the data it holds.
+type Traffic struct {
+	// ReadProfile is the rate of whole profile reads: GET /profile/{id} or GET /blob/{id}.
+	ReadProfile float64 `json:"read_profile"`
+	// ReadSegment is the rate of single segment reads: GET /profile/{id}/segment/{type}... or GET /blob/{id}?path=segments...
+	ReadSegment float64 `json:"read_segment"`
+	// ReadTags is the rate of tag reads: GET /profile/{id}/tags or GET /blob/{id}?path=tags.
+	ReadTags float64 `json:"read_tags"`
+	// WriteProfile is the rate of profile upserts, each writing the whole profile, history included: PUT /profile or PUT /blob.
+	WriteProfile float64 `json:"write_profile"`
+	// Profiles is the number of profiles stored.
+	Profiles int `json:"profiles"`
+	// ConsistentReads selects strongly consistent reads, which cost twice as much as eventually consistent ones.
+	ConsistentReads bool `json:"consistent_reads"`
+}
+
+// Pricing is the price list of a region, in dollars.
+type Pricing struct {
+	// ReadRequestUnits and WriteRequestUnits are the on-demand prices per million request units.
+	ReadRequestUnits  float64 `json:"read_request_units"`
+	WriteRequestUnits float64 `json:"write_request_units"`
+	// ReadCapacityUnitHour and WriteCapacityUnitHour are the provisioned prices per capacity unit and hour.
+	ReadCapacityUnitHour  float64 `json:"read_capacity_unit_hour"`
+	WriteCapacityUnitHour float64 `json:"write_capacity_unit_hour"`
+	// StorageGBMonth is the price per GB stored and month.
+	StorageGBMonth float64 `json:"storage_gb_month"`
+}
+
+// DefaultPricing is the price list of us-east-1 for the Standard table class.
+var DefaultPricing = Pricing{
+	ReadRequestUnits:      0.125,
+	WriteRequestUnits:     0.625,
+	ReadCapacityUnitHour:  0.00013,
+	WriteCapacityUnitHour: 0.00065,
+	StorageGBMonth:        0.25,
+}
+
+// Estimate is the capacity and monthly cost of a design.
+type Estimate struct {
+	Design string `json:"design"`
+	// ReadUnits and WriteUnits are the capacity units consumed per second.
+	ReadUnits  float64 `json:"read_units"`
+	WriteUnits float64 `json:"write_units"`
+	// OnDemand and Provisioned are the monthly costs of the reads and writes, billed on demand
+	// or with exactly the capacity needed provisioned.
+	OnDemand    float64 `json:"on_demand"`
+	Provisioned float64 `json:"provisioned"`
+	// StorageBytes is the size of the table, and Storage its monthly cost.
+	StorageBytes int64   `json:"storage_bytes"`
+	Storage      float64 `json:"storage"`
+}
+
+// Estimates returns the estimates of the normalized and blob designs, for profiles stored as the items of sizes.
+func Estimates(sizes ddb.ItemSizes, t Traffic, p Pricing) []Estimate {
+	return []Estimate{normalized(sizes, t, p), blob(sizes, t, p)}
+}
+
+func normalized(sizes ddb.ItemSizes, t Traffic, p Pricing) Estimate {
+	segments := sum(sizes.Segments)
+	segment := 0
+	if len(sizes.Segments) > 0 {
+		segment = segments / len(sizes.Segments)
+	}
+
+	// a profile read queries the SEG and USER items of the partition, not its blob, a segment read
+	// queries the latest segment, and tags are read from the USER item
+	profileRead := readUnits(sizes.User+segments, t.ConsistentReads)
+	segmentRead := readUnits(segment, t.ConsistentReads)
+	tagsRead := readUnits(sizes.User, t.ConsistentReads)
+	// a write puts the USER item and the SEG item of every segment version, in a batch billed per item
+	write := writeUnits(sizes.User)
+	for _, seg := range sizes.Segments {
+		write += writeUnits(seg)
+	}
+
+	return estimate("normalized", t, p,
+		t.ReadProfile*profileRead+t.ReadSegment*segmentRead+t.ReadTags*tagsRead,
+		t.WriteProfile*write,
+		1+len(sizes.Segments), sizes.User+segments)
+}
+
+func blob(sizes ddb.ItemSizes, t Traffic, p Pricing) Estimate {
+	// projections don't reduce the capacity consumed: every read reads the whole blob,
+	// the head item then its chunks when it's split
+	read := readUnits(sizes.Blob, t.ConsistentReads)
+	if len(sizes.BlobChunks) > 0 {
+		read += readUnits(sum(sizes.BlobChunks), t.ConsistentReads)
+	}
+	// a write puts the chunks and the head item, then deletes the chunks of the previous version,
+	// assumed the same size, a delete being billed for the size of the item deleted
+	write := writeUnits(sizes.Blob)
+	for _, chunk := range sizes.BlobChunks {
+		write += 2 * writeUnits(chunk)
+	}
+
+	return estimate("blob", t, p,
+		(t.ReadProfile+t.ReadSegment+t.ReadTags)*read,
+		t.WriteProfile*write,
+		1+len(sizes.BlobChunks), sizes.Blob+sum(sizes.BlobChunks))
+}
+
+func estimate(design string, t Traffic, p Pricing, readUnits, writeUnits float64, items, itemBytes int) Estimate {
+	const secondsPerMonth = hoursPerMonth * 3600
+	e := Estimate{
+		Design:     design,
+		ReadUnits:  readUnits,
+		WriteUnits: writeUnits,
+		OnDemand: readUnits*secondsPerMonth*p.ReadRequestUnits/1e6 +
+			writeUnits*secondsPerMonth*p.WriteRequestUnits/1e6,
+		// provisioned capacity is bought in whole units
+		Provisioned: math.Ceil(readUnits)*p.ReadCapacityUnitHour*hoursPerMonth +
+			math.Ceil(writeUnits)*p.WriteCapacityUnitHour*hoursPerMonth,
+		StorageBytes: int64(t.Profiles) * int64(itemBytes+items*itemOverhead),
+	}
+	e.Storage = float64(e.StorageBytes) / (1 << 30) * p.StorageGBMonth
+	return e
+}
+
+// readUnits is the capacity consumed by reading size bytes: a unit per 4 KB, half of it for eventually consistent reads.
+func readUnits(size int, consistent bool) float64 {
+	units := math.Ceil(float64(max(size, 1)) / readUnitSize)
+	if !consistent {
+		units /= 2
+	}
+	return units
+}
+
+// writeUnits is the capacity consumed by writing an item of size bytes: a unit per KB.
+func writeUnits(size int) float64 {
+	return math.Ceil(float64(max(size, 1)) / writeUnitSize)
+}
+
+func sum(sizes []int) int {
+	total := 0
+	for _, s := range sizes {
+		total += s
+	}
+	return total
+}
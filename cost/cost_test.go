@@ -0,0 +1,52 @@
+package cost
+
+import (
+	"personalisation-poc/repository/ddb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimates(t *testing.T) {
+	sizes := ddb.ItemSizes{
+		User:       500,
+		Segments:   []int{2000, 2000},
+		Blob:       3000,
+		BlobChunks: []int{5000, 5000},
+	}
+	traffic := Traffic{
+		ReadProfile:  10,
+		ReadTags:     10,
+		WriteProfile: 1,
+		Profiles:     1 << 20,
+	}
+
+	estimates := Estimates(sizes, traffic, DefaultPricing)
+	require.Len(t, estimates, 2)
+	normalized, blob := estimates[0], estimates[1]
+
+	// profile reads query 4500 bytes: 2 units, halved; tag reads get 500 bytes: 1 unit, halved
+	assert.Equal(t, "normalized", normalized.Design)
+	assert.Equal(t, 10*1.0+10*0.5, normalized.ReadUnits)
+	// the USER item is 1 unit, each segment 2
+	assert.Equal(t, 1.0+2*2, normalized.WriteUnits)
+	assert.Equal(t, int64(1<<20)*(4500+3*itemOverhead), normalized.StorageBytes)
+
+	// every read gets the head item (1 unit) and the chunks (3 units), halved;
+	// writes put the head item and the chunks, and delete the previous chunks
+	assert.Equal(t, "blob", blob.Design)
+	assert.Equal(t, 20*2.0, blob.ReadUnits)
+	assert.Equal(t, 3.0+5+5+5+5, blob.WriteUnits)
+
+	secondsPerMonth := float64(hoursPerMonth * 3600)
+	assert.InDelta(t, 15*secondsPerMonth*0.125/1e6+5*secondsPerMonth*0.625/1e6, normalized.OnDemand, 1e-9)
+	assert.InDelta(t, 15*hoursPerMonth*0.00013+5*hoursPerMonth*0.00065, normalized.Provisioned, 1e-9)
+
+	// every version of the segments is written
+	sizes.Segments = append(sizes.Segments, 3000)
+	assert.Equal(t, 1.0+2+2+3, Estimates(sizes, traffic, DefaultPricing)[0].WriteUnits)
+
+	traffic.ConsistentReads = true
+	assert.Equal(t, 10*2.0+10*1.0, Estimates(sizes, traffic, DefaultPricing)[0].ReadUnits)
+}
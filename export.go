@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/schema"
+	"time"
+)
+
+// blobRecord is a line of the blob exports: the document and what's stored along with it.
+type blobRecord struct {
+	ID string `json:"id"`
+	// Type is the type of the blob, empty for profiles.
+	Type string `json:"type,omitempty"`
+	// ExpiresAt is the expiry date the blob was written with, absent when it expires after the TTL of blobs.
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// runExport writes every profile stored as the given representation as NDJSON, one profile per line.
+// The output of the profile representation can be used as the cache's bloom filter export.
+func runExport(conf *Config, args []string, out io.Writer) (err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	rep := fs.String("rep", string(repository.RepresentationProfile), "representation to export: profile or blob")
+	blobType := fs.String("type", schema.DefaultType, "type of the blobs to export")
+	file := fs.String("f", "-", "file to write the profiles to, - for stdout")
+	includeExpired := fs.Bool("include-expired", false, "also export the expired profiles")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	representation, err := repository.ParseRepresentation(*rep)
+	if err != nil {
+		return err
+	}
+	if *blobType == schema.DefaultType {
+		*blobType = "" // the profiles are stored without a type
+	}
+
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		// the buffered writes may only fail when the file is closed
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = f
+	}
+
+	// the expiries derived from the TTLs are left out, so that imports don't turn them into explicit ones
+	ctx := repository.ExplicitExpiries(context.Background())
+	if *includeExpired {
+		ctx = repository.IncludeExpired(ctx)
+	}
+	repo, err := newRepo(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	n, err := exportProfiles(ctx, repo, representation, *blobType, out)
+	slog.Info("exported profiles", "count", n, "representation", representation)
+	return err
+}
+
+// exportProfiles writes the profiles stored as rep to w as NDJSON and returns how many it wrote.
+// Blobs are written as blobRecord, and only the ones of blobType, empty for profiles.
+// Profiles deleted or expired while the table is scanned are skipped.
+func exportProfiles(ctx context.Context, repo repository.ProfilesRepo, rep repository.Representation, blobType string, w io.Writer) (int, error) {
+	var n int
+	var line bytes.Buffer
+	scan := func(fn func(id string) error) error { return repo.ScanProfileIDs(ctx, rep, fn) }
+	if rep == repository.RepresentationBlob {
+		scan = func(fn func(id string) error) error { return repo.ScanBlobIDs(ctx, blobType, fn) }
+	}
+	err := scan(func(id string) error {
+		line.Reset()
+		if rep == repository.RepresentationBlob {
+			data, err := repo.GetBlob(ctx, id)
+			if err != nil {
+				return skipNotFound(err)
+			}
+			expiresAt, err := repo.BlobExpiresAt(ctx, id)
+			if err != nil {
+				return skipNotFound(err)
+			}
+			record := blobRecord{ID: id, Type: blobType, Data: data}
+			if !expiresAt.IsZero() {
+				record.ExpiresAt = &expiresAt
+			}
+			data, err = json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("blob %s: %w", id, err)
+			}
+			line.Write(data)
+		} else {
+			profile, err := repo.GetProfileByID(ctx, id)
+			if err != nil {
+				return skipNotFound(err)
+			}
+			data, err := json.Marshal(profile)
+			if err != nil {
+				return err
+			}
+			line.Write(data)
+		}
+
+		line.WriteByte('\n')
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func skipNotFound(err error) error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// runImport writes the profiles read as NDJSON, as produced by export, to the given representation.
+func runImport(conf *Config, args []string, _ io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	rep := fs.String("rep", string(repository.RepresentationProfile), "representation to import: profile or blob")
+	file := fs.String("f", "-", "file to read the profiles from, - for stdin")
+	encoding := fs.String("encoding", string(repository.BlobEncodingMap), "storage format of the imported blobs: map, gzip or zstd")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	representation, err := repository.ParseRepresentation(*rep)
+	if err != nil {
+		return err
+	}
+	enc, err := repository.ParseBlobEncoding(*encoding)
+	if err != nil {
+		return err
+	}
+
+	schemas, err := schema.New(schema.WithDir(conf.Blob.SchemaDir), schema.WithStripUnknown(conf.Blob.StripUnknown))
+	if err != nil {
+		return err
+	}
+
+	in, err := openInput(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	ctx := context.Background()
+	repo, err := newRepo(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	n, err := importProfiles(ctx, repo, schemas, representation, in, repository.WithBlobEncoding(enc))
+	slog.Info("imported profiles", "count", n, "representation", representation)
+	return err
+}
+
+// importProfiles writes the profiles read from r as rep and returns how many it wrote.
+// Profiles get the defaults of the upsert endpoint, and blobs are validated against the schemas of their type.
+func importProfiles(ctx context.Context, repo repository.ProfilesRepo, schemas *schema.Registry, rep repository.Representation, r io.Reader, opts ...repository.BlobOption) (int, error) {
+	dec := json.NewDecoder(r)
+	var n int
+	for {
+		var data json.RawMessage
+		if err := dec.Decode(&data); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+
+		var err error
+		if rep == repository.RepresentationBlob {
+			err = importBlob(ctx, repo, schemas, data, opts...)
+		} else {
+			err = importProfile(ctx, repo, data)
+		}
+		if err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		n++
+	}
+}
+
+func importProfile(ctx context.Context, repo repository.ProfilesRepo, data []byte) error {
+	var profile model.Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return err
+	}
+	validateUpsertProfile(&profile)
+	return repo.UpsertProfile(ctx, profile)
+}
+
+// importBlob writes the blob of a blobRecord, with its type and explicit expiry.
+func importBlob(ctx context.Context, repo repository.ProfilesRepo, schemas *schema.Registry, data []byte, opts ...repository.BlobOption) error {
+	var record blobRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if len(record.Data) == 0 {
+		return errors.New("blob has no data")
+	}
+	id, blob, err := schemas.Validate(record.Type, record.Data)
+	if err != nil {
+		return err
+	}
+	if record.ID != "" && record.ID != id.String() {
+		return fmt.Errorf("blob id %s doesn't match the record id %s", id, record.ID)
+	}
+
+	opts = append(opts, repository.WithBlobType(record.Type))
+	if record.ExpiresAt != nil {
+		opts = append(opts, repository.WithBlobExpiresAt(*record.ExpiresAt))
+	}
+	return repo.UpsertBlob(ctx, id.String(), blob, opts...)
+}
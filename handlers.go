@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"personalisation-poc/model"
 	"personalisation-poc/repository"
+	"personalisation-poc/schema"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,58 +21,80 @@ const (
 	idQueryParam        = "id"
 	segmentQueryParam   = "segmentType"
 	createdAtQueryParam = "createdAt"
+	encodingQueryParam  = "encoding"
+	pathQueryParam      = "path"
+	typeQueryParam      = "type"
+	asQueryParam        = "as"
 )
 
 func handleUpsertProfile(repo repository.ProfilesRepo, log *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("upserting profile")
+		log.DebugContext(r.Context(), "upserting profile")
 		data, err := io.ReadAll(r.Body)
 		if err != nil {
-			httpError(w, log, err, "error reading body", http.StatusBadRequest)
+			httpError(w, r, log, err, "error reading body", http.StatusBadRequest)
 			return
 		}
-		log.Debug("body", "body", string(data))
+		log.DebugContext(r.Context(), "body", "body", string(data))
 
 		var profile model.Profile
 		if err := json.NewDecoder(bytes.NewReader(data)).Decode(&profile); err != nil {
-			httpError(w, log, err, "error decoding profile", http.StatusBadRequest)
+			httpError(w, r, log, err, "error decoding profile", http.StatusBadRequest)
 			return
 		}
-		log.Debug("profile decoded", "profile", profile)
+		log.DebugContext(r.Context(), "profile decoded", "profile", profile)
 		validateUpsertProfile(&profile)
 
 		if err := repo.UpsertProfile(r.Context(), profile); err != nil {
-			httpError(w, log, err, "error upserting profile", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error upserting profile", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("profile upserted", "profile", profile)
+		log.DebugContext(r.Context(), "profile upserted", "profile", profile)
 		w.WriteHeader(http.StatusCreated)
 	}
 }
 
-func handleUpsertBlob(repo repository.ProfilesRepo, log *slog.Logger) http.HandlerFunc {
+func handleUpsertBlob(repo repository.ProfilesRepo, schemas *schema.Registry, log *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Debug("upserting blob")
+		log.DebugContext(r.Context(), "upserting blob")
+		encoding, err := repository.ParseBlobEncoding(r.URL.Query().Get(encodingQueryParam))
+		if err != nil {
+			httpError(w, r, log, err, "invalid encoding", http.StatusBadRequest)
+			return
+		}
 		data, err := io.ReadAll(r.Body)
 		if err != nil {
-			httpError(w, log, err, "error reading body", http.StatusBadRequest)
+			httpError(w, r, log, err, "error reading body", http.StatusBadRequest)
 			return
 		}
-		var profile model.Profile
-		if err := json.NewDecoder(bytes.NewReader(data)).Decode(&profile); err != nil {
-			httpError(w, log, err, "error decoding profile", http.StatusBadRequest)
+		blobType := blobTypeParam(r)
+		id, data, err := schemas.Validate(blobType, data)
+		switch {
+		case errors.Is(err, schema.ErrInvalid):
+			httpError(w, r, log, err, "invalid blob", http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			httpError(w, r, log, err, "error decoding blob", http.StatusBadRequest)
 			return
 		}
-		if err := repo.UpsertBlob(r.Context(), profile.ID.String(), data); err != nil {
-			httpError(w, log, err, "error upserting blob", http.StatusInternalServerError)
+		if err := repo.UpsertBlob(r.Context(), id.String(), data, repository.WithBlobEncoding(encoding), repository.WithBlobType(blobType)); err != nil {
+			httpError(w, r, log, err, "error upserting blob", http.StatusInternalServerError)
 			return
 		}
 
-		log.Debug("blob upserted", "blob", data)
+		log.DebugContext(r.Context(), "blob upserted", "blob", data)
 		w.WriteHeader(http.StatusCreated)
 	}
 }
 
+// blobTypeParam returns the blob type of the request, empty for profiles so that it matches the blobs stored without a type.
+func blobTypeParam(r *http.Request) string {
+	if blobType := r.URL.Query().Get(typeQueryParam); blobType != schema.DefaultType {
+		return blobType
+	}
+	return ""
+}
+
 func validateUpsertProfile(profile *model.Profile) {
 	if profile.ID == uuid.Nil {
 		profile.ID = uuid.New()
@@ -81,9 +105,6 @@ func validateUpsertProfile(profile *model.Profile) {
 	if profile.UpdatedAt.IsZero() {
 		profile.UpdatedAt = time.Now()
 	}
-	if profile.ExpiresAt.IsZero() {
-		profile.ExpiresAt = time.Now().AddDate(1, 0, 0) // 1 year
-	}
 	profile.Segments = lo.Map(profile.Segments, func(segment model.Segment, _ int) model.Segment {
 		if segment.CreatedAt.IsZero() {
 			segment.CreatedAt = time.Now()
@@ -91,9 +112,6 @@ func validateUpsertProfile(profile *model.Profile) {
 		if segment.UpdatedAt.IsZero() {
 			segment.UpdatedAt = time.Now()
 		}
-		if segment.ExpiresAt.IsZero() {
-			segment.ExpiresAt = time.Now().AddDate(0, 6, 0) // 6 months
-		}
 		return segment
 	})
 }
@@ -102,21 +120,16 @@ func handleGetProfile(repo repository.ProfilesRepo, log *slog.Logger) http.Handl
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		if id == "" {
-			httpError(w, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
 			return
 		}
 
 		profile, err := repo.GetProfileByID(r.Context(), id)
 		if err != nil {
-			if errors.Is(err, repository.ErrNoProfileFound) {
-				httpError(w, log, err, "profile not found", http.StatusNotFound)
-				return
-			}
-			log.Error("error getting profile", "error", err)
-			httpError(w, log, err, "error getting profile", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error getting profile", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("profile retrieved", "profile", profile)
+		log.DebugContext(r.Context(), "profile retrieved", "profile", profile)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(profile)
 	}
@@ -126,13 +139,13 @@ func handleGetSegment(repo repository.ProfilesRepo, log *slog.Logger) http.Handl
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		if id == "" {
-			httpError(w, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
 			return
 		}
 
 		segmentType := r.PathValue(segmentQueryParam)
 		if segmentType == "" {
-			httpError(w, log, errors.New("segmentType is required"), "segmentType is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("segmentType is required"), "segmentType is required", http.StatusBadRequest)
 			return
 		}
 		var createdAt time.Time
@@ -140,7 +153,7 @@ func handleGetSegment(repo repository.ProfilesRepo, log *slog.Logger) http.Handl
 		if timestamp != "" {
 			t, err := time.Parse(time.RFC3339, timestamp)
 			if err != nil {
-				httpError(w, log, err, "failed parsing created at timestamp", http.StatusBadRequest)
+				httpError(w, r, log, err, "failed parsing created at timestamp", http.StatusBadRequest)
 				return
 			}
 			createdAt = t
@@ -148,14 +161,10 @@ func handleGetSegment(repo repository.ProfilesRepo, log *slog.Logger) http.Handl
 
 		segment, err := repo.GetSegment(r.Context(), id, segmentType, createdAt)
 		if err != nil {
-			if errors.Is(err, repository.ErrNoSegmentsFound) {
-				httpError(w, log, err, "segment not found", http.StatusNotFound)
-				return
-			}
-			httpError(w, log, err, "error getting segment", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error getting segment", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("segment retrieved", "segment", segment)
+		log.DebugContext(r.Context(), "segment retrieved", "segment", segment)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(segment)
 	}
@@ -165,22 +174,22 @@ func handleGetCategories(repo repository.ProfilesRepo, log *slog.Logger) http.Ha
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		if id == "" {
-			httpError(w, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
 			return
 		}
 
 		segmentType := r.PathValue(segmentQueryParam)
 		if segmentType == "" {
-			httpError(w, log, errors.New("segmentType is required"), "segmentType is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("segmentType is required"), "segmentType is required", http.StatusBadRequest)
 			return
 		}
 
 		categories, err := repo.GetCategories(r.Context(), id, segmentType)
 		if err != nil {
-			httpError(w, log, err, "error getting categories", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error getting categories", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("categories retrieved", "categories", categories)
+		log.DebugContext(r.Context(), "categories retrieved", "categories", categories)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(categories)
 	}
@@ -190,22 +199,22 @@ func handleGetTopCategories(repo repository.ProfilesRepo, log *slog.Logger) http
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		if id == "" {
-			httpError(w, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
 			return
 		}
 
 		segmentType := r.PathValue(segmentQueryParam)
 		if segmentType == "" {
-			httpError(w, log, errors.New("segmentType is required"), "segmentType is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("segmentType is required"), "segmentType is required", http.StatusBadRequest)
 			return
 		}
 
 		topCategories, err := repo.GetTopCategories(r.Context(), id, segmentType)
 		if err != nil {
-			httpError(w, log, err, "error getting top categories", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error getting top categories", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("top categories retrieved", "topCategories", topCategories)
+		log.DebugContext(r.Context(), "top categories retrieved", "topCategories", topCategories)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(topCategories)
 	}
@@ -215,16 +224,16 @@ func handleGetTags(repo repository.ProfilesRepo, log *slog.Logger) http.HandlerF
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		if id == "" {
-			httpError(w, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
 			return
 		}
 
 		tags, err := repo.GetUserTags(r.Context(), id)
 		if err != nil {
-			httpError(w, log, err, "error getting tags", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error getting tags", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("tags retrieved", "tags", tags)
+		log.DebugContext(r.Context(), "tags retrieved", "tags", tags)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(tags)
 	}
@@ -234,40 +243,143 @@ func handleGetSegmentsFromBlob(repo repository.ProfilesRepo, log *slog.Logger) h
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		if id == "" {
-			httpError(w, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
 			return
 		}
 
 		segments, err := repo.GetRawSegmentsFromBlob(r.Context(), id)
 		if err != nil {
-			httpError(w, log, err, "error getting segments from blob", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error getting segments from blob", http.StatusInternalServerError)
 			return
 		}
-		log.Debug("segments retrieved", "segments", segments)
+		log.DebugContext(r.Context(), "segments retrieved", "segments", segments)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write(segments)
 	}
 }
 
-func httpError(w http.ResponseWriter, log *slog.Logger, err error, errMsg string, status int) {
-	log.Error(errMsg, "error", err)
+// httpError writes err with the status matching its repository error kind, or status for other errors.
+func httpError(w http.ResponseWriter, r *http.Request, log *slog.Logger, err error, errMsg string, status int) {
+	status = errorStatus(err, status)
+	if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+		// throttling or load shedding: ask the client to back off instead of retrying right away
+		w.Header().Set("Retry-After", "1")
+	}
+	if status >= http.StatusInternalServerError {
+		log.ErrorContext(r.Context(), errMsg, "error", err, "status", status)
+	} else {
+		log.WarnContext(r.Context(), errMsg, "error", err, "status", status)
+	}
 	http.Error(w, err.Error(), status)
 }
 
+// errorStatus maps the repository error kinds to HTTP status codes.
+func errorStatus(err error, status int) int {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, repository.ErrThrottled):
+		return http.StatusTooManyRequests
+	case errors.Is(err, repository.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return status
+	}
+}
+
+func handlePatchBlob(repo repository.ProfilesRepo, schemas *schema.Registry, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if id == "" {
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			return
+		}
+
+		var ops []repository.PatchOperation
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			httpError(w, r, log, err, "error decoding patch", http.StatusBadRequest)
+			return
+		}
+		blobType := blobTypeParam(r)
+		ops, err := schemas.ValidatePatch(blobType, ops)
+		switch {
+		case errors.Is(err, schema.ErrInvalid):
+			httpError(w, r, log, err, "invalid patch", http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			httpError(w, r, log, err, "error decoding patch", http.StatusBadRequest)
+			return
+		}
+
+		if err := repo.PatchBlob(r.Context(), id, ops, repository.WithBlobType(blobType)); err != nil {
+			httpError(w, r, log, err, "error patching blob", http.StatusInternalServerError)
+			return
+		}
+
+		log.DebugContext(r.Context(), "blob patched", "operations", len(ops))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
 func handleGetBlob(repo repository.ProfilesRepo, log *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		if id == "" {
-			httpError(w, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			httpError(w, r, log, errors.New("id is required"), "id is required", http.StatusBadRequest)
+			return
+		}
+
+		if paths := r.URL.Query()[pathQueryParam]; len(paths) > 0 {
+			data, err := repo.GetBlobPaths(r.Context(), id, paths)
+			if errors.Is(err, repository.ErrInvalidPath) {
+				httpError(w, r, log, err, "invalid blob paths", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				httpError(w, r, log, err, "error getting blob paths", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
 			return
 		}
 
 		blob, err := repo.GetBlob(r.Context(), id)
 		if err != nil {
-			httpError(w, log, err, "error getting blob", http.StatusInternalServerError)
+			httpError(w, r, log, err, "error getting blob", http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(blob)
+
+		switch as := r.URL.Query().Get(asQueryParam); as {
+		case "":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(blob)
+		case "profile":
+			// same shape as the profile endpoints, so clients can switch between them
+			var profile model.Profile
+			if err := json.Unmarshal(blob, &profile); err != nil {
+				httpError(w, r, log, err, "blob is not a profile", http.StatusUnprocessableEntity)
+				return
+			}
+			// filled in as by the profile endpoints, the ID from the key and the expiry from the TTL of the blob
+			if profile.ID == uuid.Nil {
+				profile.ID, _ = uuid.Parse(id)
+			}
+			if profile.ExpiresAt.IsZero() {
+				if profile.ExpiresAt, err = repo.BlobExpiresAt(r.Context(), id); err != nil {
+					httpError(w, r, log, err, "error getting blob expiry", http.StatusInternalServerError)
+					return
+				}
+			}
+			validateUpsertProfile(&profile)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(profile)
+		default:
+			httpError(w, r, log, fmt.Errorf("unknown representation %q", as), "invalid as parameter", http.StatusBadRequest)
+		}
 	}
 }
@@ -10,14 +10,15 @@ import (
 	"net/http"
 	"os"
 	"personalisation-poc/model"
+	"personalisation-poc/repository/consistency"
 	"personalisation-poc/repository/ddb"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
 	"github.com/guregu/dynamo/v2"
 	"github.com/ory/dockertest/v3"
@@ -30,6 +31,7 @@ const (
 	awsRegion          = "us-east-1"
 	awsAccessKeyID     = "dummy"
 	awsSecretAccessKey = "dummy"
+	testAdminKey       = "test-admin-key"
 )
 
 type Suite struct {
@@ -114,44 +116,10 @@ func (s *Suite) startDynamoDB() {
 }
 
 func (s *Suite) initDynamoDBTable() {
-	// Create the user_profiles table as defined in docker-compose.yml
-	createTableInput := &dynamodb.CreateTableInput{
-		TableName: aws.String(tableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{
-				AttributeName: aws.String("pk"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-			{
-				AttributeName: aws.String("sk"),
-				AttributeType: types.ScalarAttributeTypeS,
-			},
-		},
-		KeySchema: []types.KeySchemaElement{
-			{
-				AttributeName: aws.String("pk"),
-				KeyType:       types.KeyTypeHash,
-			},
-			{
-				AttributeName: aws.String("sk"),
-				KeyType:       types.KeyTypeRange,
-			},
-		},
-		ProvisionedThroughput: &types.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(10),
-			WriteCapacityUnits: aws.Int64(5),
-		},
-	}
-
-	_, err := s.dynamoClient.CreateTable(context.Background(), createTableInput)
-	s.Require().NoError(err, "Failed to create DynamoDB table")
-
-	// Wait for table to be active
-	waiter := dynamodb.NewTableExistsWaiter(s.dynamoClient)
-	err = waiter.Wait(context.Background(), &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
-	}, 2*time.Minute)
-	s.Require().NoError(err, "Table did not become active in time")
+	// Create the table with the same migrations as the migrate command
+	db := dynamo.NewFromIface(s.dynamoClient)
+	_, err := ddb.Migrate(context.Background(), db, tableName)
+	s.Require().NoError(err, "Failed to migrate DynamoDB table")
 }
 
 func (s *Suite) startApplication() {
@@ -168,7 +136,7 @@ func (s *Suite) startApplication() {
 		o.BaseEndpoint = aws.String(dynamoEndpoint)
 		o.Region = awsRegion
 		o.Credentials = credentials.NewStaticCredentialsProvider(awsAccessKeyID, awsSecretAccessKey, "")
-	})
+	}, ddb.ReturnConsumedCapacity)
 	table := db.Table(tableName)
 
 	// Create repository
@@ -178,12 +146,12 @@ func (s *Suite) startApplication() {
 	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 
 	// Create server using newServer function like in main.go
-	s.server = newServer(repo, log)
+	s.server = newServer(repo, log, withAdmin(repo, AdminConfig{APIKeys: map[string]string{"test": testAdminKey}}))
 
 	// Start HTTP server on a random available port
 	s.httpServer = &http.Server{
 		Addr:    ":0", // Let OS choose available port
-		Handler: s.server.router,
+		Handler: s.server,
 	}
 
 	// Start the server and get the actual port
@@ -203,6 +171,16 @@ func (s *Suite) startApplication() {
 	s.baseURL = fmt.Sprintf("http://localhost:%d/api/v1", port)
 }
 
+// adminRequest sends a request to an admin endpoint with the admin API key of the tests.
+func (s *Suite) adminRequest(t *testing.T, method, path string) *http.Response {
+	req, err := http.NewRequest(method, s.baseURL+path, nil)
+	require.NoError(t, err)
+	req.Header.Set(apiKeyHeader, testAdminKey)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
 func (s *Suite) waitForApplication() {
 	// Wait for application to be ready
 	maxRetries := 30
@@ -331,7 +309,21 @@ func (s *Suite) TestProfile() {
 		require.ElementsMatch(t, []string{"test_tag", "profile_test"}, tags)
 	})
 
-	// Test 5: Test non-existent profile
+	// Test 5: Request ID is propagated back to the caller
+	s.T().Run("RequestID", func(t *testing.T) {
+		req, err := http.NewRequest("GET", s.baseURL+"/profile/"+profileID.String(), nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Request-ID", "test-request-id")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "test-request-id", resp.Header.Get("X-Request-ID"))
+	})
+
+	// Test 6: Test non-existent profile
 	s.T().Run("GetNonExistentProfile", func(t *testing.T) {
 		resp, err := http.Get(s.baseURL + "/profile/" + uuid.New().String())
 		require.NoError(t, err)
@@ -339,6 +331,72 @@ func (s *Suite) TestProfile() {
 
 		require.Equal(t, http.StatusNotFound, resp.StatusCode)
 	})
+
+	// Test 7: Missing items are reported as not found by every endpoint
+	s.T().Run("GetNonExistentResources", func(t *testing.T) {
+		unknownID := uuid.New().String()
+		for _, path := range []string{
+			"/profile/" + unknownID + "/tags",
+			"/profile/" + profileID.String() + "/segment/unknown",
+			"/profile/" + profileID.String() + "/segment/unknown/categories",
+			"/profile/" + profileID.String() + "/segment/unknown/topcategories",
+			"/blob/" + unknownID,
+		} {
+			resp, err := http.Get(s.baseURL + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+		}
+	})
+
+	// Test 8: The latest segment is returned when no creation time is given
+	s.T().Run("GetLatestSegment", func(t *testing.T) {
+		resp, err := http.Get(s.baseURL + "/profile/" + profileID.String() + "/segment/morning_categories/topcategories")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var topCategories []string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&topCategories))
+		require.ElementsMatch(t, []string{"news", "sports"}, topCategories)
+	})
+
+	// Test 9: Expired profiles are hidden until DynamoDB deletes them, except from the admin endpoints
+	s.T().Run("ExpiredProfile", func(t *testing.T) {
+		expired := model.Profile{ID: uuid.New(), Tags: []string{"expired"}, ExpiresAt: time.Now().Add(-time.Hour)}
+		profileJSON, err := json.Marshal(expired)
+		require.NoError(t, err)
+		req, err := http.NewRequest("PUT", s.baseURL+"/profile", bytes.NewReader(profileJSON))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err = http.Get(s.baseURL + "/profile/" + expired.ID.String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp, err = http.Get(s.baseURL + "/admin/profile/" + expired.ID.String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = s.adminRequest(t, "GET", "/admin/profile/"+expired.ID.String())
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	// Test 10: The DynamoDB capacity consumed by a request is returned with its response
+	s.T().Run("ConsumedCapacity", func(t *testing.T) {
+		resp, err := http.Get(s.baseURL + "/profile/" + profileID.String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Regexp(t, `^read=[0-9.]+, write=0$`, resp.Header.Get(consumedCapacityHeader))
+		require.NotEqual(t, "read=0, write=0", resp.Header.Get(consumedCapacityHeader))
+	})
 }
 
 func (s *Suite) TestBlob() {
@@ -376,7 +434,22 @@ func (s *Suite) TestBlob() {
 		require.Equal(t, http.StatusCreated, resp.StatusCode)
 	})
 
-	// Test 2: Get blob
+	// Test 2: Blobs are validated against the profile schema
+	s.T().Run("InvalidBlob", func(t *testing.T) {
+		for name, body := range map[string]string{
+			"zero id":       `{"id": "00000000-0000-0000-0000-000000000000"}`,
+			"unknown field": `{"id": "` + uuid.NewString() + `", "password": "hunter2"}`,
+		} {
+			req, err := http.NewRequest("PUT", s.baseURL+"/blob", strings.NewReader(body))
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, name)
+		}
+	})
+
+	// Test 3: Get blob
 	s.T().Run("GetBlob", func(t *testing.T) {
 		resp, err := http.Get(s.baseURL + "/blob/" + blobProfileID.String())
 		require.NoError(t, err)
@@ -384,20 +457,40 @@ func (s *Suite) TestBlob() {
 
 		require.Equal(t, http.StatusOK, resp.StatusCode)
 
-		var blobData []byte
-		err = json.NewDecoder(resp.Body).Decode(&blobData)
-		require.NoError(t, err)
+		require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
 
-		// Verify we can decode the blob back to the original profile
+		// The body is the stored document itself
 		var retrievedProfile model.Profile
-		err = json.Unmarshal(blobData, &retrievedProfile)
+		err = json.NewDecoder(resp.Body).Decode(&retrievedProfile)
 		require.NoError(t, err)
 
 		require.Equal(t, blobProfileID, retrievedProfile.ID)
 		require.Equal(t, testBlobProfile.Tags, retrievedProfile.Tags)
+		require.True(t, retrievedProfile.CreatedAt.IsZero())
+	})
+
+	// Test 4: Get blob as a profile, with the profile endpoint defaults
+	s.T().Run("GetBlobAsProfile", func(t *testing.T) {
+		resp, err := http.Get(s.baseURL + "/blob/" + blobProfileID.String() + "?as=profile")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var profile model.Profile
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&profile))
+		require.Equal(t, blobProfileID, profile.ID)
+		require.False(t, profile.CreatedAt.IsZero()) // defaulted as by the profile endpoints
+		require.False(t, profile.UpdatedAt.IsZero())
+		require.False(t, profile.Segments[0].CreatedAt.IsZero())
+		require.True(t, profile.ExpiresAt.IsZero()) // the blobs of the suite don't expire
+
+		resp, err = http.Get(s.baseURL + "/blob/" + blobProfileID.String() + "?as=xml")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
 	})
 
-	// Test 3: Get segments from blob
+	// Test 5: Get segments from blob
 	s.T().Run("GetSegmentsFromBlob", func(t *testing.T) {
 		resp, err := http.Get(s.baseURL + "/blob/" + blobProfileID.String() + "/segments")
 		require.NoError(t, err)
@@ -414,4 +507,186 @@ func (s *Suite) TestBlob() {
 		require.Len(t, segments[0].Categories, 2)
 		require.Equal(t, "finance", segments[0].Categories[0].ID)
 	})
+
+	// Test 6: Project arbitrary paths of the blob
+	s.T().Run("GetBlobPaths", func(t *testing.T) {
+		resp, err := http.Get(s.baseURL + "/blob/" + blobProfileID.String() + "?path=tags&path=segments[0].top_categories")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var projected model.Profile
+		err = json.NewDecoder(resp.Body).Decode(&projected)
+		require.NoError(t, err)
+		require.Equal(t, testBlobProfile.Tags, projected.Tags)
+		require.Len(t, projected.Segments, 1)
+		require.Equal(t, []string{"finance", "health"}, projected.Segments[0].TopCategories)
+		require.Empty(t, projected.Segments[0].Categories)
+
+		resp, err = http.Get(s.baseURL + "/blob/" + blobProfileID.String() + "?path=segments[x]")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	// Test 7: Patch the blob in place
+	s.T().Run("PatchBlob", func(t *testing.T) {
+		patch := `[
+			{"op": "test", "path": "/segments/0/type", "value": "blob_categories"},
+			{"op": "replace", "path": "/segments/0/top_categories", "value": ["health"]},
+			{"op": "add", "path": "/tags/-", "value": "vip"}
+		]`
+		req, err := http.NewRequest("PATCH", s.baseURL+"/blob/"+blobProfileID.String(), strings.NewReader(patch))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json-patch+json")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = http.Get(s.baseURL + "/blob/" + blobProfileID.String() + "?path=tags&path=segments[0].top_categories")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var patched model.Profile
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&patched))
+		require.Equal(t, []string{"blob_tag", "test_blob", "vip"}, patched.Tags)
+		require.Equal(t, []string{"health"}, patched.Segments[0].TopCategories)
+
+		// replacing a missing path leaves the blob unchanged
+		patch = `[
+			{"op": "add", "path": "/tags/-", "value": "lost"},
+			{"op": "replace", "path": "/segments/3/type", "value": "missing"}
+		]`
+		req, err = http.NewRequest("PATCH", s.baseURL+"/blob/"+blobProfileID.String(), strings.NewReader(patch))
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		// patching a missing blob is not found, and an empty patch is invalid
+		patch = `[{"op": "add", "path": "/tags/-", "value": "vip"}]`
+		req, err = http.NewRequest("PATCH", s.baseURL+"/blob/"+uuid.NewString(), strings.NewReader(patch))
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		req, err = http.NewRequest("PATCH", s.baseURL+"/blob/"+blobProfileID.String(), strings.NewReader(`[]`))
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		// patches are validated against the schema of the blob
+		for _, patch := range []string{
+			`[{"op": "replace", "path": "/id", "value": "` + uuid.NewString() + `"}]`,
+			`[{"op": "add", "path": "/password", "value": "hunter2"}]`,
+			`[{"op": "replace", "path": "/segments/0/categories/0/score", "value": "high"}]`,
+		} {
+			req, err = http.NewRequest("PATCH", s.baseURL+"/blob/"+blobProfileID.String(), strings.NewReader(patch))
+			require.NoError(t, err)
+			resp, err = http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, patch)
+		}
+
+		// restore the blob for the following tests
+		blobJSON, err := json.Marshal(testBlobProfile)
+		require.NoError(t, err)
+		req, err = http.NewRequest("PUT", s.baseURL+"/blob", bytes.NewReader(blobJSON))
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	})
+
+	// Test 8: Compressed blobs are transparently decoded
+	s.T().Run("CompressedBlob", func(t *testing.T) {
+		compressedProfile := testBlobProfile
+		compressedProfile.ID = uuid.New()
+		blobJSON, err := json.Marshal(compressedProfile)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("PUT", s.baseURL+"/blob?encoding=zstd", bytes.NewReader(blobJSON))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		resp, err = http.Get(s.baseURL + "/blob/" + compressedProfile.ID.String() + "/segments")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var segments []model.Segment
+		err = json.NewDecoder(resp.Body).Decode(&segments)
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		require.Equal(t, "blob_categories", segments[0].Type)
+	})
+
+	// Test 9: Materialise the blob as a normalized profile
+	s.T().Run("ConvertBlobToProfile", func(t *testing.T) {
+		// admin endpoints require an admin API key
+		resp, err := http.Post(s.baseURL+"/admin/convert/blob-to-profile/"+blobProfileID.String(), "", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = s.adminRequest(t, "POST", "/admin/convert/blob-to-profile/"+blobProfileID.String())
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		resp, err = http.Get(s.baseURL + "/profile/" + blobProfileID.String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var profile model.Profile
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&profile))
+		require.ElementsMatch(t, testBlobProfile.Tags, profile.Tags)
+		require.Len(t, profile.Segments, 1)
+		require.Equal(t, "blob_categories", profile.Segments[0].Type)
+	})
+
+	// Test 10: Both copies are compared field by field
+	s.T().Run("ConsistencyCheck", func(t *testing.T) {
+		check := func() consistency.Report {
+			resp := s.adminRequest(t, "GET", "/admin/consistency/"+blobProfileID.String())
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var report consistency.Report
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+			return report
+		}
+		require.Equal(t, consistency.Consistent, check().Status)
+
+		resp, err := http.Get(s.baseURL + "/admin/consistency/" + blobProfileID.String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		patch := `[{"op": "replace", "path": "/segments/0/categories/0/score", "value": 0.1}]`
+		req, err := http.NewRequest("PATCH", s.baseURL+"/blob/"+blobProfileID.String(), strings.NewReader(patch))
+		require.NoError(t, err)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+		report := check()
+		require.Equal(t, consistency.Drifted, report.Status)
+		require.Len(t, report.Diffs, 1)
+		require.Equal(t, "segments[blob_categories].categories[finance].score", report.Diffs[0].Path)
+	})
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"personalisation-poc/loadtest"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// runLoadTest replays a JSONL file of requests, or a synthetic workload, against a running service and prints the results.
+// Interrupting it stops the run and prints the results so far.
+func runLoadTest(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := fs.String("target", "http://localhost"+conf.Port, "base URL of the service")
+	file := fs.String("f", "", "JSONL file of requests to replay, each with a method, a path and an optional body")
+	loop := fs.Bool("loop", false, "replay the requests over and over")
+	idsFile := fs.String("ids", "", "file of profile IDs for a synthetic workload, as printed by seed or export")
+	pattern := fs.String("pattern", string(loadtest.PatternBoth), "access pattern of the synthetic workload: profile, blob or both")
+	writeRatio := fs.Float64("write-ratio", 0.1, "share of writes in the synthetic workload")
+	seed := fs.Int64("seed", 0, "seed of the synthetic workload (random when 0)")
+	rate := fs.Float64("rate", 0, "requests per second (as fast as the concurrency allows when 0)")
+	concurrency := fs.Int("concurrency", 10, "maximum number of requests in flight")
+	duration := fs.Duration("duration", 0, "duration of the run")
+	n := fs.Int("n", 0, "number of requests to send")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	apiKey := fs.String("api-key", "", "X-API-Key of the requests, with a quota of RATE_LIMIT_CLIENT_RPS high enough for the rate")
+	output := fs.String("o", string(outputTable), "output format: json or table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	var src loadtest.Source
+	switch {
+	case (*file == "") == (*idsFile == ""):
+		return errors.New("either -f or -ids is required")
+	case *file != "":
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		reqs, err := loadtest.ReadRequests(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read requests: %w", err)
+		}
+		src = loadtest.Replay(reqs, *loop)
+	default:
+		p, err := loadtest.ParsePattern(*pattern)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(*idsFile)
+		if err != nil {
+			return err
+		}
+		ids, err := loadtest.ReadIDs(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read profile IDs: %w", err)
+		}
+		opts := []loadtest.MixOption{loadtest.WithWriteRatio(*writeRatio)}
+		if *seed != 0 {
+			opts = append(opts, loadtest.WithSeed(*seed))
+		}
+		if src, err = loadtest.NewMix(p, ids, opts...); err != nil {
+			return err
+		}
+	}
+	if (*idsFile != "" || *loop) && *duration <= 0 && *n <= 0 {
+		return errors.New("an endless workload needs -duration or -n")
+	}
+
+	var headers map[string]string
+	if *apiKey != "" {
+		headers = map[string]string{apiKeyHeader: *apiKey}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	report, err := loadtest.Run(ctx, *target, src,
+		loadtest.WithRate(*rate),
+		loadtest.WithConcurrency(*concurrency),
+		loadtest.WithDuration(*duration),
+		loadtest.WithMaxRequests(*n),
+		loadtest.WithHTTPClient(&http.Client{Timeout: *timeout}),
+		loadtest.WithHeaders(headers),
+	)
+	if err != nil {
+		return err
+	}
+	return writeLoadTestReport(out, report, format)
+}
+
+// writeLoadTestReport prints the summaries of the requests by name, followed by their total.
+func writeLoadTestReport(w io.Writer, report *loadtest.Report, format outputFormat) error {
+	summaries := append(report.ByName(), report.Total())
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summaries)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST\tCOUNT\tERROR RATE\tRPS\tMEAN\tP50\tP90\tP99\tMAX\tSTATUS CODES")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%.1f\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Requests, 100*s.ErrorRate, s.Throughput,
+			formatLatency(s.Mean), formatLatency(s.P50), formatLatency(s.P90), formatLatency(s.P99), formatLatency(s.Max),
+			formatStatusCodes(s.StatusCodes))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d requests in %s\n", report.Total().Requests, report.Duration.Round(time.Millisecond))
+	return err
+}
+
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
+
+// formatStatusCodes lists the status codes by count, e.g. "200:95 404:5", transport errors as "error".
+func formatStatusCodes(codes map[int]int) string {
+	statuses := make([]int, 0, len(codes))
+	for status := range codes {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	parts := make([]string, len(statuses))
+	for i, status := range statuses {
+		name := fmt.Sprint(status)
+		if status == 0 {
+			name = "error"
+		}
+		parts[i] = fmt.Sprintf("%s:%d", name, codes[status])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,262 @@
+// Package loadtest sends a workload of HTTP requests to the service at a fixed rate or concurrency,
+// and reports the latency percentiles, error rates and throughput of every kind of request.
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request is an HTTP request of the workload, as read from a JSONL file.
+type Request struct {
+	// Name groups the results of similar requests. By default it's the method and the path,
+	// without its query and with the IDs replaced by {id}.
+	Name   string          `json:"name,omitempty"`
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	// Headers are set on the request, over the ones of WithHeaders.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+var idPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+func (r Request) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	path, _, _ := strings.Cut(r.Path, "?")
+	return r.Method + " " + idPattern.ReplaceAllString(path, "{id}")
+}
+
+// Source produces the requests of a run. Next returns false once there are no requests left.
+// It's only called by one goroutine at a time.
+type Source interface {
+	Next() (Request, bool)
+}
+
+// ReadRequests reads a JSONL file of requests, skipping the empty lines.
+func ReadRequests(r io.Reader) ([]Request, error) {
+	var reqs []Request
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // bodies can be long lines
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var req Request
+		if err := json.Unmarshal(text, &req); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if req.Method == "" || !strings.HasPrefix(req.Path, "/") {
+			return nil, fmt.Errorf("line %d: method and absolute path are required", line)
+		}
+		reqs = append(reqs, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// Replay returns a Source sending reqs in order, over and over when loop is set.
+func Replay(reqs []Request, loop bool) Source {
+	return &replay{reqs: reqs, loop: loop}
+}
+
+type replay struct {
+	reqs []Request
+	loop bool
+	next int
+}
+
+func (r *replay) Next() (Request, bool) {
+	if r.next == len(r.reqs) {
+		if !r.loop || len(r.reqs) == 0 {
+			return Request{}, false
+		}
+		r.next = 0
+	}
+	r.next++
+	return r.reqs[r.next-1], true
+}
+
+type Option func(*runner)
+
+// WithRate sends the requests at a fixed rate, in requests per second, instead of as fast as the workers allow.
+// Latencies are measured from the time a request was due, so that a saturated service doesn't hide its queueing delay.
+func WithRate(rps float64) Option {
+	return func(r *runner) {
+		r.rate = rps
+	}
+}
+
+// WithConcurrency sets the number of requests in flight at most. By default it's 10.
+func WithConcurrency(n int) Option {
+	return func(r *runner) {
+		if n > 0 {
+			r.concurrency = n
+		}
+	}
+}
+
+// WithDuration stops the run after d. By default it runs until the source is exhausted.
+func WithDuration(d time.Duration) Option {
+	return func(r *runner) {
+		r.duration = d
+	}
+}
+
+// WithMaxRequests stops the run after n requests. By default it runs until the source is exhausted.
+func WithMaxRequests(n int) Option {
+	return func(r *runner) {
+		r.maxRequests = n
+	}
+}
+
+// WithHTTPClient sets the client sending the requests, e.g. to set a timeout. By default it's http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *runner) {
+		r.client = client
+	}
+}
+
+// WithHeaders sets headers on every request, e.g. the X-API-Key identifying the client to the rate limiter.
+func WithHeaders(headers map[string]string) Option {
+	return func(r *runner) {
+		r.headers = headers
+	}
+}
+
+type runner struct {
+	target      string
+	client      *http.Client
+	headers     map[string]string
+	rate        float64
+	concurrency int
+	duration    time.Duration
+	maxRequests int
+}
+
+type job struct {
+	req Request
+	due time.Time
+}
+
+// Run sends the requests of src to the service at target, e.g. http://localhost:8080, and reports their results.
+// It stops when src is exhausted, a limit set by the options is reached, or ctx is done:
+// endless sources, such as a looping replay or a Mix, need WithDuration or WithMaxRequests.
+func Run(ctx context.Context, target string, src Source, opts ...Option) (*Report, error) {
+	r := &runner{
+		target:      strings.TrimSuffix(target, "/"),
+		client:      http.DefaultClient,
+		concurrency: 10,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.duration)
+		defer cancel()
+	}
+
+	report := newReport()
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for range r.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				status, err := r.send(ctx, j.req)
+				if ctx.Err() != nil && err != nil {
+					continue // cut short by the end of the run
+				}
+				report.record(j.req.name(), time.Since(j.due), status, err)
+			}
+		}()
+	}
+
+	start := time.Now()
+	r.produce(ctx, src, jobs, start)
+	close(jobs)
+	wg.Wait()
+	report.Duration = time.Since(start)
+
+	return report, nil
+}
+
+// produce hands the requests of src to the workers, on schedule when a rate is set.
+func (r *runner) produce(ctx context.Context, src Source, jobs chan<- job, start time.Time) {
+	var interval time.Duration
+	if r.rate > 0 {
+		interval = time.Duration(float64(time.Second) / r.rate)
+	}
+
+	for n := 0; r.maxRequests <= 0 || n < r.maxRequests; n++ {
+		req, ok := src.Next()
+		if !ok {
+			return
+		}
+
+		due := time.Now()
+		if interval > 0 {
+			due = start.Add(time.Duration(n) * interval)
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		select {
+		case jobs <- job{req: req, due: due}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *runner) send(ctx context.Context, req Request) (int, error) {
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, r.target+req.Path, body)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range r.headers {
+		httpReq.Header.Set(name, value)
+	}
+	for name, value := range req.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// read the whole response, it's part of the latency and lets the connection be reused
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
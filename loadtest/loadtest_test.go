@@ -0,0 +1,136 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	reqs, err := ReadRequests(strings.NewReader(`
+{"method":"GET","path":"/api/v1/profile/8e0a3d84-5d36-4d42-9a5e-1a0d8f1c2b3a"}
+{"method":"GET","path":"/api/v1/profile/missing"}
+
+{"method":"PUT","path":"/api/v1/blob","body":{"id":"8e0a3d84-5d36-4d42-9a5e-1a0d8f1c2b3a"}}
+`))
+	require.NoError(t, err)
+	require.Len(t, reqs, 3)
+
+	t.Run("Replay", func(t *testing.T) {
+		received.Store(0)
+		report, err := Run(context.Background(), srv.URL, Replay(reqs, false), WithConcurrency(2))
+		require.NoError(t, err)
+		require.EqualValues(t, 3, received.Load())
+
+		total := report.Total()
+		require.Equal(t, 3, total.Requests)
+		require.Equal(t, 1, total.Errors)
+		require.Equal(t, map[int]int{200: 2, 404: 1}, total.StatusCodes)
+
+		var names []string
+		for _, s := range report.ByName() {
+			names = append(names, s.Name)
+		}
+		require.Equal(t, []string{"GET /api/v1/profile/missing", "GET /api/v1/profile/{id}", "PUT /api/v1/blob"}, names)
+	})
+
+	t.Run("Rate", func(t *testing.T) {
+		received.Store(0)
+		start := time.Now()
+		report, err := Run(context.Background(), srv.URL, Replay(reqs, true), WithRate(100), WithMaxRequests(20))
+		require.NoError(t, err)
+		require.EqualValues(t, 20, received.Load())
+		require.Equal(t, 20, report.Total().Requests)
+		// the last request is due 190ms after the first one
+		require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+	})
+
+	t.Run("Duration", func(t *testing.T) {
+		report, err := Run(context.Background(), srv.URL, Replay(reqs, true), WithRate(1000), WithDuration(100*time.Millisecond))
+		require.NoError(t, err)
+		require.Positive(t, report.Total().Requests)
+		require.Less(t, report.Duration, time.Second)
+	})
+
+	t.Run("Headers", func(t *testing.T) {
+		var keys, traces sync.Map
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys.Store(r.URL.Path, r.Header.Get("X-API-Key"))
+			traces.Store(r.URL.Path, r.Header.Get("X-Trace"))
+		}))
+		defer srv.Close()
+
+		reqs, err := ReadRequests(strings.NewReader(`
+{"method":"GET","path":"/default"}
+{"method":"GET","path":"/own","headers":{"X-API-Key":"own-key","X-Trace":"1"}}
+`))
+		require.NoError(t, err)
+		_, err = Run(context.Background(), srv.URL, Replay(reqs, false), WithHeaders(map[string]string{"X-API-Key": "loadtest"}))
+		require.NoError(t, err)
+
+		key, _ := keys.Load("/default")
+		require.Equal(t, "loadtest", key)
+		key, _ = keys.Load("/own")
+		require.Equal(t, "own-key", key) // the headers of the request win
+		trace, _ := traces.Load("/own")
+		require.Equal(t, "1", trace)
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, 50*time.Millisecond, percentile(latencies, 50))
+	require.Equal(t, 99*time.Millisecond, percentile(latencies, 99))
+	require.Equal(t, 100*time.Millisecond, percentile(latencies, 100))
+	require.Equal(t, time.Millisecond, percentile(latencies[:1], 99))
+	require.Zero(t, percentile(nil, 50))
+}
+
+func TestMix(t *testing.T) {
+	ids := []string{uuid.NewString(), uuid.NewString()}
+	for _, pattern := range []Pattern{PatternProfile, PatternBlob} {
+		a, err := NewMix(pattern, ids, WithSeed(1), WithWriteRatio(0.2))
+		require.NoError(t, err)
+		b, err := NewMix(pattern, ids, WithSeed(1), WithWriteRatio(0.2))
+		require.NoError(t, err)
+
+		var writes int
+		for range 500 {
+			req, ok := a.Next()
+			require.True(t, ok)
+			// the bodies differ by the time they're generated at
+			other, _ := b.Next()
+			require.Equal(t, req.Method+" "+req.Path, other.Method+" "+other.Path)
+
+			require.True(t, strings.HasPrefix(req.Path, "/api/v1/"+string(pattern)), req.Path)
+			if req.Method == http.MethodPut {
+				writes++
+				require.NotEmpty(t, req.Body)
+			}
+		}
+		require.InDelta(t, 100, writes, 40)
+	}
+
+	_, err := NewMix(PatternBoth, nil)
+	require.Error(t, err)
+}
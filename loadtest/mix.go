@@ -0,0 +1,163 @@
+package loadtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"personalisation-poc/model"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const apiBasePath = "/api/v1"
+
+// Pattern is the access pattern of a synthetic workload.
+type Pattern string
+
+const (
+	// PatternProfile reads and writes the normalized profiles, through the /profile endpoints.
+	PatternProfile Pattern = "profile"
+	// PatternBlob reads and writes the blobs, through the /blob endpoints.
+	PatternBlob Pattern = "blob"
+	// PatternBoth picks one of the two designs at random for every request.
+	PatternBoth Pattern = "both"
+)
+
+// ParsePattern returns the Pattern named s.
+func ParsePattern(s string) (Pattern, error) {
+	switch p := Pattern(s); p {
+	case PatternProfile, PatternBlob, PatternBoth:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown access pattern %q", s)
+	}
+}
+
+// read is a kind of read request, to the path formatted with a profile ID and a segment type.
+type read struct {
+	name   string
+	path   string
+	weight int
+}
+
+// the reads of each design, weighted after the clients' traffic: whole profiles first, then single fields
+var reads = map[Pattern][]read{
+	PatternProfile: {
+		{name: "GET /profile/{id}", path: "/profile/%[1]s", weight: 4},
+		{name: "GET /profile/{id}/tags", path: "/profile/%[1]s/tags", weight: 2},
+		{name: "GET /profile/{id}/segment/{type}/topcategories", path: "/profile/%[1]s/segment/%[2]s/topcategories", weight: 2},
+		{name: "GET /profile/{id}/segment/{type}", path: "/profile/%[1]s/segment/%[2]s", weight: 1},
+	},
+	PatternBlob: {
+		{name: "GET /blob/{id}", path: "/blob/%[1]s", weight: 4},
+		{name: "GET /blob/{id}?path=tags", path: "/blob/%[1]s?path=tags", weight: 2},
+		{name: "GET /blob/{id}?path=segments[0].top_categories", path: "/blob/%[1]s?path=segments[0].top_categories", weight: 2},
+		{name: "GET /blob/{id}/segments", path: "/blob/%[1]s/segments", weight: 1},
+	},
+}
+
+type MixOption func(*Mix)
+
+// WithWriteRatio sets the share of the requests that upsert a profile, between 0 and 1. By default it's 0.1.
+func WithWriteRatio(ratio float64) MixOption {
+	return func(m *Mix) {
+		m.writeRatio = min(max(ratio, 0), 1)
+	}
+}
+
+// WithSeed makes the workload deterministic. By default the seed is random.
+func WithSeed(seed int64) MixOption {
+	return func(m *Mix) {
+		m.rnd = rand.New(rand.NewSource(seed))
+	}
+}
+
+// Mix is an endless synthetic workload of reads and writes on existing profiles.
+// Writes replace a profile with a generated one with the same ID, so that the reads keep finding it.
+type Mix struct {
+	pattern    Pattern
+	ids        []string
+	writeRatio float64
+	rnd        *rand.Rand
+	gen        *model.Generator
+}
+
+// NewMix returns a synthetic workload with the given access pattern, on the profiles with the given IDs.
+func NewMix(pattern Pattern, ids []string, opts ...MixOption) (*Mix, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("a synthetic workload needs the IDs of existing profiles")
+	}
+	m := &Mix{pattern: pattern, ids: ids, writeRatio: 0.1}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.rnd == nil {
+		m.rnd = rand.New(rand.NewSource(rand.Int63()))
+	}
+	m.gen = model.NewGenerator(model.WithSeed(m.rnd.Int63()))
+	return m, nil
+}
+
+func (m *Mix) Next() (Request, bool) {
+	pattern := m.pattern
+	if pattern == PatternBoth {
+		pattern = []Pattern{PatternProfile, PatternBlob}[m.rnd.Intn(2)]
+	}
+	id := m.ids[m.rnd.Intn(len(m.ids))]
+
+	if m.rnd.Float64() < m.writeRatio {
+		profile := m.gen.Profile()
+		profile.ID = uuid.MustParse(id)
+		body, _ := json.Marshal(profile) // a profile always encodes
+		return Request{Name: "PUT /" + string(pattern), Method: "PUT", Path: apiBasePath + "/" + string(pattern), Body: body}, true
+	}
+
+	candidates := reads[pattern]
+	total := 0
+	for _, r := range candidates {
+		total += r.weight
+	}
+	n := m.rnd.Intn(total)
+	for _, r := range candidates {
+		if n -= r.weight; n < 0 {
+			segmentType := []string{model.MorningSegmentType, model.EveningSegmentType}[m.rnd.Intn(2)]
+			return Request{Name: r.name, Method: "GET", Path: apiBasePath + fmt.Sprintf(r.path, id, segmentType)}, true
+		}
+	}
+	panic("unreachable")
+}
+
+// ReadIDs reads profile IDs, one per line, as printed by the seed command, or NDJSON profiles with an "id" field,
+// as printed by the export command.
+func ReadIDs(r io.Reader) ([]string, error) {
+	var ids []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // exported profiles can be long lines
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "{") {
+			var profile struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal([]byte(text), &profile); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			text = profile.ID
+		}
+		if _, err := uuid.Parse(text); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ids = append(ids, text)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
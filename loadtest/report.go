@@ -0,0 +1,129 @@
+package loadtest
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Report holds the results of a run.
+type Report struct {
+	Duration time.Duration
+
+	mu     sync.Mutex
+	total  *stats
+	byName map[string]*stats
+}
+
+type stats struct {
+	errors    int
+	statuses  map[int]int
+	latencies []time.Duration
+}
+
+func newReport() *Report {
+	return &Report{total: newStats(), byName: make(map[string]*stats)}
+}
+
+func newStats() *stats {
+	return &stats{statuses: make(map[int]int)}
+}
+
+func (r *Report) record(name string, latency time.Duration, status int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.byName[name]
+	if !ok {
+		s = newStats()
+		r.byName[name] = s
+	}
+	for _, s := range []*stats{r.total, s} {
+		s.latencies = append(s.latencies, latency)
+		s.statuses[status]++
+		// transport errors and error statuses
+		if err != nil || status >= 400 {
+			s.errors++
+		}
+	}
+}
+
+// Summary is the outcome of the requests of a kind, or of all of them.
+type Summary struct {
+	Name       string  `json:"name"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	Throughput float64 `json:"throughput"` // requests per second
+	// StatusCodes counts the responses by status code, transport errors under 0.
+	StatusCodes map[int]int   `json:"status_codes"`
+	Mean        time.Duration `json:"mean_ns"`
+	P50         time.Duration `json:"p50_ns"`
+	P90         time.Duration `json:"p90_ns"`
+	P99         time.Duration `json:"p99_ns"`
+	Max         time.Duration `json:"max_ns"`
+}
+
+// Total summarizes all the requests of the run.
+func (r *Report) Total() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total.summary("total", r.Duration)
+}
+
+// ByName summarizes the requests of the run by name, sorted by name.
+func (r *Report) ByName() []Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summaries := make([]Summary, 0, len(r.byName))
+	for name, s := range r.byName {
+		summaries = append(summaries, s.summary(name, r.Duration))
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+func (s *stats) summary(name string, d time.Duration) Summary {
+	latencies := slices.Clone(s.latencies)
+	slices.Sort(latencies)
+
+	sum := Summary{
+		Name:        name,
+		Requests:    len(latencies),
+		Errors:      s.errors,
+		StatusCodes: maps.Clone(s.statuses),
+		P50:         percentile(latencies, 50),
+		P90:         percentile(latencies, 90),
+		P99:         percentile(latencies, 99),
+	}
+	if len(latencies) == 0 {
+		return sum
+	}
+
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	sum.Mean = total / time.Duration(len(latencies))
+	sum.Max = latencies[len(latencies)-1]
+	sum.ErrorRate = float64(s.errors) / float64(len(latencies))
+	if d > 0 {
+		sum.Throughput = float64(len(latencies)) / d.Seconds()
+	}
+	return sum
+}
+
+// percentile returns the p-th percentile of sorted latencies, using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
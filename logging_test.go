@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// stubRepo answers every profile read with err.
+type stubRepo struct {
+	repository.ProfilesRepo // unimplemented methods panic
+	err                     error
+}
+
+func (s stubRepo) GetProfileByID(context.Context, string) (*model.Profile, error) {
+	return nil, s.err
+}
+
+func TestRequestIDLogged(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	srv := newServer(stubRepo{err: repository.ErrNoProfileFound}, log)
+
+	req := httptest.NewRequest(http.MethodGet, apiBasePath+"/profile/123", nil)
+	req.Header.Set(requestIDHeader, "test-request-id")
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	// the error logged by httpError, then the access log line
+	var records []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]any
+		require.NoError(t, dec.Decode(&record))
+		require.Equal(t, "test-request-id", record[requestIDKey], record)
+		records = append(records, record)
+	}
+	require.Len(t, records, 2)
+	require.Equal(t, repository.ErrNoProfileFound.Error(), records[0]["error"])
+	require.Equal(t, "access", records[1][slog.MessageKey])
+}
@@ -2,23 +2,51 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"personalisation-poc/repository"
+	"personalisation-poc/repository/cache"
 	"personalisation-poc/repository/ddb"
+	"personalisation-poc/schema"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 	"github.com/guregu/dynamo/v2"
 )
 
 func main() {
-	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	// the operator commands print their results to stdout, their logs go to stderr
+	logOut := os.Stderr
+	if cmd.name == "serve" {
+		logOut = os.Stdout
+	}
+	log := slog.New(newContextHandler(slog.NewTextHandler(logOut, &slog.HandlerOptions{Level: slog.LevelDebug})))
 	slog.SetDefault(log)
 
 	conf, err := LoadConfig()
@@ -27,8 +55,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := run(log, conf); err != nil {
-		log.Error("error running", "error", err)
+	if err := cmd.run(conf, args, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Error(cmd.name+" failed", "error", err)
 		os.Exit(1)
 	}
 }
@@ -36,25 +67,32 @@ func main() {
 func run(log *slog.Logger, conf *Config) error {
 	ctx := context.Background()
 
-	cfg, err := config.LoadDefaultConfig(ctx)
+	db, err := newRepo(ctx, conf, ddb.WithSlidingExpiry(conf.TTL.SlidingInterval))
 	if err != nil {
-		return fmt.Errorf("unable to load SDK config: %w", err)
+		return err
+	}
+	var repo repository.ProfilesRepo = db
+	if conf.Cache.Enabled {
+		c, err := newCache(repo, conf.Cache)
+		if err != nil {
+			return err
+		}
+		if conf.Cache.BloomFile != "" && conf.Cache.BloomRebuildInterval > 0 {
+			go rebuildBloom(ctx, c, conf.Cache, log)
+		}
+		repo = c
 	}
 
-	db := dynamo.New(cfg, func(o *dynamodb.Options) {
-		o.BaseEndpoint = aws.String(conf.DynamoDB.Endpoint)
-		o.Region = conf.AWS.Region
-		o.Credentials = credentials.NewStaticCredentialsProvider(conf.AWS.AccessKey, conf.AWS.SecretKey, "")
-	})
-	table := db.Table(conf.TableName)
-
-	repo := ddb.NewDB(table)
+	schemas, err := schema.New(schema.WithDir(conf.Blob.SchemaDir), schema.WithStripUnknown(conf.Blob.StripUnknown))
+	if err != nil {
+		return fmt.Errorf("failed to load blob schemas: %w", err)
+	}
 
-	server := newServer(repo, log)
+	server := newServer(repo, log, withRateLimit(conf.RateLimit), withBlobSchemas(schemas), withAdmin(db, conf.Admin), withWebhooks(db))
 
 	go func() {
 		log.Info("starting server", "port", conf.Port)
-		if err := http.ListenAndServe(conf.Port, server.router); err != nil {
+		if err := http.ListenAndServe(conf.Port, server); err != nil {
 			if err == http.ErrServerClosed {
 				log.Info("server closed")
 			} else {
@@ -73,3 +111,105 @@ func run(log *slog.Logger, conf *Config) error {
 
 	return nil
 }
+
+// newDynamoDB creates a DynamoDB client. Static credentials are only used when configured,
+// otherwise the SDK's default credential chain applies.
+func newDynamoDB(ctx context.Context, conf *Config) (*dynamo.DB, error) {
+	cfg, err := loadAWSConfig(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamo.New(cfg, func(o *dynamodb.Options) {
+		// calls are retried by the repository, according to its retry policy
+		o.Retryer = aws.NopRetryer{}
+		if conf.DynamoDB.Endpoint != "" {
+			o.BaseEndpoint = aws.String(conf.DynamoDB.Endpoint)
+		}
+	}, ddb.ReturnConsumedCapacity), nil
+}
+
+// newDynamoDBStreams returns a DynamoDB Streams client. DynamoDB Local serves its streams on the DynamoDB endpoint.
+func newDynamoDBStreams(ctx context.Context, conf *Config) (*dynamodbstreams.Client, error) {
+	cfg, err := loadAWSConfig(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodbstreams.NewFromConfig(cfg, func(o *dynamodbstreams.Options) {
+		if conf.DynamoDB.Endpoint != "" {
+			o.BaseEndpoint = aws.String(conf.DynamoDB.Endpoint)
+		}
+	}), nil
+}
+
+func loadAWSConfig(ctx context.Context, conf *Config) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(conf.AWS.Region),
+	}
+	if conf.AWS.AccessKey != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(conf.AWS.AccessKey, conf.AWS.SecretKey, ""),
+		))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("unable to load SDK config: %w", err)
+	}
+	return cfg, nil
+}
+
+// retryPolicy is the retry policy of the repository. It replaces the SDK's retries, disabled by newDynamoDB.
+func retryPolicy(conf DynamoDBConfig) ddb.RetryPolicy {
+	return ddb.RetryPolicy{
+		MaxAttempts: conf.MaxAttempts,
+		BaseDelay:   conf.RetryBaseDelay,
+		MaxDelay:    conf.RetryMaxDelay,
+		Timeout:     conf.CallTimeout,
+	}
+}
+
+func newCache(repo repository.ProfilesRepo, conf CacheConfig) (*cache.Cache, error) {
+	opts := []cache.Option{
+		cache.WithSize(conf.Size),
+		cache.WithTTL(conf.TTL),
+		cache.WithNegativeTTL(conf.NegativeTTL),
+	}
+
+	if conf.BloomFile != "" {
+		f, err := os.Open(conf.BloomFile)
+		if err != nil {
+			return nil, fmt.Errorf("open bloom filter export: %w", err)
+		}
+		defer f.Close()
+
+		bloom, err := cache.LoadBloom(f, conf.BloomFPRate)
+		if err != nil {
+			return nil, fmt.Errorf("load bloom filter: %w", err)
+		}
+		opts = append(opts, cache.WithBloomFilter(bloom))
+	}
+
+	return cache.New(repo, opts...), nil
+}
+
+// rebuildBloom rebuilds the bloom filter of c from the table at every interval, until ctx is done.
+func rebuildBloom(ctx context.Context, c *cache.Cache, conf CacheConfig, log *slog.Logger) {
+	ticker := time.NewTicker(conf.BloomRebuildInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		if err := c.RebuildBloom(ctx, conf.BloomFPRate); err != nil {
+			log.ErrorContext(ctx, "failed to rebuild bloom filter", "error", err)
+			continue
+		}
+		log.InfoContext(ctx, "bloom filter rebuilt", "duration", time.Since(start))
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"personalisation-poc/repository/ddb"
+)
+
+// runMigrate creates or updates the table to the definition expected by the repository, writing the migrations applied to out.
+func runMigrate(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := newDynamoDB(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	applied, err := ddb.Migrate(ctx, db, conf.TableName)
+	for _, m := range applied {
+		fmt.Fprintf(out, "applied migration %d: %s\n", m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "table %s is at schema version %d\n", conf.TableName, ddb.SchemaVersion())
+	return nil
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"fmt"
+	"math"
 	"math/rand"
 	"sort"
 	"time"
@@ -33,42 +35,219 @@ var (
 	}
 )
 
+// DefaultCategories is the category vocabulary of the generated profiles by default.
+func DefaultCategories() []string {
+	return append([]string(nil), categories...)
+}
+
+// CategoryTags returns the tags given to the users whose top category is category.
+// Categories outside the default vocabulary get a single "<category>_fan" tag.
+func CategoryTags(category string) []string {
+	if tags, ok := categoriesTags[category]; ok {
+		return tags
+	}
+	return []string{category + "_fan"}
+}
+
+// ScoreDistribution is the distribution of the generated category scores, all in [0, 1).
+type ScoreDistribution string
+
+const (
+	// ScoresUniform spreads the scores evenly.
+	ScoresUniform ScoreDistribution = "uniform"
+	// ScoresNormal centres the scores around 0.5.
+	ScoresNormal ScoreDistribution = "normal"
+	// ScoresSkewed gives most categories a low score and a few of them a high one, like actual reading habits.
+	ScoresSkewed ScoreDistribution = "skewed"
+)
+
+// ParseScoreDistribution returns the ScoreDistribution named s.
+func ParseScoreDistribution(s string) (ScoreDistribution, error) {
+	switch d := ScoreDistribution(s); d {
+	case ScoresUniform, ScoresNormal, ScoresSkewed:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown score distribution %q", s)
+	}
+}
+
+type GeneratorOption func(*Generator)
+
+// WithSeed makes the generator deterministic: generators with the same options and seed generate the same profiles.
+// By default the seed is random.
+func WithSeed(seed int64) GeneratorOption {
+	return func(g *Generator) {
+		g.rnd = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithNow sets the time the profiles are generated at, which their timestamps derive from. By default it's the current time.
+// Deterministic datasets need both a seed and a fixed time.
+func WithNow(now time.Time) GeneratorOption {
+	return func(g *Generator) {
+		g.now = now
+	}
+}
+
+// WithSegmentTypes sets the segment types of the profiles. By default they have a morning and an evening segment.
+func WithSegmentTypes(types ...string) GeneratorOption {
+	return func(g *Generator) {
+		g.segmentTypes = types
+	}
+}
+
+// WithCategories sets the category vocabulary the segments' categories are drawn from. By default it's DefaultCategories.
+func WithCategories(categories ...string) GeneratorOption {
+	return func(g *Generator) {
+		g.categories = lo.Uniq(categories)
+	}
+}
+
+// WithCategoriesPerSegment bounds the number of distinct categories of a segment.
+// By default a segment has between one and every category of the vocabulary.
+func WithCategoriesPerSegment(least, most int) GeneratorOption {
+	return func(g *Generator) {
+		g.minCategories, g.maxCategories = least, most
+	}
+}
+
+// WithScoreDistribution sets the distribution of the category scores. By default it's ScoresUniform.
+func WithScoreDistribution(d ScoreDistribution) GeneratorOption {
+	return func(g *Generator) {
+		g.scores = d
+	}
+}
+
+// WithHistory generates depth versions of each segment type, created interval apart, the latest one at the generation time.
+// By default there is a single version.
+func WithHistory(depth int, interval time.Duration) GeneratorOption {
+	return func(g *Generator) {
+		g.historyDepth, g.historyInterval = depth, interval
+	}
+}
+
+// WithExpiry sets the expiry date of the profiles to ttl after their last update, and of the segments to ttl after their creation,
+// so that the oldest versions of a deep history may already be expired.
+// By default it's zero: the expiry dates are left unset, for the repository to apply its item types' TTLs.
+func WithExpiry(ttl time.Duration) GeneratorOption {
+	return func(g *Generator) {
+		g.expiry = ttl
+	}
+}
+
+// Generator generates fake profiles, e.g. for demos and load tests. It isn't safe for concurrent use.
+type Generator struct {
+	rnd             *rand.Rand
+	now             time.Time
+	segmentTypes    []string
+	categories      []string
+	minCategories   int
+	maxCategories   int
+	scores          ScoreDistribution
+	historyDepth    int
+	historyInterval time.Duration
+	expiry          time.Duration
+}
+
+// NewGenerator returns a Generator. Out of range options are clamped, e.g. to at least one category per segment.
+func NewGenerator(opts ...GeneratorOption) *Generator {
+	g := &Generator{
+		segmentTypes:    []string{MorningSegmentType, EveningSegmentType},
+		categories:      DefaultCategories(),
+		scores:          ScoresUniform,
+		historyDepth:    1,
+		historyInterval: 24 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	if g.rnd == nil {
+		g.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if len(g.categories) == 0 {
+		g.categories = DefaultCategories()
+	}
+	if g.maxCategories <= 0 || g.maxCategories > len(g.categories) {
+		g.maxCategories = len(g.categories)
+	}
+	g.minCategories = min(max(g.minCategories, 1), g.maxCategories)
+	g.historyDepth = max(g.historyDepth, 1)
+
+	return g
+}
+
+// FakeProfile returns a random profile with a morning and an evening segment.
 func FakeProfile() *Profile {
-	id := uuid.New()
-	now := time.Now()
+	return NewGenerator().Profile()
+}
+
+// Profile returns the next profile. Its tags derive from the top category of the latest segment of each type.
+func (g *Generator) Profile() *Profile {
+	now := g.now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// the reader can't fail
+	id, _ := uuid.NewRandomFromReader(g.rnd)
+	createdAt := now.Add(-time.Duration(g.historyDepth-1) * g.historyInterval)
 	p := &Profile{
-		ID:   id,
-		Tags: []string{},
-		Segments: []Segment{
-			getRandomSegment(MorningSegmentType, rand.Intn(len(categoriesTags)), now, now),
-			getRandomSegment(EveningSegmentType, rand.Intn(len(categoriesTags)), now, now),
-		},
-		CreatedAt: now,
+		ID:        id,
+		Tags:      []string{},
+		CreatedAt: createdAt,
 		UpdatedAt: now,
+		ExpiresAt: g.expiresAt(now),
 	}
 
-	p.Tags = append(p.Tags, categoriesTags[p.Segments[0].TopCategories[0]]...)
-	p.Tags = append(p.Tags, categoriesTags[p.Segments[1].TopCategories[0]]...)
+	for _, typ := range g.segmentTypes {
+		for version := g.historyDepth - 1; version >= 0; version-- {
+			at := now.Add(-time.Duration(version) * g.historyInterval)
+			p.Segments = append(p.Segments, g.segment(typ, at))
+		}
+		latest := p.Segments[len(p.Segments)-1]
+		p.Tags = append(p.Tags, CategoryTags(latest.TopCategories[0])...)
+	}
+	p.Tags = lo.Uniq(p.Tags)
 
 	return p
 }
 
-func getRandomSegment(typ string, numCategories int, createdAt, updatedAt time.Time) Segment {
-	categories := make([]Category, numCategories)
-	for i := range numCategories {
-		categories[i] = Category{ID: getRandomCategory(), Score: getRandomScore()}
+func (g *Generator) segment(typ string, createdAt time.Time) Segment {
+	n := g.minCategories + g.rnd.Intn(g.maxCategories-g.minCategories+1)
+	categories := make([]Category, n)
+	for i, j := range g.rnd.Perm(len(g.categories))[:n] {
+		categories[i] = Category{ID: g.categories[j], Score: g.score()}
 	}
-	topCategories := extractTopCategories(categories)
 
 	return Segment{
 		Type:          typ,
 		Categories:    categories,
-		TopCategories: topCategories,
+		TopCategories: extractTopCategories(categories),
 		CreatedAt:     createdAt,
-		UpdatedAt:     updatedAt,
+		UpdatedAt:     createdAt,
+		ExpiresAt:     g.expiresAt(createdAt),
+	}
+}
+
+func (g *Generator) score() float64 {
+	switch g.scores {
+	case ScoresNormal:
+		return math.Min(math.Max(0.5+g.rnd.NormFloat64()*0.15, 0), math.Nextafter(1, 0))
+	case ScoresSkewed:
+		return math.Pow(g.rnd.Float64(), 3)
+	default:
+		return g.rnd.Float64()
 	}
 }
 
+func (g *Generator) expiresAt(t time.Time) time.Time {
+	if g.expiry <= 0 {
+		return time.Time{}
+	}
+	return t.Add(g.expiry)
+}
+
 func extractTopCategories(categories []Category) []string {
 	sort.Slice(categories, func(i, j int) bool {
 		return categories[i].Score > categories[j].Score
@@ -85,11 +264,3 @@ func extractTopCategories(categories []Category) []string {
 	}
 	return topCategories
 }
-
-func getRandomCategory() string {
-	return categories[rand.Intn(len(categories))]
-}
-
-func getRandomScore() float64 {
-	return rand.Float64()
-}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratorDeterministic(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := NewGenerator(WithSeed(42), WithNow(now))
+	b := NewGenerator(WithSeed(42), WithNow(now))
+	for range 10 {
+		require.Equal(t, a.Profile(), b.Profile())
+	}
+
+	c := NewGenerator(WithSeed(43), WithNow(now))
+	require.NotEqual(t, NewGenerator(WithSeed(42), WithNow(now)).Profile().ID, c.Profile().ID)
+}
+
+func TestGeneratorOptions(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := NewGenerator(
+		WithNow(now),
+		WithSegmentTypes("weekday", "weekend", "night"),
+		WithCategories("sports", "finance", "health"),
+		WithCategoriesPerSegment(2, 2),
+		WithScoreDistribution(ScoresNormal),
+		WithHistory(4, time.Hour),
+		WithExpiry(24*time.Hour),
+	)
+
+	for range 100 {
+		p := g.Profile()
+		require.Len(t, p.Segments, 12)
+		require.NotEmpty(t, p.Tags)
+		require.Equal(t, now.Add(-3*time.Hour), p.CreatedAt)
+		require.Equal(t, now.Add(24*time.Hour), p.ExpiresAt)
+		for i, seg := range p.Segments {
+			require.Len(t, seg.Categories, 2)
+			require.Equal(t, now.Add(-time.Duration(3-i%4)*time.Hour), seg.CreatedAt)
+			require.Equal(t, seg.CreatedAt.Add(24*time.Hour), seg.ExpiresAt)
+			for _, c := range seg.Categories {
+				require.Contains(t, []string{"sports", "finance", "health"}, c.ID)
+				require.GreaterOrEqual(t, c.Score, 0.0)
+				require.Less(t, c.Score, 1.0)
+			}
+		}
+	}
+}
+
+func TestFakeProfile(t *testing.T) {
+	// segments used to be generated without categories, panicking when deriving the tags
+	for range 1000 {
+		p := FakeProfile()
+		require.Len(t, p.Segments, 2)
+		for _, seg := range p.Segments {
+			require.NotEmpty(t, seg.Categories)
+			require.NotEmpty(t, seg.TopCategories)
+		}
+		require.True(t, p.ExpiresAt.IsZero())
+	}
+}
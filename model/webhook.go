@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// Webhook is the subscription of a client to the changes to the profiles, delivered as signed HTTP POST requests to URL.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// EventTypes are the types of the events delivered, e.g. "tags.changed".
+	EventTypes []string `json:"event_types"`
+	// Tags restricts the tags.changed events to those adding or removing one of these tags. Empty delivers them all.
+	Tags []string `json:"tags,omitempty"`
+	// Secret is the key of the HMAC signature of the payloads. It's only returned when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+	// Owner is the admin client that created the webhook, the only one to see it.
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeadLetter is an event that couldn't be delivered to a webhook. Its payload isn't kept, as it holds profile data:
+// the client reads the profile again to catch up.
+type DeadLetter struct {
+	WebhookID string `json:"webhook_id"`
+	EventID   string `json:"event_id"`
+	EventType string `json:"event_type"`
+	ProfileID string `json:"profile_id"`
+	Attempts  int    `json:"attempts"`
+	// Status is the status code of the last response, zero when the last attempt got none.
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error"`
+	FailedAt  time.Time `json:"failed_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
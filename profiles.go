@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+)
+
+// runGetProfile prints a profile, or its blob as JSON.
+func runGetProfile(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("get-profile", flag.ContinueOnError)
+	id := fs.String("id", "", "ID of the profile")
+	output := fs.String("o", string(outputJSON), "output format: json or table")
+	blob := fs.Bool("blob", false, "print the blob instead of the normalized profile, as JSON")
+	includeExpired := fs.Bool("include-expired", false, "also return the expired items")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if *includeExpired {
+		ctx = repository.IncludeExpired(ctx)
+	}
+	repo, err := newRepo(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	if *blob {
+		data, err := repo.GetBlob(ctx, *id)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s\n", data)
+		return err
+	}
+
+	profile, err := repo.GetProfileByID(ctx, *id)
+	if err != nil {
+		return err
+	}
+	return writeProfile(out, profile, format)
+}
+
+// runPutProfile writes a profile read as JSON from a file or stdin, with the defaults of the upsert endpoint,
+// and prints it as written.
+func runPutProfile(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("put-profile", flag.ContinueOnError)
+	file := fs.String("f", "-", "file to read the profile from, - for stdin")
+	output := fs.String("o", string(outputJSON), "output format: json or table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	format, err := parseOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	in, err := openInput(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var profile model.Profile
+	if err := json.NewDecoder(in).Decode(&profile); err != nil {
+		return fmt.Errorf("failed to decode profile: %w", err)
+	}
+	validateUpsertProfile(&profile)
+
+	ctx := context.Background()
+	repo, err := newRepo(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if err := repo.UpsertProfile(ctx, profile); err != nil {
+		return err
+	}
+	return writeProfile(out, &profile, format)
+}
+
+// runDeleteProfile deletes both representations of a profile.
+func runDeleteProfile(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("delete-profile", flag.ContinueOnError)
+	id := fs.String("id", "", "ID of the profile")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	ctx := context.Background()
+	repo, err := newRepo(ctx, conf)
+	if err != nil {
+		return err
+	}
+	if err := repo.DeleteProfile(ctx, *id); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "deleted profile %s\n", *id)
+	return err
+}
+
+// openInput opens the file at path, or stdin when path is "-".
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
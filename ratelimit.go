@@ -0,0 +1,140 @@
+package main
+
+import (
+	"container/list"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+
+	// limiterIdleTimeout is how long a client's bucket is kept after its last request.
+	limiterIdleTimeout = 10 * time.Minute
+	// maxClients bounds the number of buckets kept. Once reached, the least recently seen client is evicted.
+	maxClients = 100_000
+)
+
+type clientLimiter struct {
+	key      string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps one token bucket per client. The buckets are ordered from the most to the least recently seen,
+// so that the idle and the oldest ones are evicted from the back without scanning them all.
+type rateLimiter struct {
+	conf    RateLimitConfig
+	mu      sync.Mutex
+	clients map[string]*list.Element // of *clientLimiter, in order
+	order   *list.List
+}
+
+func newRateLimiter(conf RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		conf:    conf,
+		clients: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// reserve takes a token from the client's bucket.
+// If no token is available it returns false and how long the client should wait before retrying.
+func (rl *rateLimiter) reserve(apiKey, clientKey string) (bool, time.Duration) {
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// the idle buckets are at the back
+	for e := rl.order.Back(); e != nil && now.Sub(e.Value.(*clientLimiter).lastSeen) > limiterIdleTimeout; e = rl.order.Back() {
+		rl.remove(e)
+	}
+
+	var cl *clientLimiter
+	if e, ok := rl.clients[clientKey]; ok {
+		cl = e.Value.(*clientLimiter)
+		rl.order.MoveToFront(e)
+	} else {
+		if len(rl.clients) >= maxClients {
+			rl.remove(rl.order.Back()) // the least recently seen client
+		}
+		cl = &clientLimiter{key: clientKey, limiter: rate.NewLimiter(rl.quota(apiKey))}
+		rl.clients[clientKey] = rl.order.PushFront(cl)
+	}
+	cl.lastSeen = now
+
+	res := cl.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Second
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// remove removes the bucket of e.
+func (rl *rateLimiter) remove(e *list.Element) {
+	rl.order.Remove(e)
+	delete(rl.clients, e.Value.(*clientLimiter).key)
+}
+
+// known reports whether apiKey has its own quota. Unknown keys are ignored,
+// so that sending a new key with every request doesn't get a new bucket.
+func (rl *rateLimiter) known(apiKey string) bool {
+	_, rps := rl.conf.ClientRPS[apiKey]
+	_, burst := rl.conf.ClientBurst[apiKey]
+	return rps || burst
+}
+
+// quota returns the rate and burst for the given API key, falling back to the defaults.
+func (rl *rateLimiter) quota(apiKey string) (rate.Limit, int) {
+	rps, burst := rl.conf.RPS, rl.conf.Burst
+	if apiKey != "" {
+		if v, ok := rl.conf.ClientRPS[apiKey]; ok {
+			rps = v
+		}
+		if v, ok := rl.conf.ClientBurst[apiKey]; ok {
+			burst = v
+		}
+	}
+	return rate.Limit(rps), burst
+}
+
+// rateLimit rejects requests with 429 Too Many Requests once the client has exhausted its bucket.
+func rateLimit(rl *rateLimiter, log *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get(apiKeyHeader)
+			clientKey := "key:" + apiKey
+			if !rl.known(apiKey) {
+				apiKey, clientKey = "", "ip:"+clientIP(r)
+			}
+
+			if ok, retryAfter := rl.reserve(apiKey, clientKey); !ok {
+				log.WarnContext(r.Context(), "rate limit exceeded", "client", clientKey, "retryAfter", retryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	rl := newRateLimiter(RateLimitConfig{
+		Enabled:     true,
+		RPS:         1,
+		Burst:       2,
+		ClientRPS:   map[string]float64{"batch": 1},
+		ClientBurst: map[string]int{"batch": 1},
+	})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := rateLimit(rl, log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(apiKey, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/profile/123", nil)
+		req.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// default quota for unauthenticated clients, keyed by IP
+	require.Equal(t, http.StatusOK, do("", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusOK, do("", "10.0.0.1:5678").Code)
+	rec := do("", "10.0.0.1:1234")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// other clients have their own bucket
+	require.Equal(t, http.StatusOK, do("", "10.0.0.2:1234").Code)
+
+	// per-client quota override
+	require.Equal(t, http.StatusOK, do("batch", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusTooManyRequests, do("batch", "10.0.0.3:1234").Code)
+
+	// unknown keys don't get a bucket of their own
+	require.Equal(t, http.StatusTooManyRequests, do("random", "10.0.0.1:1234").Code)
+	require.Equal(t, http.StatusOK, do("random", "10.0.0.4:1234").Code)
+	require.Equal(t, http.StatusOK, do("other", "10.0.0.4:1234").Code)
+	require.Equal(t, http.StatusTooManyRequests, do("another", "10.0.0.4:1234").Code)
+}
+
+func TestRateLimitMaxClients(t *testing.T) {
+	rl := newRateLimiter(RateLimitConfig{Enabled: true, RPS: 1, Burst: 1})
+	for i := range maxClients + 10 {
+		ok, _ := rl.reserve("", "ip:"+strconv.Itoa(i))
+		require.True(t, ok)
+	}
+	require.Len(t, rl.clients, maxClients)
+	// the oldest clients were evicted
+	require.NotContains(t, rl.clients, "ip:0")
+	require.Contains(t, rl.clients, "ip:"+strconv.Itoa(maxClients+9))
+
+	// a client seen again is kept over those seen before it
+	rl.reserve("", "ip:10")
+	rl.reserve("", "ip:new")
+	require.Contains(t, rl.clients, "ip:10")
+	require.NotContains(t, rl.clients, "ip:11")
+
+	// idle clients are evicted
+	rl.clients["ip:12"].Value.(*clientLimiter).lastSeen = time.Now().Add(-2 * limiterIdleTimeout)
+	rl.order.MoveToBack(rl.clients["ip:12"])
+	rl.reserve("", "ip:new")
+	require.NotContains(t, rl.clients, "ip:12")
+	require.Len(t, rl.clients, maxClients-1)
+}
@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// Bloom is a bloom filter of known profile IDs.
+// A negative answer means the profile certainly doesn't exist, a positive answer means it may exist.
+type Bloom struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// minBloomBits is the size of the smallest filters.
+const minBloomBits = 4096
+
+// NewBloom returns a bloom filter sized for n IDs with the given false positive rate.
+func NewBloom(n int, fpRate float64) *Bloom {
+	n = max(n, 1)
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	// a power of two is coprime with the odd second hash, so that the k bits of an ID are distinct,
+	// and the small filters keep few false positives as IDs are added past n
+	m = 1 << bits.Len64(max(m, minBloomBits)-1)
+
+	return &Bloom{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// LoadBloom builds a bloom filter from an export, one profile per line.
+// Lines are either NDJSON profiles with an "id" field or bare profile IDs.
+func LoadBloom(r io.Reader, fpRate float64) (*Bloom, error) {
+	var ids []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // exported profiles can be long lines
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "{"):
+			var profile struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal([]byte(text), &profile); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			ids = append(ids, profile.ID)
+		default:
+			ids = append(ids, text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	b := NewBloom(len(ids), fpRate)
+	for _, id := range ids {
+		b.Add(id)
+	}
+
+	return b, nil
+}
+
+// Add records id as known.
+func (b *Bloom) Add(id string) {
+	h1, h2 := bloomHashes(id)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.k {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// MayContain reports whether id may have been added.
+func (b *Bloom) MayContain(id string) bool {
+	h1, h2 := bloomHashes(id)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for i := range b.k {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHashes derives the two hashes used for double hashing.
+func bloomHashes(id string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h1 := h.Sum64()
+	h.Write([]byte{0xff})
+	h2 := h.Sum64()
+
+	// the low bits of FNV only depend on the low bits of the input, mixing spreads all of them
+	return mix64(h1), mix64(h2) | 1 // odd, so that it never degenerates to a single bit
+}
+
+// mix64 is the finalizer of MurmurHash3, whose output bits each depend on every input bit.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
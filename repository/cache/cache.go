@@ -0,0 +1,296 @@
+// Package cache provides an in-process read-through cache in front of a repository.ProfilesRepo.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var _ repository.ProfilesRepo = &Cache{} // compile time check
+
+const (
+	defaultSize = 10_000
+	defaultTTL  = 30 * time.Second
+)
+
+type Option func(*Cache)
+
+// WithSize sets the maximum number of profiles kept in the cache.
+func WithSize(size int) Option {
+	return func(c *Cache) {
+		if size > 0 {
+			c.size = size
+		}
+	}
+}
+
+// WithTTL sets how long a cached value is served before being read again from the repository.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		if ttl > 0 {
+			c.ttl = ttl
+		}
+	}
+}
+
+// WithNegativeTTL caches unknown profiles for ttl, so that repeated lookups of the same
+// missing ID don't reach the repository. By default unknown profiles are not cached.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(c *Cache) {
+		c.negativeTTL = ttl
+	}
+}
+
+// WithBloomFilter answers lookups of IDs missing from bloom with repository.ErrNoProfileFound
+// without reaching the repository. Upserts through the cache add their ID to the filter.
+// The filter must contain every existing profile, e.g. built from a recent export,
+// as profiles created through other instances are reported as missing until it's rebuilt with RebuildBloom.
+func WithBloomFilter(bloom *Bloom) Option {
+	return func(c *Cache) {
+		c.bloom.Store(bloom)
+	}
+}
+
+// Cache decorates a ProfilesRepo with a size and TTL bounded LRU cache.
+// All the values cached for a profile are invalidated when the profile or its blob is upserted
+// through the cache. Writes made by other instances are only observed once the TTL expires.
+//
+// Cached values are shared between callers and must be treated as read-only.
+type Cache struct {
+	repo        repository.ProfilesRepo
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	bloom       atomic.Pointer[Bloom]
+	mu          sync.Mutex
+	lru         *lru
+	group       singleflight.Group
+	// added collects the IDs upserted while the bloom filter is rebuilt, it's nil otherwise
+	added []string
+}
+
+// New returns a ProfilesRepo caching the reads of repo.
+func New(repo repository.ProfilesRepo, opts ...Option) *Cache {
+	c := &Cache{
+		repo: repo,
+		size: defaultSize,
+		ttl:  defaultTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.lru = newLRU(c.size)
+
+	return c
+}
+
+// load returns the value cached for the profile under key, calling fetch on a miss.
+// Concurrent misses for the same profile and key share a single call to fetch.
+// Reads including expired items, or only reporting the explicit expiries, bypass the cache.
+func load[T any](ctx context.Context, c *Cache, profileID, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	if repository.ExpiredIncluded(ctx) || repository.ExplicitExpiriesOnly(ctx) {
+		return fetch(ctx)
+	}
+
+	c.mu.Lock()
+	e := c.lru.getOrCreate(profileID)
+	if v, ok := e.values[key]; ok {
+		if time.Now().Before(v.expiresAt) {
+			c.mu.Unlock()
+			if v.err != nil {
+				var zero T
+				return zero, v.err
+			}
+			return v.v.(T), nil
+		}
+		delete(e.values, key)
+	}
+	version := e.version
+	c.mu.Unlock()
+
+	res, err, _ := c.group.Do(fmt.Sprintf("%s|%d|%s", profileID, version, key), func() (any, error) {
+		// the call is shared, so it must not be cancelled when the first caller goes away
+		v, err := fetch(context.WithoutCancel(ctx))
+		negative := c.negativeTTL > 0 && errors.Is(err, repository.ErrNoProfileFound)
+		if err != nil && !negative {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if e, ok := c.lru.get(profileID); ok && e.version == version {
+			now := time.Now()
+			if negative {
+				e.store(key, value{err: err, expiresAt: now.Add(c.negativeTTL)}, now)
+			} else {
+				e.store(key, value{v: v, expiresAt: now.Add(c.ttl)}, now)
+			}
+		}
+		return v, err
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return res.(T), nil
+}
+
+// invalidate drops every value cached for the profile, including loads still in flight.
+// Profiles that aren't cached are left out of the cache, so that writes don't evict the profiles being read.
+func (c *Cache) invalidate(profileID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.lru.peek(profileID); ok {
+		e.version = c.lru.nextVersion()
+		clear(e.values)
+	}
+}
+
+// RebuildBloom replaces the bloom filter with one built from the profile IDs of the repository,
+// so that the profiles created through other instances are found. It's a no-op without a bloom filter.
+func (c *Cache) RebuildBloom(ctx context.Context, fpRate float64) error {
+	if c.bloom.Load() == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.added = []string{}
+	c.mu.Unlock()
+
+	var ids []string
+	err := c.repo.ScanProfileIDs(ctx, repository.RepresentationProfile, func(id string) error {
+		ids = append(ids, id)
+		return nil
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids = append(ids, c.added...)
+	c.added = nil
+	if err != nil {
+		return err
+	}
+
+	bloom := NewBloom(len(ids), fpRate)
+	for _, id := range ids {
+		bloom.Add(id)
+	}
+	c.bloom.Store(bloom)
+	return nil
+}
+
+func (c *Cache) GetProfileByID(ctx context.Context, id string) (*model.Profile, error) {
+	if bloom := c.bloom.Load(); bloom != nil && !bloom.MayContain(id) {
+		return nil, repository.ErrNoProfileFound
+	}
+	return load(ctx, c, id, "profile", func(ctx context.Context) (*model.Profile, error) {
+		return c.repo.GetProfileByID(ctx, id)
+	})
+}
+
+func (c *Cache) GetSegment(ctx context.Context, profileID string, segmentType string, createdAt time.Time) (*model.Segment, error) {
+	key := fmt.Sprintf("segment|%s|%d", segmentType, createdAt.UnixNano())
+	return load(ctx, c, profileID, key, func(ctx context.Context) (*model.Segment, error) {
+		return c.repo.GetSegment(ctx, profileID, segmentType, createdAt)
+	})
+}
+
+func (c *Cache) GetCategories(ctx context.Context, profileID string, segmentType string) ([]model.Category, error) {
+	return load(ctx, c, profileID, "categories|"+segmentType, func(ctx context.Context) ([]model.Category, error) {
+		return c.repo.GetCategories(ctx, profileID, segmentType)
+	})
+}
+
+func (c *Cache) GetUserTags(ctx context.Context, profileID string) ([]string, error) {
+	return load(ctx, c, profileID, "tags", func(ctx context.Context) ([]string, error) {
+		return c.repo.GetUserTags(ctx, profileID)
+	})
+}
+
+func (c *Cache) GetTopCategories(ctx context.Context, profileID string, segmentType string) ([]string, error) {
+	return load(ctx, c, profileID, "topcategories|"+segmentType, func(ctx context.Context) ([]string, error) {
+		return c.repo.GetTopCategories(ctx, profileID, segmentType)
+	})
+}
+
+func (c *Cache) GetBlob(ctx context.Context, profileID string) ([]byte, error) {
+	return load(ctx, c, profileID, "blob", func(ctx context.Context) ([]byte, error) {
+		return c.repo.GetBlob(ctx, profileID)
+	})
+}
+
+func (c *Cache) GetRawSegmentsFromBlob(ctx context.Context, profileID string) ([]byte, error) {
+	return load(ctx, c, profileID, "blobsegments", func(ctx context.Context) ([]byte, error) {
+		return c.repo.GetRawSegmentsFromBlob(ctx, profileID)
+	})
+}
+
+func (c *Cache) GetBlobPaths(ctx context.Context, profileID string, paths []string) ([]byte, error) {
+	// JSON keeps the key unambiguous, paths may contain commas
+	key, err := json.Marshal(paths)
+	if err != nil {
+		return nil, err
+	}
+	return load(ctx, c, profileID, "blobpaths|"+string(key), func(ctx context.Context) ([]byte, error) {
+		return c.repo.GetBlobPaths(ctx, profileID, paths)
+	})
+}
+
+func (c *Cache) BlobExpiresAt(ctx context.Context, profileID string) (time.Time, error) {
+	return load(ctx, c, profileID, "blobexpires", func(ctx context.Context) (time.Time, error) {
+		return c.repo.BlobExpiresAt(ctx, profileID)
+	})
+}
+
+func (c *Cache) UpsertProfile(ctx context.Context, profile model.Profile) error {
+	defer c.invalidate(profile.ID.String())
+	if err := c.repo.UpsertProfile(ctx, profile); err != nil {
+		return err
+	}
+	if bloom := c.bloom.Load(); bloom != nil {
+		bloom.Add(profile.ID.String())
+		// upserted during a rebuild, so maybe missed by its scan
+		c.mu.Lock()
+		if c.added != nil {
+			c.added = append(c.added, profile.ID.String())
+		}
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *Cache) UpsertBlob(ctx context.Context, profileID string, data []byte, opts ...repository.BlobOption) error {
+	defer c.invalidate(profileID)
+	return c.repo.UpsertBlob(ctx, profileID, data, opts...)
+}
+
+func (c *Cache) PatchBlob(ctx context.Context, profileID string, ops []repository.PatchOperation, opts ...repository.BlobOption) error {
+	defer c.invalidate(profileID)
+	return c.repo.PatchBlob(ctx, profileID, ops, opts...)
+}
+
+func (c *Cache) DeleteProfile(ctx context.Context, id string) error {
+	defer c.invalidate(id)
+	return c.repo.DeleteProfile(ctx, id)
+}
+
+// ScanProfileIDs is not cached.
+func (c *Cache) ScanProfileIDs(ctx context.Context, rep repository.Representation, fn func(id string) error) error {
+	return c.repo.ScanProfileIDs(ctx, rep, fn)
+}
+
+// ScanBlobIDs is not cached.
+func (c *Cache) ScanBlobIDs(ctx context.Context, blobType string, fn func(id string) error) error {
+	return c.repo.ScanBlobIDs(ctx, blobType, fn)
+}
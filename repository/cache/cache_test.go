@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepo is an in-memory ProfilesRepo counting the reads that reach it.
+type fakeRepo struct {
+	repository.ProfilesRepo // unimplemented methods panic
+
+	mu       sync.Mutex
+	profiles map[string]model.Profile
+	reads    atomic.Int32
+	block    chan struct{} // when set, reads wait for it to be closed
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{profiles: make(map[string]model.Profile)}
+}
+
+func (f *fakeRepo) GetProfileByID(ctx context.Context, id string) (*model.Profile, error) {
+	f.reads.Add(1)
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.profiles[id]
+	if !ok {
+		return nil, repository.ErrNoProfileFound
+	}
+	return &p, nil
+}
+
+func (f *fakeRepo) UpsertProfile(ctx context.Context, profile model.Profile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.profiles[profile.ID.String()] = profile
+	return nil
+}
+
+func (f *fakeRepo) GetBlobPaths(ctx context.Context, profileID string, paths []string) ([]byte, error) {
+	f.reads.Add(1)
+	return json.Marshal(paths)
+}
+
+func (f *fakeRepo) ScanProfileIDs(ctx context.Context, rep repository.Representation, fn func(id string) error) error {
+	f.mu.Lock()
+	ids := make([]string, 0, len(f.profiles))
+	for id := range f.profiles {
+		ids = append(ids, id)
+	}
+	f.mu.Unlock()
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepo) DeleteProfile(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.profiles[id]; !ok {
+		return repository.ErrNoProfileFound
+	}
+	delete(f.profiles, id)
+	return nil
+}
+
+func TestCacheReadThrough(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo, WithTTL(time.Minute))
+
+	id := uuid.New()
+	require.NoError(t, c.UpsertProfile(ctx, model.Profile{ID: id, Tags: []string{"sports_fan"}}))
+
+	for range 3 {
+		p, err := c.GetProfileByID(ctx, id.String())
+		require.NoError(t, err)
+		require.Equal(t, []string{"sports_fan"}, p.Tags)
+	}
+	require.EqualValues(t, 1, repo.reads.Load())
+
+	// upserts invalidate the cached profile
+	require.NoError(t, c.UpsertProfile(ctx, model.Profile{ID: id, Tags: []string{"tech_geek"}}))
+	p, err := c.GetProfileByID(ctx, id.String())
+	require.NoError(t, err)
+	require.Equal(t, []string{"tech_geek"}, p.Tags)
+	require.EqualValues(t, 2, repo.reads.Load())
+
+	// and so do deletes
+	require.NoError(t, c.DeleteProfile(ctx, id.String()))
+	_, err = c.GetProfileByID(ctx, id.String())
+	require.ErrorIs(t, err, repository.ErrNoProfileFound)
+}
+
+func TestCacheIncludeExpired(t *testing.T) {
+	ctx := repository.IncludeExpired(context.Background())
+	repo := newFakeRepo()
+	c := New(repo, WithTTL(time.Minute))
+
+	id := uuid.New()
+	require.NoError(t, c.UpsertProfile(ctx, model.Profile{ID: id}))
+	for range 2 {
+		_, err := c.GetProfileByID(ctx, id.String())
+		require.NoError(t, err)
+	}
+	require.EqualValues(t, 2, repo.reads.Load())
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo, WithSize(2))
+
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, id := range ids {
+		require.NoError(t, repo.UpsertProfile(ctx, model.Profile{ID: id}))
+		_, err := c.GetProfileByID(ctx, id.String())
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, c.lru.len())
+
+	// the first profile was evicted
+	_, err := c.GetProfileByID(ctx, ids[0].String())
+	require.NoError(t, err)
+	require.EqualValues(t, 4, repo.reads.Load())
+}
+
+func TestCacheWritesDontEvict(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo, WithSize(1))
+
+	hot := uuid.New()
+	require.NoError(t, repo.UpsertProfile(ctx, model.Profile{ID: hot}))
+	_, err := c.GetProfileByID(ctx, hot.String())
+	require.NoError(t, err)
+
+	// writes of other profiles don't take their place
+	for range 3 {
+		require.NoError(t, c.UpsertProfile(ctx, model.Profile{ID: uuid.New()}))
+	}
+	require.Equal(t, 1, c.lru.len())
+	_, err = c.GetProfileByID(ctx, hot.String())
+	require.NoError(t, err)
+	require.EqualValues(t, 1, repo.reads.Load())
+}
+
+func TestCacheValuesBounded(t *testing.T) {
+	e := &entry{values: make(map[string]value)}
+	now := time.Now()
+	for i := range maxValuesPerEntry {
+		e.store(strconv.Itoa(i), value{expiresAt: now.Add(time.Duration(i%2) * time.Minute)}, now)
+	}
+	require.Len(t, e.values, maxValuesPerEntry)
+
+	// the expired values make room
+	e.store("new", value{expiresAt: now.Add(time.Minute)}, now)
+	require.Len(t, e.values, maxValuesPerEntry/2+1)
+	require.Contains(t, e.values, "new")
+
+	// values aren't cached once the entry is full of live ones
+	for i := range maxValuesPerEntry {
+		e.store("live"+strconv.Itoa(i), value{expiresAt: now.Add(time.Minute)}, now)
+	}
+	require.Len(t, e.values, maxValuesPerEntry)
+}
+
+func TestCacheBlobPathsKey(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo)
+
+	id := uuid.NewString()
+	for _, paths := range [][]string{{"a,b"}, {"a", "b"}, {"a,b"}} {
+		data, err := c.GetBlobPaths(ctx, id, paths)
+		require.NoError(t, err)
+		want, _ := json.Marshal(paths)
+		require.JSONEq(t, string(want), string(data))
+	}
+	require.EqualValues(t, 2, repo.reads.Load())
+}
+
+func TestCacheCoalescesMisses(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo)
+
+	id := uuid.New()
+	require.NoError(t, repo.UpsertProfile(ctx, model.Profile{ID: id}))
+
+	repo.block = make(chan struct{})
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetProfileByID(ctx, id.String())
+			require.NoError(t, err)
+		}()
+	}
+	require.Eventually(t, func() bool { return repo.reads.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond) // let the other readers join the in-flight call
+	close(repo.block)
+	wg.Wait()
+
+	require.EqualValues(t, 1, repo.reads.Load())
+}
+
+func TestCacheNegative(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo, WithNegativeTTL(time.Minute))
+
+	id := uuid.New()
+	for range 3 {
+		_, err := c.GetProfileByID(ctx, id.String())
+		require.ErrorIs(t, err, repository.ErrNoProfileFound)
+	}
+	require.EqualValues(t, 1, repo.reads.Load())
+
+	// upserting the profile invalidates the negative entry
+	require.NoError(t, c.UpsertProfile(ctx, model.Profile{ID: id}))
+	p, err := c.GetProfileByID(ctx, id.String())
+	require.NoError(t, err)
+	require.Equal(t, id, p.ID)
+	require.EqualValues(t, 2, repo.reads.Load())
+}
+
+func TestCacheBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+
+	known := uuid.New()
+	require.NoError(t, repo.UpsertProfile(ctx, model.Profile{ID: known}))
+	export := `{"id":"` + known.String() + `","tags":[]}` + "\n"
+	bloom, err := LoadBloom(strings.NewReader(export), 1e-9) // keep false positives out of the test
+	require.NoError(t, err)
+
+	c := New(repo, WithBloomFilter(bloom))
+
+	_, err = c.GetProfileByID(ctx, known.String())
+	require.NoError(t, err)
+	_, err = c.GetProfileByID(ctx, uuid.NewString())
+	require.ErrorIs(t, err, repository.ErrNoProfileFound)
+	require.EqualValues(t, 1, repo.reads.Load())
+
+	// new profiles are added to the filter
+	created := uuid.New()
+	require.NoError(t, c.UpsertProfile(ctx, model.Profile{ID: created}))
+	_, err = c.GetProfileByID(ctx, created.String())
+	require.NoError(t, err)
+
+	// profiles created through other instances are found once the filter is rebuilt
+	other := uuid.New()
+	require.NoError(t, repo.UpsertProfile(ctx, model.Profile{ID: other}))
+	_, err = c.GetProfileByID(ctx, other.String())
+	require.ErrorIs(t, err, repository.ErrNoProfileFound)
+	require.NoError(t, c.RebuildBloom(ctx, 1e-9))
+	for _, id := range []uuid.UUID{known, created, other} {
+		_, err = c.GetProfileByID(ctx, id.String())
+		require.NoError(t, err)
+	}
+}
+
+func TestBloomFalsePositives(t *testing.T) {
+	for _, n := range []int{1, 1000} {
+		bloom := NewBloom(n, 0.01)
+		for range n {
+			bloom.Add(uuid.NewString())
+		}
+		var positives int
+		for range 10000 {
+			if bloom.MayContain(uuid.NewString()) {
+				positives++
+			}
+		}
+		require.Less(t, positives, 200, "%d IDs", n) // twice the rate the filter is sized for
+	}
+}
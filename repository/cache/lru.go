@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// lru is a size-bounded least recently used map of profile ID to cached values.
+// It is not safe for concurrent use.
+type lru struct {
+	size    int
+	ll      *list.List
+	items   map[string]*list.Element
+	version uint64 // last version given to an entry
+}
+
+// maxValuesPerEntry bounds the number of values cached per profile, as their keys are chosen by the clients
+// (segment creation times, blob paths).
+const maxValuesPerEntry = 64
+
+// entry holds every cached value for one profile.
+type entry struct {
+	id string
+	// version changes on invalidation, so that loads started earlier are not stored.
+	// Versions are unique across entries, so that loads started before an eviction aren't stored in the new entry.
+	version uint64
+	values  map[string]value
+}
+
+type value struct {
+	v         any
+	err       error // set for negatively cached lookups
+	expiresAt time.Time
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the entry for id, marking it as recently used.
+func (c *lru) get(id string) (*entry, bool) {
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry), true
+}
+
+// peek returns the entry for id without marking it as recently used.
+func (c *lru) peek(id string) (*entry, bool) {
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*entry), true
+}
+
+// nextVersion returns a version that no entry had before.
+func (c *lru) nextVersion() uint64 {
+	c.version++
+	return c.version
+}
+
+// getOrCreate returns the entry for id, creating it and evicting the least recently used entry if needed.
+func (c *lru) getOrCreate(id string) *entry {
+	if e, ok := c.get(id); ok {
+		return e
+	}
+
+	e := &entry{id: id, version: c.nextVersion(), values: make(map[string]value)}
+	c.items[id] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).id)
+	}
+	return e
+}
+
+// store caches v under key, first removing the expired values when the entry is full.
+// v isn't cached when the entry is still full.
+func (e *entry) store(key string, v value, now time.Time) {
+	if _, ok := e.values[key]; !ok && len(e.values) >= maxValuesPerEntry {
+		for k, v := range e.values {
+			if !now.Before(v.expiresAt) {
+				delete(e.values, k)
+			}
+		}
+		if len(e.values) >= maxValuesPerEntry {
+			return
+		}
+	}
+	e.values[key] = v
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
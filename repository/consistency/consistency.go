@@ -0,0 +1,108 @@
+// Package consistency compares the normalized and blob copies of the profiles written through both designs.
+package consistency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+)
+
+type Status string
+
+const (
+	Consistent     Status = "consistent"
+	Drifted        Status = "drifted"
+	MissingProfile Status = "missing_profile" // only the blob exists
+	MissingBlob    Status = "missing_blob"    // only the normalized profile exists
+	Failed         Status = "error"           // the profile couldn't be checked
+)
+
+// Report is the result of the check of a profile.
+type Report struct {
+	ID     string       `json:"id"`
+	Status Status       `json:"status"`
+	Diffs  []Difference `json:"diffs,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// Checker compares the profiles read with GetProfileByID with the ones read with GetBlob.
+type Checker struct {
+	repo repository.ProfilesRepo
+}
+
+func New(repo repository.ProfilesRepo) *Checker {
+	return &Checker{repo: repo}
+}
+
+// Check compares both copies of the profile identified by id.
+// It fails with repository.ErrNoProfileFound when neither copy exists.
+func (c *Checker) Check(ctx context.Context, id string) (Report, error) {
+	profile, err := c.repo.GetProfileByID(ctx, id)
+	if err != nil && !errors.Is(err, repository.ErrNoProfileFound) {
+		return Report{}, fmt.Errorf("failed to read profile: %w", err)
+	}
+
+	var blob *model.Profile
+	data, err := c.repo.GetBlob(ctx, id)
+	switch {
+	case errors.Is(err, repository.ErrNoProfileFound):
+	case err != nil:
+		return Report{}, fmt.Errorf("failed to read blob: %w", err)
+	default:
+		if err := json.Unmarshal(data, &blob); err != nil {
+			return Report{}, fmt.Errorf("failed to decode blob: %w", err)
+		}
+	}
+
+	report := Report{ID: id}
+	switch {
+	case profile == nil && blob == nil:
+		return Report{}, repository.ErrNoProfileFound
+	case profile == nil:
+		report.Status = MissingProfile
+	case blob == nil:
+		report.Status = MissingBlob
+	default:
+		report.Diffs = Diff(*profile, *blob)
+		report.Status = Consistent
+		if len(report.Diffs) > 0 {
+			report.Status = Drifted
+		}
+	}
+
+	return report, nil
+}
+
+// CheckAll scans the table for the profiles stored in either design and calls fn with their report.
+// Profiles that can't be checked are reported with the Failed status.
+func (c *Checker) CheckAll(ctx context.Context, fn func(Report) error) error {
+	// profiles with both copies are found by both scans
+	seen := make(map[string]struct{})
+	check := func(id string) error {
+		if _, ok := seen[id]; ok {
+			return nil
+		}
+		seen[id] = struct{}{}
+
+		report, err := c.Check(ctx, id)
+		if errors.Is(err, repository.ErrNoProfileFound) {
+			return nil // deleted since the scan
+		}
+		if err != nil {
+			report = Report{ID: id, Status: Failed, Error: err.Error()}
+		}
+		return fn(report)
+	}
+
+	if err := c.repo.ScanProfileIDs(ctx, repository.RepresentationProfile, check); err != nil {
+		return fmt.Errorf("failed to scan profiles: %w", err)
+	}
+	if err := c.repo.ScanProfileIDs(ctx, repository.RepresentationBlob, check); err != nil {
+		return fmt.Errorf("failed to scan blobs: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package consistency
+
+import (
+	"fmt"
+	"personalisation-poc/model"
+	"slices"
+	"time"
+)
+
+// DiffKind tells which copy of the profile a difference comes from.
+type DiffKind string
+
+const (
+	MissingInBlob    DiffKind = "missing_in_blob"
+	MissingInProfile DiffKind = "missing_in_profile"
+	Different        DiffKind = "different"
+)
+
+// Difference is a field that differs between the normalized profile and the blob.
+// Path addresses the field, identifying segments by type and categories by ID,
+// e.g. "segments[morning].categories[sports].score".
+type Difference struct {
+	Path    string   `json:"path"`
+	Kind    DiffKind `json:"kind"`
+	Profile any      `json:"profile,omitempty"`
+	Blob    any      `json:"blob,omitempty"`
+}
+
+// Diff compares the normalized profile with the blob.
+// Tags and top categories are compared as sets since DynamoDB sets don't keep their order,
+// and expiry times with a second precision since TTLs are stored as Unix timestamps.
+// Expiry times missing from the blob aren't compared, the repository sets them from its TTLs.
+func Diff(profile, blob model.Profile) []Difference {
+	var diffs []Difference
+
+	diffs = append(diffs, diffSets("tags", profile.Tags, blob.Tags)...)
+	diffs = append(diffs, diffTimes("", profile.CreatedAt, blob.CreatedAt, profile.UpdatedAt, blob.UpdatedAt, profile.ExpiresAt, blob.ExpiresAt)...)
+
+	profileSegments, blobSegments := segmentsByKey(profile.Segments, blob.Segments)
+	for _, key := range unionKeys(profileSegments, blobSegments) {
+		path := fmt.Sprintf("segments[%s]", key)
+		ps, inProfile := profileSegments[key]
+		bs, inBlob := blobSegments[key]
+		switch {
+		case !inBlob:
+			diffs = append(diffs, Difference{Path: path, Kind: MissingInBlob, Profile: ps})
+		case !inProfile:
+			diffs = append(diffs, Difference{Path: path, Kind: MissingInProfile, Blob: bs})
+		default:
+			diffs = append(diffs, diffSegment(path, ps, bs)...)
+		}
+	}
+
+	return diffs
+}
+
+func diffSegment(path string, profile, blob model.Segment) []Difference {
+	var diffs []Difference
+
+	diffs = append(diffs, diffSets(path+".top_categories", profile.TopCategories, blob.TopCategories)...)
+	diffs = append(diffs, diffTimes(path+".", profile.CreatedAt, blob.CreatedAt, profile.UpdatedAt, blob.UpdatedAt, profile.ExpiresAt, blob.ExpiresAt)...)
+
+	profileScores, blobScores := scoresByID(profile.Categories), scoresByID(blob.Categories)
+	for _, id := range unionKeys(profileScores, blobScores) {
+		catPath := fmt.Sprintf("%s.categories[%s]", path, id)
+		ps, inProfile := profileScores[id]
+		bs, inBlob := blobScores[id]
+		switch {
+		case !inBlob:
+			diffs = append(diffs, Difference{Path: catPath, Kind: MissingInBlob, Profile: ps})
+		case !inProfile:
+			diffs = append(diffs, Difference{Path: catPath, Kind: MissingInProfile, Blob: bs})
+		case ps != bs:
+			diffs = append(diffs, Difference{Path: catPath + ".score", Kind: Different, Profile: ps, Blob: bs})
+		}
+	}
+
+	return diffs
+}
+
+func diffSets(path string, profile, blob []string) []Difference {
+	var diffs []Difference
+	for _, v := range profile {
+		if !slices.Contains(blob, v) {
+			diffs = append(diffs, Difference{Path: fmt.Sprintf("%s[%s]", path, v), Kind: MissingInBlob})
+		}
+	}
+	for _, v := range blob {
+		if !slices.Contains(profile, v) {
+			diffs = append(diffs, Difference{Path: fmt.Sprintf("%s[%s]", path, v), Kind: MissingInProfile})
+		}
+	}
+	return diffs
+}
+
+func diffTimes(prefix string, profileCreated, blobCreated, profileUpdated, blobUpdated, profileExpires, blobExpires time.Time) []Difference {
+	var diffs []Difference
+	if !profileCreated.Equal(blobCreated) {
+		diffs = append(diffs, Difference{Path: prefix + "created_at", Kind: Different, Profile: profileCreated, Blob: blobCreated})
+	}
+	if !profileUpdated.Equal(blobUpdated) {
+		diffs = append(diffs, Difference{Path: prefix + "updated_at", Kind: Different, Profile: profileUpdated, Blob: blobUpdated})
+	}
+	if !blobExpires.IsZero() && profileExpires.Unix() != blobExpires.Unix() {
+		diffs = append(diffs, Difference{Path: prefix + "expires_at", Kind: Different, Profile: profileExpires, Blob: blobExpires})
+	}
+	return diffs
+}
+
+// segmentsByKey indexes the segments by type, adding their creation time when a type isn't unique.
+func segmentsByKey(profile, blob []model.Segment) (map[string]model.Segment, map[string]model.Segment) {
+	unique := uniqueTypes(profile) && uniqueTypes(blob)
+	index := func(segments []model.Segment) map[string]model.Segment {
+		m := make(map[string]model.Segment, len(segments))
+		for _, s := range segments {
+			key := s.Type
+			if !unique {
+				// segments are keyed by type and creation time with a second precision in DynamoDB
+				key = s.Type + "@" + s.CreatedAt.UTC().Format(time.RFC3339)
+			}
+			m[key] = s
+		}
+		return m
+	}
+
+	return index(profile), index(blob)
+}
+
+func uniqueTypes(segments []model.Segment) bool {
+	seen := make(map[string]bool, len(segments))
+	for _, s := range segments {
+		if seen[s.Type] {
+			return false
+		}
+		seen[s.Type] = true
+	}
+	return true
+}
+
+func scoresByID(categories []model.Category) map[string]float64 {
+	m := make(map[string]float64, len(categories))
+	for _, c := range categories {
+		m[c.ID] = c.Score
+	}
+	return m
+}
+
+// unionKeys returns the keys of a and b, sorted.
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,63 @@
+package consistency
+
+import (
+	"personalisation-poc/model"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Now()
+	profile := model.Profile{
+		ID:        uuid.New(),
+		Tags:      []string{"sports_fan", "tech_geek"},
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now,
+		Segments: []model.Segment{
+			{
+				Type:          "morning",
+				Categories:    []model.Category{{ID: "sports", Score: 0.9}, {ID: "technology", Score: 0.4}},
+				TopCategories: []string{"sports", "technology"},
+			},
+			{Type: "evening"},
+		},
+	}
+	require.Empty(t, Diff(profile, profile))
+
+	blob := profile
+	blob.Tags = []string{"tech_geek", "sports_fan"} // order doesn't matter
+	blob.ExpiresAt = now.Truncate(time.Second)      // TTLs have a second precision
+	require.Empty(t, Diff(profile, blob))
+
+	blob.Tags = []string{"tech_geek", "netflix_addict"}
+	blob.Segments = []model.Segment{{
+		Type:          "morning",
+		Categories:    []model.Category{{ID: "sports", Score: 0.5}, {ID: "world", Score: 0.1}},
+		TopCategories: []string{"sports", "technology"},
+	}}
+	require.Equal(t, []Difference{
+		{Path: "tags[sports_fan]", Kind: MissingInBlob},
+		{Path: "tags[netflix_addict]", Kind: MissingInProfile},
+		{Path: "segments[evening]", Kind: MissingInBlob, Profile: profile.Segments[1]},
+		{Path: "segments[morning].categories[sports].score", Kind: Different, Profile: 0.9, Blob: 0.5},
+		{Path: "segments[morning].categories[technology]", Kind: MissingInBlob, Profile: 0.4},
+		{Path: "segments[morning].categories[world]", Kind: MissingInProfile, Blob: 0.1},
+	}, Diff(profile, blob))
+}
+
+func TestDiffSegmentsOfTheSameType(t *testing.T) {
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	profile := model.Profile{Segments: []model.Segment{
+		{Type: "morning", CreatedAt: day},
+		{Type: "morning", CreatedAt: day.AddDate(0, 0, 1)},
+	}}
+	blob := model.Profile{Segments: profile.Segments[:1]}
+
+	require.Equal(t, []Difference{
+		{Path: "segments[morning@2025-01-02T00:00:00Z]", Kind: MissingInBlob, Profile: profile.Segments[1]},
+	}, Diff(profile, blob))
+}
@@ -0,0 +1,31 @@
+package repository
+
+import "context"
+
+type includeExpiredKey struct{}
+
+// IncludeExpired returns a context whose reads also return the items past their expiry time,
+// which DynamoDB only deletes up to a few days later. It is meant for debugging.
+func IncludeExpired(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeExpiredKey{}, true)
+}
+
+// ExpiredIncluded reports whether the reads made with ctx return expired items.
+func ExpiredIncluded(ctx context.Context) bool {
+	included, _ := ctx.Value(includeExpiredKey{}).(bool)
+	return included
+}
+
+type explicitExpiriesKey struct{}
+
+// ExplicitExpiries returns a context whose profile and blob reads only report the expiry dates set explicitly,
+// leaving zero the ones derived from the TTLs. Writing back what's read with it keeps the expiries as they were.
+func ExplicitExpiries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explicitExpiriesKey{}, true)
+}
+
+// ExplicitExpiriesOnly reports whether the reads made with ctx only report the explicit expiry dates.
+func ExplicitExpiriesOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(explicitExpiriesKey{}).(bool)
+	return only
+}
@@ -0,0 +1,197 @@
+// Package convert materialises profiles stored in one representation into the other,
+// for a single profile or in bulk as a background job.
+package convert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultWorkers      = 4
+	defaultJobRetention = time.Hour
+)
+
+var (
+	ErrUnknownDirection = errors.New("unknown conversion direction")
+	ErrInvalidBlob      = repository.NewError(repository.ErrValidation, errors.New("blob is not a valid profile"))
+	// ErrTypedBlob is returned for the profiles whose blob is a document of another type, which isn't converted.
+	ErrTypedBlob = repository.NewError(repository.ErrConflict, errors.New("blob is not a profile"))
+)
+
+// Direction is the source and target representations of a conversion.
+type Direction string
+
+const (
+	// BlobToProfile materialises the BLOB item into USER and SEG items.
+	BlobToProfile Direction = "blob-to-profile"
+	// ProfileToBlob materialises the USER and SEG items into a BLOB item.
+	ProfileToBlob Direction = "profile-to-blob"
+)
+
+func ParseDirection(s string) (Direction, error) {
+	switch dir := Direction(s); dir {
+	case BlobToProfile, ProfileToBlob:
+		return dir, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownDirection, s)
+	}
+}
+
+// source is the representation read by the conversion.
+func (d Direction) source() repository.Representation {
+	if d == BlobToProfile {
+		return repository.RepresentationBlob
+	}
+	return repository.RepresentationProfile
+}
+
+// Repo is the repository the conversions read and write. It must be the table itself rather than a cache,
+// so that conversions read the stored items.
+type Repo interface {
+	repository.ProfilesRepo
+	// BlobType returns the type of the blob stored under profileID, empty for profiles.
+	BlobType(ctx context.Context, profileID string) (string, error)
+}
+
+type Option func(*Converter)
+
+// WithWorkers sets the number of profiles converted concurrently by a bulk job.
+func WithWorkers(n int) Option {
+	return func(c *Converter) {
+		if n > 0 {
+			c.workers = n
+		}
+	}
+}
+
+// WithRate caps the number of profiles converted per second by bulk jobs, all jobs included,
+// so that they leave capacity to the API. Zero or less disables the cap.
+func WithRate(perSecond float64) Option {
+	return func(c *Converter) {
+		if perSecond > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(perSecond), 1)
+		}
+	}
+}
+
+// WithJobRetention sets how long finished jobs are kept, one hour by default.
+func WithJobRetention(d time.Duration) Option {
+	return func(c *Converter) {
+		if d > 0 {
+			c.retention = d
+		}
+	}
+}
+
+// Converter converts profiles between the normalized and blob representations.
+// The target representation is overwritten, items only present in it are left as they are:
+// e.g. segments missing from the blob are not deleted from the normalized profile.
+// The target representation expires with the source one.
+type Converter struct {
+	repo      Repo
+	workers   int
+	limiter   *rate.Limiter // nil when bulk jobs aren't rate limited
+	retention time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func New(repo Repo, opts ...Option) *Converter {
+	c := &Converter{
+		repo:      repo,
+		workers:   defaultWorkers,
+		retention: defaultJobRetention,
+		jobs:      make(map[string]*job),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Convert converts the profile identified by id.
+func (c *Converter) Convert(ctx context.Context, dir Direction, id string) error {
+	switch dir {
+	case BlobToProfile:
+		return c.blobToProfile(ctx, id)
+	case ProfileToBlob:
+		return c.profileToBlob(ctx, id)
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownDirection, dir)
+	}
+}
+
+func (c *Converter) blobToProfile(ctx context.Context, id string) error {
+	if err := c.checkBlobType(ctx, id); err != nil {
+		return err
+	}
+	data, err := c.repo.GetBlob(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	var profile model.Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBlob, err)
+	}
+	if profile.ID == uuid.Nil || profile.ID.String() != id {
+		return fmt.Errorf("%w: id %q doesn't match the blob key", ErrInvalidBlob, profile.ID)
+	}
+	if profile.ExpiresAt.IsZero() {
+		// the profile JSON of blobs usually has no expiry date, the blob item has one
+		if profile.ExpiresAt, err = c.repo.BlobExpiresAt(ctx, id); err != nil {
+			return fmt.Errorf("failed to read blob: %w", err)
+		}
+	}
+
+	if err := c.repo.UpsertProfile(ctx, profile); err != nil {
+		return fmt.Errorf("failed to write profile: %w", err)
+	}
+	return nil
+}
+
+func (c *Converter) profileToBlob(ctx context.Context, id string) error {
+	profile, err := c.repo.GetProfileByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to read profile: %w", err)
+	}
+
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	// a blob of another type shares the key of the profile blob, and isn't overwritten
+	if err := c.checkBlobType(ctx, id); err != nil && !errors.Is(err, repository.ErrNoProfileFound) {
+		return err
+	}
+
+	if err := c.repo.UpsertBlob(ctx, id, data, repository.WithBlobExpiresAt(profile.ExpiresAt)); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	return nil
+}
+
+// checkBlobType fails with ErrTypedBlob when the blob stored under id isn't a profile,
+// and with repository.ErrNoProfileFound when there's none.
+func (c *Converter) checkBlobType(ctx context.Context, id string) error {
+	typ, err := c.repo.BlobType(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to read blob: %w", err)
+	}
+	if typ != "" {
+		return fmt.Errorf("%w: it's of type %q", ErrTypedBlob, typ)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package convert
+
+import (
+	"context"
+	"encoding/json"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRepo stores both representations in memory.
+type fakeRepo struct {
+	repository.ProfilesRepo // unimplemented methods panic
+
+	mu          sync.Mutex
+	profiles    map[string]model.Profile
+	blobs       map[string][]byte
+	blobExpires map[string]time.Time
+	blobTypes   map[string]string
+}
+
+func newFakeRepo() *fakeRepo {
+	return &fakeRepo{profiles: make(map[string]model.Profile), blobs: make(map[string][]byte), blobExpires: make(map[string]time.Time), blobTypes: make(map[string]string)}
+}
+
+func (f *fakeRepo) GetProfileByID(ctx context.Context, id string) (*model.Profile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.profiles[id]
+	if !ok {
+		return nil, repository.ErrNoProfileFound
+	}
+	return &p, nil
+}
+
+func (f *fakeRepo) UpsertProfile(ctx context.Context, profile model.Profile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.profiles[profile.ID.String()] = profile
+	return nil
+}
+
+func (f *fakeRepo) GetBlob(ctx context.Context, id string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.blobs[id]
+	if !ok {
+		return nil, repository.ErrNoProfileFound
+	}
+	return b, nil
+}
+
+func (f *fakeRepo) UpsertBlob(ctx context.Context, id string, data []byte, opts ...repository.BlobOption) error {
+	var options repository.BlobOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blobs[id] = data
+	f.blobExpires[id] = options.ExpiresAt
+	f.blobTypes[id] = options.Type
+	return nil
+}
+
+func (f *fakeRepo) BlobType(ctx context.Context, id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.blobs[id]; !ok {
+		return "", repository.ErrNoProfileFound
+	}
+	return f.blobTypes[id], nil
+}
+
+func (f *fakeRepo) BlobExpiresAt(ctx context.Context, id string) (time.Time, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.blobs[id]; !ok {
+		return time.Time{}, repository.ErrNoProfileFound
+	}
+	return f.blobExpires[id], nil
+}
+
+func (f *fakeRepo) ScanProfileIDs(ctx context.Context, rep repository.Representation, fn func(id string) error) error {
+	f.mu.Lock()
+	var ids []string
+	if rep == repository.RepresentationBlob {
+		for id := range f.blobs {
+			if f.blobTypes[id] == "" {
+				ids = append(ids, id)
+			}
+		}
+	} else {
+		for id := range f.profiles {
+			ids = append(ids, id)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, id := range ids {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestConvert(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo)
+
+	profile := model.Profile{
+		ID:       uuid.New(),
+		Tags:     []string{"sports_fan"},
+		Segments: []model.Segment{{Type: "morning", Categories: []model.Category{{ID: "sports", Score: 0.9}}}},
+	}
+	require.NoError(t, repo.UpsertProfile(ctx, profile))
+
+	require.NoError(t, c.Convert(ctx, ProfileToBlob, profile.ID.String()))
+	var blob model.Profile
+	require.NoError(t, json.Unmarshal(repo.blobs[profile.ID.String()], &blob))
+	require.Equal(t, profile.Segments, blob.Segments)
+
+	// and back, once the normalized copy is gone
+	delete(repo.profiles, profile.ID.String())
+	require.NoError(t, c.Convert(ctx, BlobToProfile, profile.ID.String()))
+	require.Equal(t, profile.Tags, repo.profiles[profile.ID.String()].Tags)
+
+	// the converted representation expires with the source one
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	repo.blobExpires[profile.ID.String()] = expiresAt
+	require.NoError(t, c.Convert(ctx, BlobToProfile, profile.ID.String()))
+	require.Equal(t, expiresAt, repo.profiles[profile.ID.String()].ExpiresAt)
+	delete(repo.blobs, profile.ID.String())
+	require.NoError(t, c.Convert(ctx, ProfileToBlob, profile.ID.String()))
+	require.Equal(t, expiresAt, repo.blobExpires[profile.ID.String()])
+
+	err := c.Convert(ctx, ProfileToBlob, uuid.NewString())
+	require.ErrorIs(t, err, repository.ErrNoProfileFound)
+
+	// the blob must describe the profile it's stored under
+	other := uuid.NewString()
+	repo.blobs[other] = repo.blobs[profile.ID.String()]
+	err = c.Convert(ctx, BlobToProfile, other)
+	require.ErrorIs(t, err, ErrInvalidBlob)
+}
+
+func TestJob(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo, WithWorkers(2))
+
+	for range 10 {
+		require.NoError(t, repo.UpsertProfile(ctx, model.Profile{ID: uuid.New()}))
+	}
+	repo.blobs[uuid.NewString()] = []byte(`{"id": "not a uuid"}`)
+
+	job, err := c.StartJob(ProfileToBlob)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, _ = c.Job(job.ID)
+		return job.State != JobRunning
+	}, time.Second, time.Millisecond)
+	require.Equal(t, JobSucceeded, job.State)
+	require.Equal(t, 10, job.Converted)
+	require.Len(t, repo.blobs, 11)
+
+	job, err = c.StartJob(BlobToProfile)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, _ = c.Job(job.ID)
+		return job.State != JobRunning
+	}, time.Second, time.Millisecond)
+	require.Equal(t, JobFailed, job.State)
+	require.Equal(t, 10, job.Converted)
+	require.Equal(t, 1, job.Failed)
+	require.Len(t, job.Errors, 1)
+
+	require.Len(t, c.Jobs(), 2)
+	_, err = c.StartJob("sideways")
+	require.ErrorIs(t, err, ErrUnknownDirection)
+}
+
+func TestJobRate(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo, WithRate(20))
+
+	for range 5 {
+		require.NoError(t, repo.UpsertProfile(ctx, model.Profile{ID: uuid.New()}))
+	}
+
+	start := time.Now()
+	job, err := c.StartJob(ProfileToBlob)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, _ = c.Job(job.ID)
+		return job.State != JobRunning
+	}, 5*time.Second, time.Millisecond)
+	require.Equal(t, 5, job.Converted)
+	// the first conversion is immediate, the others wait 50ms each
+	require.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestJobEviction(t *testing.T) {
+	repo := newFakeRepo()
+	c := New(repo, WithJobRetention(time.Hour))
+
+	now := time.Now()
+	add := func(finishedAt *time.Time) string {
+		j := &job{status: JobStatus{ID: uuid.NewString(), State: JobSucceeded, StartedAt: now, FinishedAt: finishedAt}}
+		if finishedAt == nil {
+			j.status.State = JobRunning
+		}
+		c.jobs[j.status.ID] = j
+		return j.status.ID
+	}
+	ago := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+
+	running := add(nil)
+	expired := add(ago(2 * time.Hour))
+	oldest := add(ago(time.Duration(maxFinishedJobs) * time.Second))
+	for i := range maxFinishedJobs {
+		add(ago(time.Duration(i) * time.Second))
+	}
+
+	require.Len(t, c.Jobs(), maxFinishedJobs+1)
+	_, ok := c.Job(running)
+	require.True(t, ok, "running jobs are kept")
+	_, ok = c.Job(expired)
+	require.False(t, ok, "jobs finished for longer than the retention are evicted")
+	_, ok = c.Job(oldest)
+	require.False(t, ok, "the oldest finished jobs are evicted above the cap")
+}
+
+func TestConvertTypedBlob(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepo()
+	c := New(repo)
+
+	profile := model.Profile{ID: uuid.New(), Tags: []string{"sports_fan"}}
+	id := profile.ID.String()
+	require.NoError(t, repo.UpsertProfile(ctx, profile))
+	order := []byte(`{"id":"` + id + `","total":42}`)
+	require.NoError(t, repo.UpsertBlob(ctx, id, order, repository.WithBlobType("order")))
+
+	// the blobs of other types are neither overwritten nor read as profiles
+	require.ErrorIs(t, c.Convert(ctx, ProfileToBlob, id), ErrTypedBlob)
+	require.Equal(t, order, repo.blobs[id])
+	require.Equal(t, "order", repo.blobTypes[id])
+	delete(repo.profiles, id)
+	require.ErrorIs(t, c.Convert(ctx, BlobToProfile, id), ErrTypedBlob)
+	require.NotContains(t, repo.profiles, id)
+
+	// and the jobs skip them
+	job, err := c.StartJob(BlobToProfile)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, _ = c.Job(job.ID)
+		return job.State != JobRunning
+	}, time.Second, time.Millisecond)
+	require.Equal(t, JobSucceeded, job.State)
+	require.Zero(t, job.Converted)
+	require.NotContains(t, repo.profiles, id)
+}
@@ -0,0 +1,239 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxJobErrors caps the number of per-profile errors kept in a job status.
+	maxJobErrors = 100
+	// maxFinishedJobs caps the number of finished jobs kept, the oldest ones being evicted first.
+	maxFinishedJobs = 100
+)
+
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded" // every profile was converted
+	JobFailed    JobState = "failed"    // the scan failed or some profiles couldn't be converted
+	JobCancelled JobState = "cancelled"
+)
+
+// JobStatus is a snapshot of the progress of a bulk conversion.
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Direction  Direction  `json:"direction"`
+	State      JobState   `json:"state"`
+	Converted  int        `json:"converted"`
+	Failed     int        `json:"failed"`
+	Errors     []JobError `json:"errors,omitempty"` // the first errors only
+	Error      string     `json:"error,omitempty"`  // why the job stopped before converting every profile
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type JobError struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+type job struct {
+	mu     sync.Mutex
+	status JobStatus
+	cancel context.CancelFunc
+}
+
+func (j *job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := j.status
+	status.Errors = slices.Clone(j.status.Errors)
+	return status
+}
+
+// finishedAt returns when the job finished, nil while it's running.
+func (j *job) finishedAt() *time.Time {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status.FinishedAt
+}
+
+func (j *job) record(id string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err == nil {
+		j.status.Converted++
+		return
+	}
+	j.status.Failed++
+	if len(j.status.Errors) < maxJobErrors {
+		j.status.Errors = append(j.status.Errors, JobError{ID: id, Error: err.Error()})
+	}
+}
+
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.status.FinishedAt = &now
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.status.State = JobCancelled
+	case err != nil:
+		j.status.State = JobFailed
+		j.status.Error = err.Error()
+	case j.status.Failed > 0:
+		j.status.State = JobFailed
+	default:
+		j.status.State = JobSucceeded
+	}
+}
+
+// StartJob converts every profile stored in the source representation of dir in the background.
+// Jobs are kept in memory: they stop with the process and are only visible on the instance running them.
+// Finished jobs are kept for the retention of the converter.
+func (c *Converter) StartJob(dir Direction) (JobStatus, error) {
+	if _, err := ParseDirection(string(dir)); err != nil {
+		return JobStatus{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		status: JobStatus{
+			ID:        uuid.NewString(),
+			Direction: dir,
+			State:     JobRunning,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	c.mu.Lock()
+	c.evictJobs(time.Now())
+	c.jobs[j.status.ID] = j
+	c.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		j.finish(c.run(ctx, j, dir))
+	}()
+
+	return j.snapshot(), nil
+}
+
+func (c *Converter) run(ctx context.Context, j *job, dir Direction) error {
+	ids := make(chan string)
+	var wg sync.WaitGroup
+	for range c.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				// let conversions in progress finish when the job is cancelled
+				j.record(id, c.Convert(context.WithoutCancel(ctx), dir, id))
+			}
+		}()
+	}
+
+	err := c.repo.ScanProfileIDs(ctx, dir.source(), func(id string) error {
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		select {
+		case ids <- id:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(ids)
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	return err
+}
+
+// Job returns the status of the job identified by id.
+func (c *Converter) Job(id string) (JobStatus, bool) {
+	c.mu.Lock()
+	j, ok := c.jobs[id]
+	c.mu.Unlock()
+	if !ok {
+		return JobStatus{}, false
+	}
+
+	return j.snapshot(), true
+}
+
+// Jobs returns the status of all the jobs, most recent first.
+func (c *Converter) Jobs() []JobStatus {
+	c.mu.Lock()
+	c.evictJobs(time.Now())
+	jobs := make([]JobStatus, 0, len(c.jobs))
+	for _, j := range c.jobs {
+		jobs = append(jobs, j.snapshot())
+	}
+	c.mu.Unlock()
+
+	slices.SortFunc(jobs, func(a, b JobStatus) int {
+		if c := b.StartedAt.Compare(a.StartedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return jobs
+}
+
+// CancelJob stops the job identified by id. Profiles being converted are finished first.
+func (c *Converter) CancelJob(id string) bool {
+	c.mu.Lock()
+	j, ok := c.jobs[id]
+	c.mu.Unlock()
+	if ok {
+		j.cancel()
+	}
+
+	return ok
+}
+
+// evictJobs drops the jobs finished for longer than the retention, then the oldest finished jobs
+// above maxFinishedJobs. c.mu must be held.
+func (c *Converter) evictJobs(now time.Time) {
+	type finishedJob struct {
+		id string
+		at time.Time
+	}
+	var finished []finishedJob
+	for id, j := range c.jobs {
+		at := j.finishedAt()
+		switch {
+		case at == nil:
+		case now.Sub(*at) > c.retention:
+			delete(c.jobs, id)
+		default:
+			finished = append(finished, finishedJob{id: id, at: *at})
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+
+	slices.SortFunc(finished, func(a, b finishedJob) int { return a.at.Compare(b.at) })
+	for _, j := range finished[:len(finished)-maxFinishedJobs] {
+		delete(c.jobs, j.id)
+	}
+}
@@ -0,0 +1,101 @@
+package ddb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"testing"
+	"time"
+
+	"github.com/guregu/dynamo/v2"
+)
+
+// benchProfileSizes are the profile shapes benchmarked: categories per segment, and versions of each segment type.
+var benchProfileSizes = []struct {
+	categories int
+	history    int
+}{
+	{categories: 5, history: 1},
+	{categories: 5, history: 30},
+	{categories: 100, history: 1},
+	{categories: 100, history: 30},
+}
+
+// BenchmarkDesigns compares the latency, allocations and consumed capacity of the normalized and blob designs
+// against DynamoDB Local. Latencies only compare the designs with each other: DynamoDB Local isn't DynamoDB.
+//
+//	go test ./repository/ddb -run '^$' -bench Designs -benchmem
+func BenchmarkDesigns(b *testing.B) {
+	endpoint, table := startDynamoDB(b)
+	db := NewDB(dynamo.NewFromIface(newTestClient(endpoint, nil, ReturnConsumedCapacity)).Table(table))
+
+	vocabulary := make([]string, 100)
+	for i := range vocabulary {
+		vocabulary[i] = fmt.Sprintf("category_%03d", i)
+	}
+
+	for i, size := range benchProfileSizes {
+		gen := model.NewGenerator(
+			model.WithSeed(int64(i+1)), // every size has its own profile, and partition
+			model.WithNow(time.Now()),
+			model.WithCategories(vocabulary[:size.categories]...),
+			model.WithCategoriesPerSegment(size.categories, size.categories),
+			model.WithHistory(size.history, time.Hour),
+		)
+		profile := gen.Profile()
+		id := profile.ID.String()
+		blob, err := json.Marshal(profile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		name := fmt.Sprintf("categories=%d/history=%d", size.categories, size.history)
+
+		b.Run(name+"/UpsertProfile", func(b *testing.B) {
+			benchmarkCall(b, func(ctx context.Context) error { return db.UpsertProfile(ctx, *profile) })
+		})
+		b.Run(name+"/GetProfileByID", func(b *testing.B) {
+			if err := db.UpsertProfile(context.Background(), *profile); err != nil {
+				b.Fatal(err)
+			}
+			benchmarkCall(b, func(ctx context.Context) error {
+				_, err := db.GetProfileByID(ctx, id)
+				return err
+			})
+		})
+		for _, enc := range []repository.BlobEncoding{repository.BlobEncodingMap, repository.BlobEncodingZstd} {
+			b.Run(fmt.Sprintf("%s/UpsertBlob/%s", name, enc), func(b *testing.B) {
+				b.ReportMetric(float64(len(blob)), "json-bytes")
+				benchmarkCall(b, func(ctx context.Context) error { return db.UpsertBlob(ctx, id, blob, repository.WithBlobEncoding(enc)) })
+			})
+			// every read benchmark writes its fixture, so that it can be run on its own with -bench
+			b.Run(fmt.Sprintf("%s/GetBlob/%s", name, enc), func(b *testing.B) {
+				if err := db.UpsertBlob(context.Background(), id, blob, repository.WithBlobEncoding(enc)); err != nil {
+					b.Fatal(err)
+				}
+				benchmarkCall(b, func(ctx context.Context) error {
+					_, err := db.GetBlob(ctx, id)
+					return err
+				})
+			})
+		}
+	}
+}
+
+// benchmarkCall benchmarks call, reporting the capacity it consumes per operation.
+func benchmarkCall(b *testing.B, call func(ctx context.Context) error) {
+	ctx, capacity := RecordCapacity(context.Background())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := call(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	read, write := capacity.Units()
+	b.ReportMetric(read/float64(b.N), "RCU/op")
+	b.ReportMetric(write/float64(b.N), "WCU/op")
+}
@@ -2,35 +2,126 @@ package ddb
 
 import (
 	"encoding/json"
+	"fmt"
+	"personalisation-poc/repository"
+	"strconv"
 	"time"
 )
 
 const (
 	blobItemKeyPrefix = "BLOB"
+	blobChunkItemType = "BLOBCHUNK"
+
+	// blobChunkSize is the maximum payload stored in a single item.
+	// It leaves room under the 400KB DynamoDB item size limit for keys and attribute names.
+	blobChunkSize = 350 * 1024
 )
 
+// blob is the head item of a blob. Map encoded blobs are stored in Data.
+// Binary encoded blobs are stored in Payload, or split across Chunks blobChunk items when oversize.
+// The chunks of each write have their own Version, so that a read never mixes the chunks of two writes.
 type blob struct {
 	PK       string `dynamo:"pk,hash"`  // partition key
 	SK       string `dynamo:"sk,range"` // sort key
 	ItemType string `dynamo:"typ"`      // item type
 	ID       string `dynamo:"id"`
-	TTL      int64  `dynamo:"ttl,unixtime"`
-	Data     any    `dynamo:"rawdata"`
+	TTL      int64  `dynamo:"ttl,omitempty"`
+	FixedTTL bool   `dynamo:"ttl_fixed,omitempty"` // TTL set from an explicit expiry date, never slid
+	Data     any    `dynamo:"rawdata,omitempty"`
+	Encoding string `dynamo:"enc,omitempty"`
+	Payload  []byte `dynamo:"payload,omitempty"`
+	Chunks   int    `dynamo:"chunks,omitempty"`
+	Version  string `dynamo:"ver,omitempty"`
+	Type     string `dynamo:"btype,omitempty"` // empty for profiles
 }
 
-func toDBBlob(profileID string, data []byte) (blob, error) {
-	// Parse JSON into interface{} so DynamoDB can store it as a native map
-	var jsonData any
-	if err := json.Unmarshal(data, &jsonData); err != nil {
-		return blob{}, err
+// blobChunk holds the n-th part of an oversize binary blob, under SK BLOB#{id}#{version}#{n}.
+// Chunks written before versions existed are under SK BLOB#{id}#{n}.
+type blobChunk struct {
+	PK       string `dynamo:"pk,hash"`  // partition key
+	SK       string `dynamo:"sk,range"` // sort key
+	ItemType string `dynamo:"typ"`      // item type
+	Index    int    `dynamo:"n"`
+	TTL      int64  `dynamo:"ttl,omitempty"`
+	Payload  []byte `dynamo:"payload"`
+}
+
+func buildBlobChunkSK(profileID, version string, n int) string {
+	return blobChunkSKPrefix(profileID, version) + strconv.Itoa(n)
+}
+
+// blobChunkSKPrefix is the prefix shared by the sort keys of the chunks of a version of a blob.
+func blobChunkSKPrefix(profileID, version string) string {
+	prefix := buildSK(blobItemKeyPrefix, profileID, nil) + keySeparator
+	if version != "" {
+		prefix += version + keySeparator
 	}
+	return prefix
+}
+
+// newBlobVersion returns the version of the chunks of a new write.
+func newBlobVersion() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
 
-	return blob{
+// toDBBlob builds the items of a blob expiring at expiresAt, or after ttl when it's zero.
+func toDBBlob(profileID string, data []byte, enc repository.BlobEncoding, expiresAt time.Time, ttl time.Duration) (blob, []blobChunk, error) {
+	b := blob{
 		PK:       buildPK(profileID),
 		SK:       buildSK(blobItemKeyPrefix, profileID, nil),
 		ItemType: blobItemKeyPrefix,
 		ID:       profileID,
-		TTL:      time.Now().AddDate(1, 0, 0).Unix(), // 1 year
-		Data:     jsonData,
-	}, nil
+		TTL:      expiry(expiresAt, ttl),
+		FixedTTL: !expiresAt.IsZero(),
+	}
+
+	if enc == repository.BlobEncodingMap {
+		// Parse JSON into interface{} so DynamoDB can store it as a native map
+		var jsonData any
+		if err := json.Unmarshal(data, &jsonData); err != nil {
+			return blob{}, nil, err
+		}
+		b.Data = jsonData
+		return b, nil, nil
+	}
+
+	if !json.Valid(data) {
+		return blob{}, nil, fmt.Errorf("invalid JSON")
+	}
+	payload, err := compress(enc, data)
+	if err != nil {
+		return blob{}, nil, err
+	}
+	b.Encoding = string(enc)
+
+	if len(payload) <= blobChunkSize {
+		b.Payload = payload
+		return b, nil, nil
+	}
+
+	var chunks []blobChunk
+	b.Version = newBlobVersion()
+	for n := 0; len(payload) > 0; n++ {
+		size := min(blobChunkSize, len(payload))
+		chunks = append(chunks, blobChunk{
+			PK:       b.PK,
+			SK:       buildBlobChunkSK(profileID, b.Version, n),
+			ItemType: blobChunkItemType,
+			Index:    n,
+			TTL:      b.TTL,
+			Payload:  payload[:size],
+		})
+		payload = payload[size:]
+	}
+	b.Chunks = len(chunks)
+
+	return b, chunks, nil
+}
+
+// blobEncoding returns the encoding of a stored blob. Blobs written before encodings existed are maps.
+func (b blob) blobEncoding() repository.BlobEncoding {
+	if b.Encoding == "" {
+		return repository.BlobEncodingMap
+	}
+	return repository.BlobEncoding(b.Encoding)
 }
@@ -0,0 +1,117 @@
+package ddb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkedBlob returns a blob of id that needs several chunks once compressed, random hex compressing poorly.
+func chunkedBlob(t *testing.T, id string, seed uint64) []byte {
+	rnd := rand.New(rand.NewPCG(seed, 2))
+	random := make([]byte, 600*1024)
+	for i := range random {
+		random[i] = byte(rnd.IntN(256))
+	}
+	data, err := json.Marshal(map[string]any{"id": id, "random": hex.EncodeToString(random)})
+	require.NoError(t, err)
+	return data
+}
+
+func TestToDBBlobChunking(t *testing.T) {
+	data := chunkedBlob(t, "123", 1)
+
+	for _, enc := range []repository.BlobEncoding{repository.BlobEncodingGzip, repository.BlobEncodingZstd} {
+		t.Run(string(enc), func(t *testing.T) {
+			head, chunks, err := toDBBlob("123", data, enc, time.Time{}, 0)
+			require.NoError(t, err)
+			require.Equal(t, string(enc), head.Encoding)
+			require.Nil(t, head.Data)
+
+			require.Greater(t, len(chunks), 1)
+			require.Nil(t, head.Payload)
+			require.Equal(t, len(chunks), head.Chunks)
+			require.NotEmpty(t, head.Version)
+
+			parts := make([][]byte, 0, len(chunks))
+			for n, chunk := range chunks {
+				require.Equal(t, n, chunk.Index)
+				require.Equal(t, "BLOB#123#"+head.Version+"#"+strconv.Itoa(n), chunk.SK)
+				require.LessOrEqual(t, len(chunk.Payload), blobChunkSize)
+				parts = append(parts, chunk.Payload)
+			}
+
+			decoded, err := decompress(enc, bytes.Join(parts, nil))
+			require.NoError(t, err)
+			require.Equal(t, data, decoded)
+		})
+	}
+}
+
+func TestToDBBlobMap(t *testing.T) {
+	head, chunks, err := toDBBlob("123", []byte(`{"id":"123","segments":[]}`), repository.BlobEncodingMap, time.Time{}, 0)
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+	require.Empty(t, head.Encoding)
+	require.Equal(t, map[string]any{"id": "123", "segments": []any{}}, head.Data)
+
+	_, _, err = toDBBlob("123", []byte(`not json`), repository.BlobEncodingGzip, time.Time{}, 0)
+	require.Error(t, err)
+}
+
+func TestUpsertChunkedBlobDynamoDBLocal(t *testing.T) {
+	endpoint, name := startDynamoDB(t)
+	ctx := testContext(t)
+	table := newTestTable(endpoint, name, &http.Client{})
+	db := NewDB(table)
+
+	id := uuid.NewString()
+	first, second := chunkedBlob(t, id, 1), chunkedBlob(t, id, 2)
+	require.NoError(t, db.UpsertBlob(ctx, id, first, repository.WithBlobEncoding(repository.BlobEncodingGzip)))
+	require.NoError(t, db.UpsertBlob(ctx, id, second, repository.WithBlobEncoding(repository.BlobEncodingGzip)))
+
+	data, err := db.GetBlob(ctx, id)
+	require.NoError(t, err)
+	require.JSONEq(t, string(second), string(data))
+
+	// only the chunks of the second version are left
+	var head blob
+	require.NoError(t, table.Get(partitionKey, buildPK(id)).Range(sortKey, dynamo.Equal, buildSK(blobItemKeyPrefix, id, nil)).One(ctx, &head))
+	var chunks []blobChunk
+	require.NoError(t, table.Get(partitionKey, buildPK(id)).Range(sortKey, dynamo.BeginsWith, blobChunkSKPrefix(id, "")).All(ctx, &chunks))
+	require.Len(t, chunks, head.Chunks)
+	for _, chunk := range chunks {
+		require.True(t, strings.HasPrefix(chunk.SK, blobChunkSKPrefix(id, head.Version)), chunk.SK)
+	}
+}
+
+func TestGetProfileSkipsBlobDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := testContext(t)
+	db := NewDB(dynamo.NewFromIface(newTestClient(endpoint, nil, ReturnConsumedCapacity)).Table(table))
+
+	profile := model.FakeProfile()
+	id := profile.ID.String()
+	require.NoError(t, db.UpsertProfile(ctx, *profile))
+	require.NoError(t, db.UpsertBlob(ctx, id, chunkedBlob(t, id, 1), repository.WithBlobEncoding(repository.BlobEncodingGzip)))
+
+	// the blob chunks sharing the partition, hundreds of KB, aren't read
+	ctx, capacity := RecordCapacity(ctx)
+	got, err := db.GetProfileByID(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, profile.ID, got.ID)
+	read, _ := capacity.Units()
+	require.LessOrEqual(t, read, 1.0)
+}
@@ -0,0 +1,137 @@
+package ddb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Capacity accumulates the capacity units consumed by the DynamoDB calls made with a context,
+// e.g. to attribute the cost of the table to the requests of the clients. It's safe for concurrent use.
+type Capacity struct {
+	mu    sync.Mutex
+	read  float64
+	write float64
+}
+
+type capacityCtxKey struct{}
+
+// RecordCapacity returns a context accumulating the capacity consumed by the calls made with it into the returned Capacity.
+// Only the calls of clients configured with ReturnConsumedCapacity are accounted for.
+func RecordCapacity(ctx context.Context) (context.Context, *Capacity) {
+	c := &Capacity{}
+	return context.WithValue(ctx, capacityCtxKey{}, c), c
+}
+
+// CapacityFromContext returns the Capacity recorded by ctx, nil if it doesn't record one.
+func CapacityFromContext(ctx context.Context) *Capacity {
+	c, _ := ctx.Value(capacityCtxKey{}).(*Capacity)
+	return c
+}
+
+// Units returns the read and write capacity units consumed so far.
+func (c *Capacity) Units() (read, write float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.read, c.write
+}
+
+func (c *Capacity) add(read, write float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.read += read
+	c.write += write
+}
+
+// ReturnConsumedCapacity configures a DynamoDB client to ask for the capacity consumed by every call,
+// and to add it to the Capacity recorded by the call's context. Calls of the repository then report their cost:
+//
+//	dynamo.New(cfg, ddb.ReturnConsumedCapacity)
+func ReturnConsumedCapacity(o *dynamodb.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("ReturnConsumedCapacity", consumedCapacity), middleware.After)
+	})
+}
+
+func consumedCapacity(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+	// the capacity is asked for even without a Capacity to add it to: it's free, and it keeps the calls the same
+	const total = types.ReturnConsumedCapacityTotal
+	switch params := in.Parameters.(type) {
+	case *dynamodb.GetItemInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.QueryInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.ScanInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.BatchGetItemInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.PutItemInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.UpdateItemInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.DeleteItemInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.BatchWriteItemInput:
+		params.ReturnConsumedCapacity = total
+	case *dynamodb.TransactWriteItemsInput:
+		params.ReturnConsumedCapacity = total
+	}
+
+	out, md, err := next.HandleInitialize(ctx, in)
+	c := CapacityFromContext(ctx)
+	if err != nil || c == nil {
+		return out, md, err
+	}
+
+	var (
+		consumed []types.ConsumedCapacity
+		read     bool
+	)
+	switch result := out.Result.(type) {
+	case *dynamodb.GetItemOutput:
+		consumed, read = appendCapacity(consumed, result.ConsumedCapacity), true
+	case *dynamodb.QueryOutput:
+		consumed, read = appendCapacity(consumed, result.ConsumedCapacity), true
+	case *dynamodb.ScanOutput:
+		consumed, read = appendCapacity(consumed, result.ConsumedCapacity), true
+	case *dynamodb.BatchGetItemOutput:
+		consumed, read = result.ConsumedCapacity, true
+	case *dynamodb.PutItemOutput:
+		consumed = appendCapacity(consumed, result.ConsumedCapacity)
+	case *dynamodb.UpdateItemOutput:
+		consumed = appendCapacity(consumed, result.ConsumedCapacity)
+	case *dynamodb.DeleteItemOutput:
+		consumed = appendCapacity(consumed, result.ConsumedCapacity)
+	case *dynamodb.BatchWriteItemOutput:
+		consumed = result.ConsumedCapacity
+	case *dynamodb.TransactWriteItemsOutput:
+		consumed = result.ConsumedCapacity
+	}
+
+	for _, cc := range consumed {
+		// the split between reads and writes isn't always reported, but a call only does one or the other
+		if read {
+			c.add(value(cc.CapacityUnits), 0)
+		} else {
+			c.add(0, value(cc.CapacityUnits))
+		}
+	}
+	return out, md, err
+}
+
+func appendCapacity(consumed []types.ConsumedCapacity, c *types.ConsumedCapacity) []types.ConsumedCapacity {
+	if c == nil {
+		return consumed
+	}
+	return append(consumed, *c)
+}
+
+func value(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
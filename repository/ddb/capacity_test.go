@@ -0,0 +1,66 @@
+package ddb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripFunc answers the requests of a client without a server.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestReturnConsumedCapacity(t *testing.T) {
+	var mu sync.Mutex
+	var asked []string
+	client := newTestClient("http://localhost", &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var input struct {
+			ReturnConsumedCapacity string
+		}
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		asked = append(asked, input.ReturnConsumedCapacity)
+		mu.Unlock()
+
+		units := 0.5
+		if strings.HasSuffix(req.Header.Get("X-Amz-Target"), "PutItem") {
+			units = 2
+		}
+		return dynamoResponse(req, http.StatusOK, map[string]any{
+			"ConsumedCapacity": map[string]any{"TableName": testTableName, "CapacityUnits": units},
+		}), nil
+	})}, ReturnConsumedCapacity)
+
+	key := map[string]types.AttributeValue{partitionKey: &types.AttributeValueMemberS{Value: "USER#1"}}
+	ctx, capacity := RecordCapacity(context.Background())
+	for range 2 {
+		_, err := client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(testTableName), Key: key})
+		require.NoError(t, err)
+	}
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(testTableName), Item: key})
+	require.NoError(t, err)
+
+	read, write := capacity.Units()
+	require.Equal(t, 1.0, read)
+	require.Equal(t, 2.0, write)
+	require.Equal(t, []string{"TOTAL", "TOTAL", "TOTAL"}, asked)
+
+	// calls made without a Capacity aren't accounted for
+	_, err = client.GetItem(context.Background(), &dynamodb.GetItemInput{TableName: aws.String(testTableName), Key: key})
+	require.NoError(t, err)
+	read, _ = capacity.Units()
+	require.Equal(t, 1.0, read)
+}
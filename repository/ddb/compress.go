@@ -0,0 +1,52 @@
+package ddb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"personalisation-poc/repository"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// zstd encoders and decoders are safe for concurrent use with EncodeAll and DecodeAll.
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func compress(enc repository.BlobEncoding, data []byte) ([]byte, error) {
+	switch enc {
+	case repository.BlobEncodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case repository.BlobEncodingZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported blob encoding %q", enc)
+	}
+}
+
+func decompress(enc repository.BlobEncoding, data []byte) ([]byte, error) {
+	switch enc {
+	case repository.BlobEncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case repository.BlobEncodingZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported blob encoding %q", enc)
+	}
+}
@@ -1,6 +1,7 @@
 package ddb
 
 import (
+	"context"
 	"personalisation-poc/repository"
 	"time"
 
@@ -11,26 +12,74 @@ var _ repository.ProfilesRepo = &DB{} // compile time check
 
 type Option func(*DB)
 
-// WithTTL updates the items' Time To Live. By default it's zero (unlimited).
+// WithTTL sets the Time To Live of all the item types. By default it's zero (unlimited).
 func WithTTL(ttl time.Duration) Option {
 	return func(db *DB) {
-		db.ttl = ttl
+		db.userTTL, db.segmentTTL, db.blobTTL = ttl, ttl, ttl
+	}
+}
+
+// WithUserTTL sets the Time To Live of the USER items of profiles written without an expiry date.
+func WithUserTTL(ttl time.Duration) Option {
+	return func(db *DB) {
+		db.userTTL = ttl
+	}
+}
+
+// WithSegmentTTL sets the Time To Live of the SEG items of segments written without an expiry date.
+func WithSegmentTTL(ttl time.Duration) Option {
+	return func(db *DB) {
+		db.segmentTTL = ttl
+	}
+}
+
+// WithBlobTTL sets the Time To Live of the BLOB items.
+func WithBlobTTL(ttl time.Duration) Option {
+	return func(db *DB) {
+		db.blobTTL = ttl
+	}
+}
+
+// WithSlidingExpiry extends the TTL of the items of a profile by their item type's TTL when the profile is read,
+// so that active users don't expire. To save writes, TTLs extended less than interval ago are left as they are.
+// By default it's zero (disabled).
+func WithSlidingExpiry(interval time.Duration) Option {
+	return func(db *DB) {
+		db.sliding = interval
+	}
+}
+
+// WithMaxInFlight caps the number of concurrent DynamoDB calls.
+// Calls made while the cap is reached fail fast with repository.ErrOverloaded. By default there is no cap.
+func WithMaxInFlight(n int) Option {
+	return func(db *DB) {
+		if n > 0 {
+			db.inFlight = make(chan struct{}, n)
+		}
 	}
 }
 
 // DB implements the ProfilesRepo interface backed by a DynamoDB table.
 // It follows the principles of Single Table Design.
 type DB struct {
-	table dynamo.Table
-	ttl   time.Duration
+	table    dynamo.Table
+	inFlight chan struct{}
+	retry    RetryPolicy
+
+	userTTL    time.Duration
+	segmentTTL time.Duration
+	blobTTL    time.Duration
+	sliding    time.Duration
+	slides     chan struct{} // slots of the background sliding expiry updates
 }
 
 // NewDB returns a new DynamoDB-backed implementation of the ProfilesRepo interface.
 // It uses a single DynamoDB table.
 func NewDB(table dynamo.Table, opts ...Option) *DB {
 	db := &DB{
-		table: table,
-		ttl:   0,
+		table:  table,
+		retry:  DefaultRetryPolicy,
+		slides: make(chan struct{}, maxSlidingUpdates),
 	}
 	for _, opt := range opts {
 		opt(db)
@@ -38,3 +87,29 @@ func NewDB(table dynamo.Table, opts ...Option) *DB {
 
 	return db
 }
+
+// do runs an idempotent DynamoDB call, shedding it if too many calls are already in flight
+// and retrying it on transient errors according to the retry policy.
+// Errors are tagged with their repository error kind.
+func (d *DB) do(ctx context.Context, call func(ctx context.Context) error) error {
+	return d.run(ctx, true, call)
+}
+
+// doOnce is do for calls that must not be applied twice, e.g. appending to a list.
+// They are only retried when DynamoDB rejected them.
+func (d *DB) doOnce(ctx context.Context, call func(ctx context.Context) error) error {
+	return d.run(ctx, false, call)
+}
+
+func (d *DB) run(ctx context.Context, idempotent bool, call func(ctx context.Context) error) error {
+	if d.inFlight != nil {
+		select {
+		case d.inFlight <- struct{}{}:
+			defer func() { <-d.inFlight }()
+		default:
+			return repository.ErrOverloaded
+		}
+	}
+
+	return d.attempt(ctx, idempotent, call)
+}
@@ -0,0 +1,122 @@
+package ddb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/guregu/dynamo/v2"
+	"github.com/ory/dockertest/v3"
+	"github.com/stretchr/testify/require"
+)
+
+const testTableName = "user_profiles"
+
+// dynamoDBLocal is the DynamoDB Local container shared by the tests of the package, started by the first test
+// needing it and purged by TestMain.
+var dynamoDBLocal struct {
+	once     sync.Once
+	pool     *dockertest.Pool
+	res      *dockertest.Resource
+	endpoint string
+	err      error
+	tables   atomic.Int64 // number of tables created, to name them
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if dynamoDBLocal.res != nil {
+		dynamoDBLocal.pool.Purge(dynamoDBLocal.res)
+	}
+	os.Exit(code)
+}
+
+// startDynamoDB creates a table of its own for the test in the shared DynamoDB Local instance, starting it
+// on first use, and returns the endpoint of the instance and the name of the table. The table is migrated to
+// the latest schema version and deleted when the test ends. The test is skipped when Docker isn't available.
+func startDynamoDB(tb testing.TB) (endpoint, table string) {
+	tb.Helper()
+
+	dynamoDBLocal.once.Do(func() {
+		dynamoDBLocal.endpoint, dynamoDBLocal.err = runDynamoDBLocal()
+	})
+	if dynamoDBLocal.pool == nil {
+		tb.Skipf("docker is not available: %v", dynamoDBLocal.err)
+	}
+	require.NoError(tb, dynamoDBLocal.err)
+	endpoint = dynamoDBLocal.endpoint
+
+	table = fmt.Sprintf("%s_%d", testTableName, dynamoDBLocal.tables.Add(1))
+	db := dynamo.NewFromIface(newTestClient(endpoint, nil))
+	_, err := Migrate(context.Background(), db, table)
+	require.NoError(tb, err, "failed to create table")
+	tb.Cleanup(func() {
+		db.Table(table).DeleteTable().Run(context.Background())
+	})
+
+	return endpoint, table
+}
+
+// runDynamoDBLocal starts the DynamoDB Local container and waits for it to accept requests.
+// dynamoDBLocal.pool is left nil when Docker isn't available.
+func runDynamoDBLocal() (string, error) {
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		return "", err
+	}
+	dynamoDBLocal.pool = pool
+
+	res, err := pool.Run("amazon/dynamodb-local", "latest", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to start DynamoDB container: %w", err)
+	}
+	dynamoDBLocal.res = res
+
+	endpoint := fmt.Sprintf("http://localhost:%s", res.GetPort("8000/tcp"))
+	client := newTestClient(endpoint, nil)
+	err = pool.Retry(func() error {
+		_, err := client.ListTables(context.Background(), &dynamodb.ListTablesInput{})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("DynamoDB did not start in time: %w", err)
+	}
+
+	return endpoint, nil
+}
+
+// newTestClient returns a DynamoDB client without SDK retries, sending its requests through httpClient when set.
+func newTestClient(endpoint string, httpClient *http.Client, optFns ...func(*dynamodb.Options)) *dynamodb.Client {
+	return dynamodb.New(dynamodb.Options{}, append([]func(*dynamodb.Options){func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.Region = "us-east-1"
+		o.Credentials = credentials.NewStaticCredentialsProvider("dummy", "dummy", "")
+		o.Retryer = aws.NopRetryer{}
+		if httpClient != nil {
+			o.HTTPClient = httpClient
+		}
+	}}, optFns...)...)
+}
+
+// newTestTable returns the table named name of the DynamoDB Local instance at endpoint.
+func newTestTable(endpoint, name string, httpClient *http.Client) dynamo.Table {
+	return dynamo.NewFromIface(newTestClient(endpoint, httpClient)).Table(name)
+}
+
+// testContext returns a context bounding the calls of a test against DynamoDB Local.
+func testContext(tb testing.TB) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	tb.Cleanup(cancel)
+	return ctx
+}
@@ -0,0 +1,45 @@
+package ddb
+
+import (
+	"context"
+	"fmt"
+	"personalisation-poc/repository"
+
+	"github.com/guregu/dynamo/v2"
+)
+
+func (d *DB) DeleteProfile(ctx context.Context, id string) error {
+	var keys []dynamo.Keyed
+	err := d.do(ctx, func(ctx context.Context) error {
+		keys = keys[:0]
+		var items []struct {
+			PK string `dynamo:"pk"`
+			SK string `dynamo:"sk"`
+		}
+		// expired items are deleted as well, DynamoDB may not have removed them yet
+		if err := d.table.Get(partitionKey, buildPK(id)).Project(partitionKey, sortKey).All(ctx, &items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			keys = append(keys, dynamo.Keys{item.PK, item.SK})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read profile items: %w", err)
+	}
+	if len(keys) == 0 {
+		return repository.ErrNoProfileFound
+	}
+
+	bw := d.table.Batch(partitionKey, sortKey).Write().Delete(keys...)
+	err = d.do(ctx, func(ctx context.Context) error {
+		_, err := bw.Run(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete profile items: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package ddb
+
+import (
+	"net/http"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteProfileDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := testContext(t)
+	db := NewDB(newTestTable(endpoint, table, &http.Client{}))
+
+	profile := model.Profile{
+		ID:   uuid.New(),
+		Tags: []string{"sports_fan"},
+		Segments: []model.Segment{
+			{Type: model.MorningSegmentType, TopCategories: []string{"sports"}, CreatedAt: time.Now()},
+			{Type: model.EveningSegmentType, TopCategories: []string{"world"}, ExpiresAt: time.Now().Add(-time.Hour)},
+		},
+	}
+	id := profile.ID.String()
+	require.NoError(t, db.UpsertProfile(ctx, profile))
+	require.NoError(t, db.UpsertBlob(ctx, id, []byte(`{"id":"`+id+`","tags":["sports_fan"]}`), repository.WithBlobEncoding(repository.BlobEncodingGzip)))
+
+	require.NoError(t, db.DeleteProfile(ctx, id))
+
+	ctx = repository.IncludeExpired(ctx)
+	_, err := db.GetProfileByID(ctx, id)
+	require.ErrorIs(t, err, repository.ErrNoProfileFound)
+	_, err = db.GetBlob(ctx, id)
+	require.ErrorIs(t, err, repository.ErrNoProfileFound)
+
+	require.ErrorIs(t, db.DeleteProfile(ctx, id), repository.ErrNoProfileFound)
+}
@@ -0,0 +1,58 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"personalisation-poc/repository"
+
+	"github.com/aws/smithy-go"
+	"github.com/guregu/dynamo/v2"
+)
+
+// mapError tags DynamoDB errors with the matching repository error kind.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var repoErr *repository.Error
+	if errors.As(err, &repoErr) {
+		return err
+	}
+
+	if kind := errorKind(err); kind != nil {
+		return repository.NewError(kind, err)
+	}
+	return err
+}
+
+func errorKind(err error) error {
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return repository.ErrNotFound
+	}
+	if dynamo.IsCondCheckFailed(err) {
+		return repository.ErrConflict
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ProvisionedThroughputExceededException", "ThrottlingException", "RequestLimitExceeded":
+			return repository.ErrThrottled
+		case "TransactionConflictException", "TransactionInProgressException":
+			return repository.ErrConflict
+		case "ValidationException":
+			return repository.ErrValidation
+		case "InternalServerError", "ServiceUnavailable", "ResourceNotFoundException":
+			// a missing table is a deployment issue rather than a missing item
+			return repository.ErrUnavailable
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return repository.ErrUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"personalisation-poc/repository"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"github.com/guregu/dynamo/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapError(t *testing.T) {
+	apiErr := func(code string) error {
+		return fmt.Errorf("operation error DynamoDB: %w", &smithy.GenericAPIError{Code: code})
+	}
+
+	for name, tc := range map[string]struct {
+		err  error
+		kind error
+	}{
+		"not found":          {err: dynamo.ErrNotFound, kind: repository.ErrNotFound},
+		"condition failed":   {err: &types.ConditionalCheckFailedException{}, kind: repository.ErrConflict},
+		"throughput":         {err: apiErr("ProvisionedThroughputExceededException"), kind: repository.ErrThrottled},
+		"throttling":         {err: apiErr("ThrottlingException"), kind: repository.ErrThrottled},
+		"transaction":        {err: apiErr("TransactionConflictException"), kind: repository.ErrConflict},
+		"validation":         {err: apiErr("ValidationException"), kind: repository.ErrValidation},
+		"internal":           {err: apiErr("InternalServerError"), kind: repository.ErrUnavailable},
+		"missing table":      {err: apiErr("ResourceNotFoundException"), kind: repository.ErrUnavailable},
+		"deadline":           {err: context.DeadlineExceeded, kind: repository.ErrUnavailable},
+		"unknown api error":  {err: apiErr("AccessDeniedException")},
+		"other":              {err: errors.New("boom")},
+		"already classified": {err: repository.ErrNoProfileFound, kind: repository.ErrNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := mapError(tc.err)
+			require.ErrorIs(t, err, tc.err)
+			for _, kind := range []error{repository.ErrNotFound, repository.ErrConflict, repository.ErrValidation, repository.ErrThrottled, repository.ErrUnavailable} {
+				require.Equal(t, kind == tc.kind, errors.Is(err, kind), kind)
+			}
+		})
+	}
+
+	require.NoError(t, mapError(nil))
+}
@@ -1,8 +1,10 @@
 package ddb
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"personalisation-poc/model"
 	"personalisation-poc/repository"
@@ -21,35 +23,46 @@ func (d *DB) GetProfileByID(ctx context.Context, id string) (*model.Profile, err
 		item     map[string]types.AttributeValue
 	)
 
-	// Get all items for the profile
-	iter := d.table.Get(partitionKey, buildPK(id)).Iter()
-	for iter.Next(ctx, &item) {
-		itemTyp, ok := item[itemType].(*types.AttributeValueMemberS)
-		if !ok {
-			return nil, fmt.Errorf("invalid sort key")
-		}
-		switch { // add all supported item types to this switch statement
-		case itemTyp == nil:
-			return nil, fmt.Errorf("invalid sort key")
-		case strings.HasPrefix(itemTyp.Value, userItemKeyPrefix): // user item
-			err := dynamo.UnmarshalItem(item, &user)
-			if err != nil {
-				return nil, fmt.Errorf("unmarshal user: %w", err)
+	err := d.do(ctx, func(ctx context.Context) error {
+		user, segments = nil, nil // start over when retried
+
+		// Get the SEG and USER items of the profile. They sort after the BLOB items sharing the partition,
+		// which aren't read, so that storing the blob representation doesn't make profile reads more expensive.
+		q := d.table.Get(partitionKey, buildPK(id)).Range(sortKey, dynamo.GreaterOrEqual, segmentItemKeyPrefix+keySeparator)
+		iter := filterExpired(ctx, q).Iter()
+		for iter.Next(ctx, &item) {
+			itemTyp, ok := item[itemType].(*types.AttributeValueMemberS)
+			if !ok {
+				return fmt.Errorf("invalid sort key")
 			}
-		case strings.HasPrefix(itemTyp.Value, segmentItemKeyPrefix): // segment item
-			var segmt segment
-			err := dynamo.UnmarshalItem(item, &segmt)
-			if err != nil {
-				return nil, fmt.Errorf("unmarshal sub profile: %w", err)
+			switch { // add all supported item types to this switch statement
+			case itemTyp == nil:
+				return fmt.Errorf("invalid sort key")
+			case strings.HasPrefix(itemTyp.Value, userItemKeyPrefix): // user item
+				err := dynamo.UnmarshalItem(item, &user)
+				if err != nil {
+					return fmt.Errorf("unmarshal user: %w", err)
+				}
+				if expired(ctx, user.TTL) {
+					user = nil
+				}
+			case strings.HasPrefix(itemTyp.Value, segmentItemKeyPrefix): // segment item
+				var segmt segment
+				err := dynamo.UnmarshalItem(item, &segmt)
+				if err != nil {
+					return fmt.Errorf("unmarshal sub profile: %w", err)
+				}
+				if !expired(ctx, segmt.TTL) {
+					segments = append(segments, segmt)
+				}
+			default:
+				return fmt.Errorf("get profile: unknown item type: %s", *itemTyp)
 			}
-			segments = append(segments, segmt)
-		default:
-			return nil, fmt.Errorf("get profile: unknown item type: %s", *itemTyp)
 		}
-	}
 
-	// Check for any errors from the iterator
-	err := iter.Err()
+		// Check for any errors from the iterator
+		return iter.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -58,99 +71,322 @@ func (d *DB) GetProfileByID(ctx context.Context, id string) (*model.Profile, err
 		return nil, repository.ErrNoProfileFound
 	}
 
+	items := []expiringItem{{sk: user.SK, ttl: user.TTL, fixed: user.FixedTTL, typeTTL: d.userTTL}}
+	for _, s := range segments {
+		items = append(items, expiringItem{sk: s.SK, ttl: s.TTL, fixed: s.FixedTTL, typeTTL: d.segmentTTL})
+	}
+	d.slideExpiry(ctx, id, items...)
+
 	// Convert the items to a canonical profile
-	return toCanonicalProfile(*user, segments), nil
+	profile := toCanonicalProfile(*user, segments)
+	if repository.ExplicitExpiriesOnly(ctx) {
+		if !user.FixedTTL {
+			profile.ExpiresAt = time.Time{}
+		}
+		for i, s := range segments {
+			if !s.FixedTTL {
+				profile.Segments[i].ExpiresAt = time.Time{}
+			}
+		}
+	}
+	return profile, nil
 }
 
 func (d *DB) GetSegment(ctx context.Context, profileID string, segmentType string, createdAt time.Time) (*model.Segment, error) {
+	if createdAt.IsZero() {
+		segment, err := d.getLatestSegment(ctx, profileID, segmentType)
+		if err != nil {
+			return nil, err
+		}
+		return toCanonicalSegment(segment), nil
+	}
+
 	var segment segment
-	err := d.table.Get(partitionKey, buildPK(profileID)).
-		Range(sortKey, dynamo.BeginsWith, buildSK(segmentItemKeyPrefix, segmentType, &createdAt)).
-		One(ctx, &segment)
+	err := d.do(ctx, func(ctx context.Context) error {
+		return filterExpired(ctx, d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.BeginsWith, buildSK(segmentItemKeyPrefix, segmentType, &createdAt))).
+			One(ctx, &segment)
+	})
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && expired(ctx, segment.TTL)) {
+		return nil, repository.ErrNoSegmentsFound
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	return toCanonicalSegment(segment), err
+	return toCanonicalSegment(segment), nil
+}
+
+// getLatestSegment returns the most recent segment of the given type, projecting attrs when set.
+func (d *DB) getLatestSegment(ctx context.Context, profileID string, segmentType string, attrs ...string) (segment, error) {
+	var segments []segment
+	err := d.do(ctx, func(ctx context.Context) error {
+		segments = nil
+		// segment sort keys end with their creation time, the latest segment that isn't expired is the first one
+		q := d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.BeginsWith, buildSK(segmentItemKeyPrefix, segmentType, nil)+keySeparator).
+			Order(dynamo.Descending).
+			Limit(1)
+		if len(attrs) > 0 {
+			q.Project(append(attrs, ttlAttribute)...)
+		}
+		return filterExpired(ctx, q).All(ctx, &segments)
+	})
+	if err != nil {
+		return segment{}, err
+	}
+	if len(segments) == 0 || expired(ctx, segments[0].TTL) {
+		return segment{}, repository.ErrNoSegmentsFound
+	}
+
+	return segments[0], nil
 }
 
 func (d *DB) GetCategories(ctx context.Context, profileID string, segmentType string) ([]model.Category, error) {
-	var categories []category
-	err := d.table.Get(partitionKey, buildPK(profileID)).
-		Range(sortKey, dynamo.Equal, buildSK(segmentItemKeyPrefix, segmentType, nil)).
-		Project("cats").
-		One(ctx, &categories)
+	segment, err := d.getLatestSegment(ctx, profileID, segmentType, "cats")
+	if err != nil {
+		return nil, err
+	}
 
-	return lo.Map(categories, func(cat category, _ int) model.Category {
+	return lo.Map(segment.Categories, func(cat category, _ int) model.Category {
 		return model.Category{
 			ID:    cat.ID,
 			Score: cat.Score,
 		}
-	}), err
+	}), nil
 }
 
 func (d *DB) GetUserTags(ctx context.Context, profileID string) ([]string, error) {
-	var tags map[string]any
-	err := d.table.Get(partitionKey, buildPK(profileID)).
-		Range(sortKey, dynamo.Equal, buildSK(userItemKeyPrefix, profileID, nil)).
-		Project("tags").
-		One(ctx, &tags)
-
-	tagList, ok := tags["tags"].([]string)
-	if !ok {
-		return nil, fmt.Errorf("invalid tags")
+	var user user
+	err := d.do(ctx, func(ctx context.Context) error {
+		return d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.Equal, buildSK(userItemKeyPrefix, profileID, nil)).
+			Project("tags", ttlAttribute).
+			One(ctx, &user)
+	})
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && expired(ctx, user.TTL)) {
+		return nil, repository.ErrNoProfileFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return tagList, err
+	return user.Tags, nil
 }
 
 func (d *DB) GetTopCategories(ctx context.Context, profileID string, segmentType string) ([]string, error) {
-	var topCategories map[string]any
-	err := d.table.Get(partitionKey, buildPK(profileID)).
-		Range(sortKey, dynamo.Equal, buildSK(segmentItemKeyPrefix, segmentType, nil)).
-		Project("top_cats").
-		One(ctx, &topCategories)
-
-	topCategoryList, ok := topCategories["top_cats"].([]string)
-	if !ok {
-		return nil, fmt.Errorf("invalid top categories")
+	segment, err := d.getLatestSegment(ctx, profileID, segmentType, "top_cats")
+	if err != nil {
+		return nil, err
 	}
 
-	return topCategoryList, err
+	return segment.TopCategories, nil
 }
 
+// blobReadAttempts bounds the reads of a chunked blob rewritten while it's read.
+const blobReadAttempts = 3
+
+// errMissingBlobChunk is returned when a chunk of the version of a blob read has been deleted,
+// i.e. the blob has been rewritten since its head item was read.
+var errMissingBlobChunk = errors.New("missing blob chunk")
+
 func (d *DB) GetBlob(ctx context.Context, profileID string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		blob, err := d.getBlobHead(ctx, profileID)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := d.decodeBlob(ctx, profileID, blob)
+		if errors.Is(err, errMissingBlobChunk) && attempt < blobReadAttempts {
+			continue // read the new version
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// the chunks expire with the head item
+		items := []expiringItem{{sk: buildSK(blobItemKeyPrefix, profileID, nil), ttl: blob.TTL, fixed: blob.FixedTTL, typeTTL: d.blobTTL}}
+		for n := range blob.Chunks {
+			items = append(items, expiringItem{sk: buildBlobChunkSK(profileID, blob.Version, n), ttl: blob.TTL, fixed: blob.FixedTTL, typeTTL: d.blobTTL})
+		}
+		d.slideExpiry(ctx, profileID, items...)
+
+		return data, nil
+	}
+}
+
+// getBlobHead returns the head item of a blob.
+func (d *DB) getBlobHead(ctx context.Context, profileID string) (blob, error) {
 	var blob blob
-	err := d.table.Get(partitionKey, buildPK(profileID)).
-		Range(sortKey, dynamo.Equal, buildSK(blobItemKeyPrefix, profileID, nil)).
-		Project("rawdata").
-		One(ctx, &blob)
+	err := d.do(ctx, func(ctx context.Context) error {
+		return d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.Equal, buildSK(blobItemKeyPrefix, profileID, nil)).
+			Project("rawdata", "enc", "payload", "chunks", "ver", "btype", ttlAttribute, fixedTTLAttribute).
+			One(ctx, &blob)
+	})
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && expired(ctx, blob.TTL)) {
+		return blob, repository.ErrNoProfileFound
+	}
 
-	if err != nil {
-		return nil, err
+	return blob, err
+}
+
+// BlobExpiresAt returns when the blob of the profile expires, zero when it doesn't.
+func (d *DB) BlobExpiresAt(ctx context.Context, profileID string) (time.Time, error) {
+	var blob blob
+	err := d.do(ctx, func(ctx context.Context) error {
+		return d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.Equal, buildSK(blobItemKeyPrefix, profileID, nil)).
+			Project(ttlAttribute, fixedTTLAttribute).
+			One(ctx, &blob)
+	})
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && expired(ctx, blob.TTL)) {
+		return time.Time{}, repository.ErrNoProfileFound
+	}
+	if repository.ExplicitExpiriesOnly(ctx) && !blob.FixedTTL {
+		return time.Time{}, err
 	}
 
-	// Marshal the interface{} back to JSON bytes
-	return json.Marshal(blob.Data)
+	return expiresAt(blob.TTL), err
 }
 
-func (d *DB) GetRawSegmentsFromBlob(ctx context.Context, profileID string) ([]byte, error) {
-	// Project only the segments field from rawdata
-	var result struct {
-		RawData map[string]any `dynamo:"rawdata"`
+// BlobType returns the type of the blob of the profile, empty when it's a profile.
+func (d *DB) BlobType(ctx context.Context, profileID string) (string, error) {
+	var blob blob
+	err := d.do(ctx, func(ctx context.Context) error {
+		return d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.Equal, buildSK(blobItemKeyPrefix, profileID, nil)).
+			Project("btype", ttlAttribute).
+			One(ctx, &blob)
+	})
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && expired(ctx, blob.TTL)) {
+		return "", repository.ErrNoProfileFound
+	}
+
+	return blob.Type, err
+}
+
+// decodeBlob returns the JSON document stored in a blob, fetching its chunks if needed.
+func (d *DB) decodeBlob(ctx context.Context, profileID string, b blob) ([]byte, error) {
+	enc := b.blobEncoding()
+	if enc == repository.BlobEncodingMap {
+		// Marshal the interface{} back to JSON bytes
+		return json.Marshal(b.Data)
+	}
+
+	payload := b.Payload
+	if b.Chunks > 0 {
+		var err error
+		payload, err = d.getBlobChunks(ctx, profileID, b.Version, b.Chunks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := decompress(enc, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode blob: %w", err)
 	}
+	return data, nil
+}
 
-	err := d.table.Get(partitionKey, buildPK(profileID)).
-		Range(sortKey, dynamo.Equal, buildSK(blobItemKeyPrefix, profileID, nil)).
-		Project("rawdata.'segments'").
-		One(ctx, &result)
+// getBlobChunks reassembles the payload of a version of a blob split across n chunks.
+func (d *DB) getBlobChunks(ctx context.Context, profileID, version string, n int) ([]byte, error) {
+	var chunks []blobChunk
+	err := d.do(ctx, func(ctx context.Context) error {
+		chunks = nil
+		return d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.BeginsWith, blobChunkSKPrefix(profileID, version)).
+			All(ctx, &chunks)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob chunks: %w", err)
+	}
 
+	parts := make([][]byte, n)
+	for _, chunk := range chunks {
+		// the prefix of the unversioned chunks matches every chunk
+		if chunk.Index < n && chunk.SK == buildBlobChunkSK(profileID, version, chunk.Index) {
+			parts[chunk.Index] = chunk.Payload
+		}
+	}
+	for i, part := range parts {
+		if part == nil {
+			return nil, fmt.Errorf("%w %d of %d", errMissingBlobChunk, i, n)
+		}
+	}
+
+	return bytes.Join(parts, nil), nil
+}
+
+func (d *DB) GetRawSegmentsFromBlob(ctx context.Context, profileID string) ([]byte, error) {
+	// Project only the segments field from rawdata
+	doc, err := d.getBlobPaths(ctx, profileID, [][]pathElem{{{key: "segments"}}})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get segments from blob: %w", err)
 	}
 
 	// Extract segments from the projected result
-	segmentsData, exists := result.RawData["segments"]
+	segmentsData, exists := doc["segments"]
 	if !exists {
 		return nil, repository.ErrNoSegmentsFound // No segments found
 	}
 
 	return json.Marshal(segmentsData)
 }
+
+func (d *DB) GetBlobPaths(ctx context.Context, profileID string, paths []string) ([]byte, error) {
+	parsed, err := parseDocPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := d.getBlobPaths(ctx, profileID, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+// getBlobPaths returns the sub-documents of the blob at paths, nested as in the blob.
+// Binary encoded blobs can't be projected by DynamoDB, so they are decoded and projected in memory.
+func (d *DB) getBlobPaths(ctx context.Context, profileID string, paths [][]pathElem) (map[string]any, error) {
+	var result struct {
+		RawData  map[string]any `dynamo:"rawdata"`
+		Encoding string         `dynamo:"enc"`
+		TTL      int64          `dynamo:"ttl"`
+	}
+
+	expr, args := blobProjection(paths)
+	err := d.do(ctx, func(ctx context.Context) error {
+		return d.table.Get(partitionKey, buildPK(profileID)).
+			Range(sortKey, dynamo.Equal, buildSK(blobItemKeyPrefix, profileID, nil)).
+			ProjectExpr(expr+", enc, $", append(args, ttlAttribute)...).
+			One(ctx, &result)
+	})
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && expired(ctx, result.TTL)) {
+		return nil, repository.ErrNoProfileFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Encoding != "" && repository.BlobEncoding(result.Encoding) != repository.BlobEncodingMap {
+		data, err := d.GetBlob(ctx, profileID)
+		if err != nil {
+			return nil, err
+		}
+		var doc any
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to decode blob: %w", err)
+		}
+		return projectDoc(doc, paths), nil
+	}
+
+	if result.RawData == nil {
+		return map[string]any{}, nil
+	}
+	return result.RawData, nil
+}
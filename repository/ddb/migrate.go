@@ -0,0 +1,162 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/guregu/dynamo/v2"
+)
+
+const (
+	metaItemType = "META"
+	// the schema version item is alone in its partition, out of the profiles' way
+	schemaVersionPK = metaItemType
+	schemaVersionSK = "SCHEMA"
+)
+
+// Migration is a change to the table definition. Migrations are idempotent, so that a table created
+// by other means, e.g. by hand, is brought to the expected definition rather than failing.
+type Migration struct {
+	Version     int
+	Description string
+	apply       func(ctx context.Context, db *dynamo.DB, tableName string) error
+}
+
+// migrations is the definition of the table, as the ordered list of the changes applied to it.
+// Applied migrations must not be changed: append a new one instead, e.g. to create a GSI.
+var migrations = []Migration{
+	{Version: 1, Description: "create the table with pk and sk string keys, billed on demand", apply: createTable},
+	{Version: 2, Description: "expire the items on their " + ttlAttribute + " attribute", apply: enableTTL},
+	{Version: 3, Description: "stream the old and new images of the items", apply: enableStream},
+}
+
+// SchemaVersion is the version of the table definition the repository expects.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// schemaVersion is the item recording the last migration applied to the table.
+type schemaVersion struct {
+	PK        string    `dynamo:"pk,hash"`
+	SK        string    `dynamo:"sk,range"`
+	ItemType  string    `dynamo:"typ"`
+	Version   int       `dynamo:"version"`
+	AppliedAt time.Time `dynamo:"applied_at"`
+}
+
+// Migrate creates the table when it doesn't exist and applies the migrations it hasn't recorded yet, in order.
+// It returns the migrations applied, none when the table is up to date.
+func Migrate(ctx context.Context, db *dynamo.DB, tableName string) ([]Migration, error) {
+	current, err := appliedVersion(ctx, db, tableName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	if current > SchemaVersion() {
+		return nil, fmt.Errorf("table schema version %d is newer than the supported version %d", current, SchemaVersion())
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := m.apply(ctx, db, tableName); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+
+		// concurrent migrations may have gone further already
+		err := db.Table(tableName).Put(schemaVersion{
+			PK:        schemaVersionPK,
+			SK:        schemaVersionSK,
+			ItemType:  metaItemType,
+			Version:   m.Version,
+			AppliedAt: time.Now().UTC(),
+		}).If("attribute_not_exists($) OR $ < ?", partitionKey, "version", m.Version).Run(ctx)
+		if err != nil && !dynamo.IsCondCheckFailed(err) {
+			return applied, fmt.Errorf("failed to record schema version %d: %w", m.Version, err)
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// appliedVersion returns the version recorded in the table, zero when the table or the version item don't exist.
+func appliedVersion(ctx context.Context, db *dynamo.DB, tableName string) (int, error) {
+	var v schemaVersion
+	err := db.Table(tableName).Get(partitionKey, schemaVersionPK).
+		Range(sortKey, dynamo.Equal, schemaVersionSK).
+		Consistent(true).
+		One(ctx, &v)
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.Is(err, dynamo.ErrNotFound), errors.As(err, &notFound):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	return v.Version, nil
+}
+
+func createTable(ctx context.Context, db *dynamo.DB, tableName string) error {
+	table := db.Table(tableName)
+	desc, err := table.Describe().Run(ctx)
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		keys := struct {
+			PK string `dynamo:"pk,hash"`
+			SK string `dynamo:"sk,range"`
+		}{}
+		return db.CreateTable(tableName, keys).OnDemand(true).Wait(ctx)
+	case err != nil:
+		return err
+	}
+
+	if desc.HashKey != partitionKey || desc.RangeKey != sortKey ||
+		desc.HashKeyType != dynamo.StringType || desc.RangeKeyType != dynamo.StringType {
+		return fmt.Errorf("table %s exists with keys %s (%s) and %s (%s) instead of %s (S) and %s (S)",
+			tableName, desc.HashKey, desc.HashKeyType, desc.RangeKey, desc.RangeKeyType, partitionKey, sortKey)
+	}
+	return table.Wait(ctx)
+}
+
+func enableTTL(ctx context.Context, db *dynamo.DB, tableName string) error {
+	table := db.Table(tableName)
+	desc, err := table.DescribeTTL().Run(ctx)
+	if err != nil {
+		return err
+	}
+	if desc.Status == dynamo.TTLEnabled || desc.Status == dynamo.TTLEnabling {
+		if desc.Attribute != ttlAttribute {
+			return fmt.Errorf("TTL is already enabled on attribute %q", desc.Attribute)
+		}
+		return nil
+	}
+
+	return table.UpdateTTL(ttlAttribute, true).Run(ctx)
+}
+
+func enableStream(ctx context.Context, db *dynamo.DB, tableName string) error {
+	table := db.Table(tableName)
+	desc, err := table.Describe().Run(ctx)
+	if err != nil {
+		return err
+	}
+	if desc.StreamEnabled {
+		if desc.StreamView != dynamo.NewAndOldImagesView {
+			return fmt.Errorf("stream is already enabled with view %s", desc.StreamView)
+		}
+		return nil
+	}
+
+	_, err = table.UpdateTable().Stream(dynamo.NewAndOldImagesView).Run(ctx)
+	if err != nil {
+		return err
+	}
+	return table.Wait(ctx)
+}
@@ -0,0 +1,55 @@
+package ddb
+
+import (
+	"personalisation-poc/repository"
+	"testing"
+
+	"github.com/guregu/dynamo/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t) // migrated to the latest version
+	ctx := testContext(t)
+	db := dynamo.NewFromIface(newTestClient(endpoint, nil))
+
+	applied, err := Migrate(ctx, db, table)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	version, err := appliedVersion(ctx, db, table)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion(), version)
+
+	ttl, err := db.Table(table).DescribeTTL().Run(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ttlAttribute, ttl.Attribute)
+
+	desc, err := db.Table(table).Describe().Run(ctx)
+	require.NoError(t, err)
+	require.True(t, desc.StreamEnabled)
+	require.Equal(t, dynamo.NewAndOldImagesView, desc.StreamView)
+
+	// a table created by other means is brought up to date
+	const otherTable = "created_by_hand"
+	keys := struct {
+		PK string `dynamo:"pk,hash"`
+		SK string `dynamo:"sk,range"`
+	}{}
+	require.NoError(t, db.CreateTable(otherTable, keys).Provision(10, 5).Wait(ctx))
+
+	applied, err = Migrate(ctx, db, otherTable)
+	require.NoError(t, err)
+	require.Len(t, applied, len(migrations))
+	version, err = appliedVersion(ctx, db, otherTable)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion(), version)
+
+	// the schema version item isn't a profile
+	ids := 0
+	require.NoError(t, NewDB(db.Table(otherTable)).ScanProfileIDs(ctx, repository.RepresentationProfile, func(string) error {
+		ids++
+		return nil
+	}))
+	require.Zero(t, ids)
+}
@@ -0,0 +1,286 @@
+package ddb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"personalisation-poc/repository"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/guregu/dynamo/v2"
+)
+
+// listIndexToken matches the JSON Pointer tokens addressing list elements.
+// Numeric tokens are always list indexes, so map keys made of digits can't be patched.
+var listIndexToken = regexp.MustCompile(`^(0|[1-9][0-9]*)$`)
+
+// docExpr is a fragment of an expression with its placeholder arguments.
+type docExpr struct {
+	expr string
+	args []any
+}
+
+// updatePlan is the translation of a JSON Patch into a single UpdateItem call.
+type updatePlan struct {
+	set        []docExpr
+	remove     []docExpr
+	conditions []docExpr
+}
+
+// parsePointer parses a JSON Pointer (RFC 6901) such as "/segments/0/top_categories".
+// The trailing "-" token, addressing the end of a list, is reported with appendToList.
+func parsePointer(ptr string) (path []pathElem, appendToList bool, err error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: pointer %q: %s", repository.ErrInvalidPatch, ptr, reason)
+	}
+
+	if ptr == "" {
+		return nil, false, invalid("the whole document can't be patched, use PUT instead")
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, false, invalid("must start with /")
+	}
+
+	tokens := strings.Split(ptr[1:], "/")
+	for i, token := range tokens {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch {
+		case token == "-":
+			if i != len(tokens)-1 {
+				return nil, false, invalid("- must be the last token")
+			}
+			if i == 0 {
+				return nil, false, invalid("the document is not a list")
+			}
+			appendToList = true
+		case listIndexToken.MatchString(token):
+			if i == 0 {
+				return nil, false, invalid("the document is not a list")
+			}
+			n, err := strconv.Atoi(token)
+			if err != nil {
+				return nil, false, invalid("list index out of range")
+			}
+			path = append(path, pathElem{index: n, isIndex: true})
+		case token == "":
+			return nil, false, invalid("empty attribute name")
+		default:
+			path = append(path, pathElem{key: token})
+		}
+	}
+	if len(path) > maxPathDepth {
+		return nil, false, invalid(fmt.Sprintf("more than %d levels", maxPathDepth))
+	}
+
+	return path, appendToList, nil
+}
+
+// toUpdatePlan translates JSON Patch operations into the SET and REMOVE actions and the conditions
+// of a single update of the blob data.
+//
+// DynamoDB evaluates all the actions against the stored item while JSON Patch applies operations in turn,
+// so operations must write to distinct paths and can't read paths written by previous operations.
+// Removing a list element shifts the following ones, so it can't be combined with other writes to the same list.
+func toUpdatePlan(ops []repository.PatchOperation) (updatePlan, error) {
+	if len(ops) == 0 {
+		return updatePlan{}, fmt.Errorf("%w: no operations", repository.ErrInvalidPatch)
+	}
+
+	var (
+		plan    updatePlan
+		targets [][]pathElem
+	)
+	// read rejects paths overlapping with the ones written by previous operations.
+	read := func(op int, path []pathElem) error {
+		for _, other := range targets {
+			if hasPathPrefix(path, other) || hasPathPrefix(other, path) {
+				return fmt.Errorf("%w: operation %d: path already written by the patch", repository.ErrInvalidPatch, op)
+			}
+		}
+		return nil
+	}
+	// write records a path written by the update.
+	write := func(op int, path []pathElem) error {
+		if err := read(op, path); err != nil {
+			return err
+		}
+		targets = append(targets, path)
+		return nil
+	}
+	cond := func(format string, path []pathElem, args ...any) docExpr {
+		expr, pathArgs := blobPathExpr(path)
+		return docExpr{expr: fmt.Sprintf(format, expr), args: append(pathArgs, args...)}
+	}
+	// parentIsMap requires the parent of path to be a map, so that a new key can be added to it.
+	parentIsMap := func(path []pathElem) []docExpr {
+		if len(path) == 1 {
+			return nil // the blob data is always a map
+		}
+		return []docExpr{cond("attribute_type(%s, ?)", path[:len(path)-1], "M")}
+	}
+	// removed is the path written when removing path: the whole list for list elements.
+	removed := func(path []pathElem) []pathElem {
+		if path[len(path)-1].isIndex {
+			return path[:len(path)-1]
+		}
+		return path
+	}
+
+	for i, op := range ops {
+		path, appendToList, err := parsePointer(op.Path)
+		if err != nil {
+			return updatePlan{}, fmt.Errorf("operation %d: %w", i, err)
+		}
+		var from []pathElem
+		if op.Op == "move" || op.Op == "copy" {
+			var fromAppend bool
+			from, fromAppend, err = parsePointer(op.From)
+			if err != nil {
+				return updatePlan{}, fmt.Errorf("operation %d: %w", i, err)
+			}
+			if fromAppend {
+				return updatePlan{}, fmt.Errorf("%w: operation %d: from can't address the end of a list", repository.ErrInvalidPatch, i)
+			}
+		}
+		var value any
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if len(op.Value) == 0 {
+				return updatePlan{}, fmt.Errorf("%w: operation %d: value is required", repository.ErrInvalidPatch, i)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return updatePlan{}, fmt.Errorf("%w: operation %d: %v", repository.ErrInvalidPatch, i, err)
+			}
+		}
+		if appendToList && op.Op != "add" {
+			return updatePlan{}, fmt.Errorf("%w: operation %d: only add can address the end of a list", repository.ErrInvalidPatch, i)
+		}
+
+		switch op.Op {
+		case "add":
+			if appendToList {
+				if err := write(i, path); err != nil {
+					return updatePlan{}, err
+				}
+				list, listArgs := blobPathExpr(path)
+				plan.set = append(plan.set, docExpr{
+					expr: fmt.Sprintf("%s = list_append(%s, ?)", list, list),
+					args: append(append(listArgs, listArgs...), []any{value}),
+				})
+				plan.conditions = append(plan.conditions, cond("attribute_type(%s, ?)", path, "L"))
+				continue
+			}
+			if path[len(path)-1].isIndex {
+				return updatePlan{}, fmt.Errorf("%w: operation %d: inserting at a list index is not supported, use - to append", repository.ErrInvalidPatch, i)
+			}
+			if err := write(i, path); err != nil {
+				return updatePlan{}, err
+			}
+			plan.set = append(plan.set, cond("%s = ?", path, value))
+			plan.conditions = append(plan.conditions, parentIsMap(path)...)
+		case "remove":
+			if err := write(i, removed(path)); err != nil {
+				return updatePlan{}, err
+			}
+			plan.remove = append(plan.remove, cond("%s", path))
+			plan.conditions = append(plan.conditions, cond("attribute_exists(%s)", path))
+		case "replace":
+			if err := write(i, path); err != nil {
+				return updatePlan{}, err
+			}
+			plan.set = append(plan.set, cond("%s = ?", path, value))
+			plan.conditions = append(plan.conditions, cond("attribute_exists(%s)", path))
+		case "move", "copy":
+			if path[len(path)-1].isIndex {
+				return updatePlan{}, fmt.Errorf("%w: operation %d: %s to a list index is not supported", repository.ErrInvalidPatch, i, op.Op)
+			}
+			if op.Op == "move" && hasPathPrefix(path, from) {
+				return updatePlan{}, fmt.Errorf("%w: operation %d: can't move a value into itself", repository.ErrInvalidPatch, i)
+			}
+			if err := read(i, from); err != nil {
+				return updatePlan{}, err
+			}
+			if err := write(i, path); err != nil {
+				return updatePlan{}, err
+			}
+			if op.Op == "move" {
+				if err := write(i, removed(from)); err != nil {
+					return updatePlan{}, err
+				}
+				plan.remove = append(plan.remove, cond("%s", from))
+			}
+			fromExpr, fromArgs := blobPathExpr(from)
+			plan.set = append(plan.set, cond("%s = "+fromExpr, path, fromArgs...))
+			plan.conditions = append(plan.conditions, cond("attribute_exists(%s)", from))
+			plan.conditions = append(plan.conditions, parentIsMap(path)...)
+		case "test":
+			if err := read(i, path); err != nil {
+				return updatePlan{}, err
+			}
+			plan.conditions = append(plan.conditions, cond("%s = ?", path, value))
+		default:
+			return updatePlan{}, fmt.Errorf("%w: operation %d: unknown op %q", repository.ErrInvalidPatch, i, op.Op)
+		}
+	}
+
+	return plan, nil
+}
+
+func (d *DB) PatchBlob(ctx context.Context, profileID string, ops []repository.PatchOperation, opts ...repository.BlobOption) error {
+	var options repository.BlobOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	plan, err := toUpdatePlan(ops)
+	if err != nil {
+		return err
+	}
+
+	update := d.table.Update(partitionKey, buildPK(profileID)).
+		Range(sortKey, buildSK(blobItemKeyPrefix, profileID, nil)).
+		// binary encoded blobs can't be updated in place
+		If("attribute_exists($) AND (attribute_not_exists(enc) OR enc = ?)", blobDataAttribute, repository.BlobEncodingMap)
+	// expired blobs are gone for the reads, and can't be patched either
+	if !repository.ExpiredIncluded(ctx) {
+		expr, args := notExpiredFilter()
+		update.If(expr, args...)
+	}
+	// ops were validated against the schema of their type
+	if options.Type == "" {
+		update.If("attribute_not_exists(btype)")
+	} else {
+		update.If("btype = ?", options.Type)
+	}
+	for _, set := range plan.set {
+		update.SetExpr(set.expr, set.args...)
+	}
+	for _, remove := range plan.remove {
+		update.RemoveExpr(remove.expr, remove.args...)
+	}
+	for _, cond := range plan.conditions {
+		update.If(cond.expr, cond.args...)
+	}
+
+	// appends and numeric updates aren't idempotent
+	err = d.doOnce(ctx, func(ctx context.Context) error {
+		return update.Run(ctx)
+	})
+	if dynamo.IsCondCheckFailed(err) {
+		// the conditions also fail when there's no blob to patch, or it's expired
+		head, err := d.getBlobHead(ctx, profileID)
+		if err != nil {
+			return fmt.Errorf("failed to patch blob: %w", err)
+		}
+		if head.Type != options.Type {
+			return fmt.Errorf("failed to patch blob: %w: the blob is of type %q", repository.ErrPatchConflict, head.Type)
+		}
+		return fmt.Errorf("failed to patch blob: %w", repository.ErrPatchConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to patch blob: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package ddb
+
+import (
+	"encoding/json"
+	"personalisation-poc/repository"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToUpdatePlan(t *testing.T) {
+	var ops []repository.PatchOperation
+	err := json.Unmarshal([]byte(`[
+		{"op": "test", "path": "/segments/0/type", "value": "x"},
+		{"op": "replace", "path": "/segments/0/top_categories", "value": ["a"]},
+		{"op": "add", "path": "/tags/-", "value": "t"},
+		{"op": "add", "path": "/meta/a~1b", "value": 1},
+		{"op": "move", "from": "/old", "path": "/new"}
+	]`), &ops)
+	require.NoError(t, err)
+
+	plan, err := toUpdatePlan(ops)
+	require.NoError(t, err)
+
+	require.Equal(t, []docExpr{
+		{expr: "$.$[0].$ = ?", args: []any{"rawdata", "segments", "top_categories", []any{"a"}}},
+		{expr: "$.$ = list_append($.$, ?)", args: []any{"rawdata", "tags", "rawdata", "tags", []any{"t"}}},
+		{expr: "$.$.$ = ?", args: []any{"rawdata", "meta", "a/b", float64(1)}},
+		{expr: "$.$ = $.$", args: []any{"rawdata", "new", "rawdata", "old"}},
+	}, plan.set)
+	require.Equal(t, []docExpr{
+		{expr: "$.$", args: []any{"rawdata", "old"}},
+	}, plan.remove)
+	require.Equal(t, []docExpr{
+		{expr: "$.$[0].$ = ?", args: []any{"rawdata", "segments", "type", "x"}},
+		{expr: "attribute_exists($.$[0].$)", args: []any{"rawdata", "segments", "top_categories"}},
+		{expr: "attribute_type($.$, ?)", args: []any{"rawdata", "tags", "L"}},
+		{expr: "attribute_type($.$, ?)", args: []any{"rawdata", "meta", "M"}},
+		{expr: "attribute_exists($.$)", args: []any{"rawdata", "old"}},
+	}, plan.conditions)
+}
+
+func TestToUpdatePlanInvalid(t *testing.T) {
+	for name, patch := range map[string]string{
+		"unknown op":       `[{"op": "merge", "path": "/a", "value": 1}]`,
+		"whole document":   `[{"op": "replace", "path": "", "value": {}}]`,
+		"missing value":    `[{"op": "add", "path": "/a"}]`,
+		"insert in list":   `[{"op": "add", "path": "/a/0", "value": 1}]`,
+		"overlapping":      `[{"op": "add", "path": "/a", "value": {}}, {"op": "add", "path": "/a/b", "value": 1}]`,
+		"shifted indexes":  `[{"op": "remove", "path": "/a/0"}, {"op": "remove", "path": "/a/1"}]`,
+		"test after write": `[{"op": "replace", "path": "/a", "value": 1}, {"op": "test", "path": "/a", "value": 1}]`,
+		"move into itself": `[{"op": "move", "from": "/a", "path": "/a/b"}]`,
+		"no operations":    `[]`,
+	} {
+		var ops []repository.PatchOperation
+		require.NoError(t, json.Unmarshal([]byte(patch), &ops), name)
+		_, err := toUpdatePlan(ops)
+		require.ErrorIs(t, err, repository.ErrInvalidPatch, name)
+	}
+}
@@ -0,0 +1,230 @@
+package ddb
+
+import (
+	"fmt"
+	"personalisation-poc/repository"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	blobDataAttribute = "rawdata"
+
+	// maxPathDepth is the maximum nesting level DynamoDB supports in document paths.
+	maxPathDepth = 32
+	// maxPaths and maxPathLength bound the paths of a projection, keeping it within DynamoDB's 4 KB expressions.
+	maxPaths      = 32
+	maxPathLength = 256
+)
+
+// pathElem is a step of a document path: either a map key or a list index.
+type pathElem struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseDocPath parses a document path such as "segments[0].top_categories".
+func parseDocPath(path string) ([]pathElem, error) {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w %q: %s", repository.ErrInvalidPath, path, reason)
+	}
+
+	if len(path) > maxPathLength {
+		return nil, invalid(fmt.Sprintf("longer than %d characters", maxPathLength))
+	}
+
+	var elems []pathElem
+	for i, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name == "" {
+			if i == 0 && part == "" {
+				return nil, invalid("empty path")
+			}
+			return nil, invalid("empty attribute name")
+		}
+		if strings.Contains(name, "]") {
+			return nil, invalid("unexpected ]")
+		}
+		elems = append(elems, pathElem{key: name})
+
+		if len(part) == len(name) {
+			continue
+		}
+		// list indexes, e.g. "0]" or "0][1]"
+		for _, idx := range strings.Split(rest, "[") {
+			digits, ok := strings.CutSuffix(idx, "]")
+			if !ok {
+				return nil, invalid("missing ]")
+			}
+			n, err := strconv.Atoi(digits)
+			if err != nil || n < 0 {
+				return nil, invalid("list index must be a non-negative integer")
+			}
+			elems = append(elems, pathElem{index: n, isIndex: true})
+		}
+	}
+	if len(elems) > maxPathDepth {
+		return nil, invalid(fmt.Sprintf("more than %d levels", maxPathDepth))
+	}
+
+	return elems, nil
+}
+
+// parseDocPaths parses several document paths, rejecting duplicated or overlapping ones like DynamoDB does.
+func parseDocPaths(paths []string) ([][]pathElem, error) {
+	if len(paths) > maxPaths {
+		return nil, fmt.Errorf("%w: more than %d paths", repository.ErrInvalidPath, maxPaths)
+	}
+
+	parsed := make([][]pathElem, 0, len(paths))
+	for _, path := range paths {
+		elems, err := parseDocPath(path)
+		if err != nil {
+			return nil, err
+		}
+		for i, other := range parsed {
+			if hasPathPrefix(elems, other) || hasPathPrefix(other, elems) {
+				return nil, fmt.Errorf("%w: %q overlaps with %q", repository.ErrInvalidPath, path, paths[i])
+			}
+		}
+		parsed = append(parsed, elems)
+	}
+
+	return parsed, nil
+}
+
+func hasPathPrefix(path, prefix []pathElem) bool {
+	return len(prefix) <= len(path) && slices.Equal(path[:len(prefix)], prefix)
+}
+
+// blobProjection builds a projection expression of paths within the blob data.
+func blobProjection(paths [][]pathElem) (string, []any) {
+	var (
+		exprs []string
+		args  []any
+	)
+	for _, path := range paths {
+		expr, pathArgs := blobPathExpr(path)
+		exprs = append(exprs, expr)
+		args = append(args, pathArgs...)
+	}
+
+	return strings.Join(exprs, ", "), args
+}
+
+// blobPathExpr builds the expression of a path within the blob data, using name placeholders
+// for every attribute name so that reserved words and special characters are supported.
+func blobPathExpr(path []pathElem) (string, []any) {
+	var expr strings.Builder
+	expr.WriteString("$")
+	args := []any{blobDataAttribute}
+	for _, elem := range path {
+		if elem.isIndex {
+			fmt.Fprintf(&expr, "[%d]", elem.index)
+			continue
+		}
+		expr.WriteString(".$")
+		args = append(args, elem.key)
+	}
+
+	return expr.String(), args
+}
+
+// projectDoc extracts paths from a decoded JSON document, shaping the result like a DynamoDB projection:
+// parent maps are kept, projected list elements are compacted in index order and missing paths are omitted.
+func projectDoc(doc any, paths [][]pathElem) map[string]any {
+	root := &projectionNode{}
+	for _, path := range paths {
+		root.insert(doc, path)
+	}
+
+	out, _ := root.build().(map[string]any)
+	if out == nil {
+		out = map[string]any{}
+	}
+	return out
+}
+
+type projectionNode struct {
+	value any // set on leaves
+	leaf  bool
+	keys  map[string]*projectionNode
+	items map[int]*projectionNode
+}
+
+func (n *projectionNode) insert(doc any, path []pathElem) {
+	if len(path) == 0 {
+		n.value, n.leaf = doc, true
+		return
+	}
+
+	elem := path[0]
+	if elem.isIndex {
+		list, ok := doc.([]any)
+		if !ok || elem.index >= len(list) {
+			return
+		}
+		if n.items == nil {
+			n.items = make(map[int]*projectionNode)
+		}
+		child, ok := n.items[elem.index]
+		if !ok {
+			child = &projectionNode{}
+		}
+		child.insert(list[elem.index], path[1:])
+		if !child.empty() {
+			n.items[elem.index] = child
+		}
+		return
+	}
+
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return
+	}
+	value, ok := m[elem.key]
+	if !ok {
+		return
+	}
+	if n.keys == nil {
+		n.keys = make(map[string]*projectionNode)
+	}
+	child, ok := n.keys[elem.key]
+	if !ok {
+		child = &projectionNode{}
+	}
+	child.insert(value, path[1:])
+	if !child.empty() {
+		n.keys[elem.key] = child
+	}
+}
+
+func (n *projectionNode) empty() bool {
+	return !n.leaf && len(n.keys) == 0 && len(n.items) == 0
+}
+
+func (n *projectionNode) build() any {
+	switch {
+	case n.leaf:
+		return n.value
+	case len(n.items) > 0:
+		indexes := make([]int, 0, len(n.items))
+		for i := range n.items {
+			indexes = append(indexes, i)
+		}
+		slices.Sort(indexes)
+		list := make([]any, 0, len(indexes))
+		for _, i := range indexes {
+			list = append(list, n.items[i].build())
+		}
+		return list
+	default:
+		m := make(map[string]any, len(n.keys))
+		for k, child := range n.keys {
+			m[k] = child.build()
+		}
+		return m
+	}
+}
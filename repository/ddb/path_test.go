@@ -0,0 +1,69 @@
+package ddb
+
+import (
+	"encoding/json"
+	"personalisation-poc/repository"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDocPath(t *testing.T) {
+	elems, err := parseDocPath("segments[0][2].top_categories")
+	require.NoError(t, err)
+	require.Equal(t, []pathElem{
+		{key: "segments"},
+		{index: 0, isIndex: true},
+		{index: 2, isIndex: true},
+		{key: "top_categories"},
+	}, elems)
+
+	for _, path := range []string{"", "a..b", ".a", "a[", "a[x]", "a[-1]", "a]", "[0]", "a[0]b"} {
+		_, err := parseDocPath(path)
+		require.ErrorIs(t, err, repository.ErrInvalidPath, path)
+	}
+
+	_, err = parseDocPath(strings.Repeat("a", maxPathLength+1))
+	require.ErrorIs(t, err, repository.ErrInvalidPath)
+
+	_, err = parseDocPaths([]string{"segments[0]", "segments[0].type"})
+	require.ErrorIs(t, err, repository.ErrInvalidPath)
+
+	paths := make([]string, maxPaths+1)
+	for i := range paths {
+		paths[i] = "a" + strconv.Itoa(i)
+	}
+	_, err = parseDocPaths(paths)
+	require.ErrorIs(t, err, repository.ErrInvalidPath)
+}
+
+func TestBlobProjection(t *testing.T) {
+	paths, err := parseDocPaths([]string{"tags", "segments[1].type"})
+	require.NoError(t, err)
+
+	expr, args := blobProjection(paths)
+	require.Equal(t, "$.$, $.$[1].$", expr)
+	require.Equal(t, []any{"rawdata", "tags", "rawdata", "segments", "type"}, args)
+}
+
+func TestProjectDoc(t *testing.T) {
+	var doc any
+	err := json.Unmarshal([]byte(`{
+		"tags": ["a", "b"],
+		"segments": [
+			{"type": "x", "top_categories": ["c1"]},
+			{"type": "y", "top_categories": ["c2"]},
+			{"type": "z", "top_categories": ["c3"]}
+		]
+	}`), &doc)
+	require.NoError(t, err)
+
+	paths, err := parseDocPaths([]string{"segments[2].type", "segments[0].top_categories", "missing", "tags[5]"})
+	require.NoError(t, err)
+
+	out, err := json.Marshal(projectDoc(doc, paths))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"segments": [{"top_categories": ["c1"]}, {"type": "z"}]}`, string(out))
+}
@@ -17,12 +17,13 @@ func toCanonicalProfile(u user, ss []segment) *model.Profile {
 		}),
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
-		ExpiresAt: time.Unix(u.TTL, 0),
+		ExpiresAt: expiresAt(u.TTL),
 	}
 }
 
-func toDBItems(p model.Profile) (user, []segment) {
-	return toDBUser(p), lo.Map(p.Segments, func(s model.Segment, _ int) segment {
-		return toDBSegment(s, p.ID.String(), s.Type)
+// toDBItems converts a profile to its items, expiring the ones without an expiry date after their item type's TTL.
+func toDBItems(p model.Profile, userTTL, segmentTTL time.Duration) (user, []segment) {
+	return toDBUser(p, userTTL), lo.Map(p.Segments, func(s model.Segment, _ int) segment {
+		return toDBSegment(s, p.ID.String(), s.Type, segmentTTL)
 	})
 }
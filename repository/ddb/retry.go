@@ -0,0 +1,94 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"personalisation-poc/repository"
+	"time"
+)
+
+// RetryPolicy configures how calls failing with a transient error are retried.
+// Throttled calls are always safe to retry, calls failing with an unavailable database are only retried when idempotent:
+// the write may have been applied before the connection dropped.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a call, including the first one. 1 disables retries.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubled at every retry up to MaxDelay.
+	// The actual delay is drawn at random below it (full jitter) to spread the retries of concurrent calls.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout is the deadline of a call, retries included. Zero leaves it to the caller's context.
+	Timeout time.Duration
+}
+
+// DefaultRetryPolicy is the policy of a DB created without WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   25 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// WithRetryPolicy sets the retry policy of the DynamoDB calls, DefaultRetryPolicy by default.
+// The SDK's own retries should be disabled so that attempts don't multiply.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(db *DB) {
+		if p.MaxAttempts < 1 {
+			p.MaxAttempts = 1
+		}
+		db.retry = p
+	}
+}
+
+// backoff returns the delay before the given retry, starting at 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < retry && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// retryable reports whether a call that failed with err can be attempted again.
+func retryable(err error, idempotent bool) bool {
+	switch {
+	case errors.Is(err, repository.ErrOverloaded):
+		return false // shed by this instance, retrying would only add load
+	case errors.Is(err, repository.ErrThrottled):
+		return true
+	case errors.Is(err, repository.ErrUnavailable):
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// attempt runs call until it succeeds, fails with a permanent error or runs out of attempts.
+func (d *DB) attempt(ctx context.Context, idempotent bool, call func(ctx context.Context) error) error {
+	if d.retry.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.retry.Timeout)
+		defer cancel()
+	}
+
+	for n := 1; ; n++ {
+		err := mapError(call(ctx))
+		if err == nil || n >= d.retry.MaxAttempts || !retryable(err, idempotent) {
+			return err
+		}
+
+		timer := time.NewTimer(d.retry.backoff(n))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err // the last error explains the failure better than the deadline
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package ddb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for retry, ceiling := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 40: 50} {
+		for range 100 {
+			require.LessOrEqual(t, p.backoff(retry), ceiling*time.Millisecond, retry)
+		}
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+	unavailable := &smithy.GenericAPIError{Code: "InternalServerError"}
+	db := NewDB(newTestTable("http://localhost", testTableName, nil), WithRetryPolicy(RetryPolicy{MaxAttempts: 3}))
+
+	for name, tc := range map[string]struct {
+		errs       []error
+		idempotent bool
+		attempts   int
+		err        error
+	}{
+		"success":                    {errs: []error{nil}, attempts: 1},
+		"throttled once":             {errs: []error{throttled, nil}, attempts: 2},
+		"throttled":                  {errs: []error{throttled, throttled, throttled}, attempts: 3, err: repository.ErrThrottled},
+		"unavailable idempotent":     {errs: []error{unavailable, nil}, idempotent: true, attempts: 2},
+		"unavailable not idempotent": {errs: []error{unavailable}, attempts: 1, err: repository.ErrUnavailable},
+		"throttled not idempotent":   {errs: []error{throttled, nil}, attempts: 2},
+		"permanent":                  {errs: []error{errors.New("boom")}, idempotent: true, attempts: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			attempts := 0
+			err := db.attempt(context.Background(), tc.idempotent, func(context.Context) error {
+				attempts++
+				return tc.errs[attempts-1]
+			})
+			require.Equal(t, tc.attempts, attempts)
+			if tc.errs[attempts-1] == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, tc.errs[attempts-1])
+			}
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		db := NewDB(db.table, WithRetryPolicy(RetryPolicy{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: time.Second, Timeout: 50 * time.Millisecond}))
+		start := time.Now()
+		err := db.attempt(context.Background(), true, func(context.Context) error {
+			return throttled
+		})
+		require.ErrorIs(t, err, repository.ErrThrottled)
+		require.Less(t, time.Since(start), time.Second)
+	})
+}
+
+// faultTransport fails the first requests of the DynamoDB operations it's configured for.
+type faultTransport struct {
+	mu     sync.Mutex
+	faults map[string][]fault // by operation, e.g. "PutItem"
+	calls  map[string]int
+}
+
+type fault int
+
+const (
+	faultThrottle    fault = iota // rejected with ProvisionedThroughputExceededException
+	faultDrop                     // the connection fails after the request is sent
+	faultUnprocessed              // batch writes are accepted without processing any item
+)
+
+func (f *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op := strings.TrimPrefix(req.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+	f.mu.Lock()
+	f.calls[op]++
+	var next *fault
+	if faults := f.faults[op]; len(faults) > 0 {
+		next = &faults[0]
+		f.faults[op] = faults[1:]
+	}
+	f.mu.Unlock()
+
+	if next == nil {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	switch *next {
+	case faultThrottle:
+		return dynamoResponse(req, http.StatusBadRequest, map[string]any{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException",
+			"message": "injected throttling",
+		}), nil
+	case faultDrop:
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, errors.New("injected connection reset")
+	default:
+		var input struct {
+			RequestItems map[string]any
+		}
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			return nil, err
+		}
+		return dynamoResponse(req, http.StatusOK, map[string]any{"UnprocessedItems": input.RequestItems}), nil
+	}
+}
+
+func (f *faultTransport) count(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+func dynamoResponse(req *http.Request, status int, body any) *http.Response {
+	data, _ := json.Marshal(body)
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"application/x-amz-json-1.0"}},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}
+}
+
+func TestRetryDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := testContext(t)
+
+	transport := &faultTransport{
+		faults: map[string][]fault{
+			"BatchWriteItem": {faultThrottle, faultUnprocessed},
+			"Query":          {faultDrop, faultThrottle},
+			"PutItem":        {faultDrop},
+			"UpdateItem":     {faultDrop},
+		},
+		calls: make(map[string]int),
+	}
+	db := NewDB(newTestTable(endpoint, table, &http.Client{Transport: transport}), WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    10 * time.Millisecond,
+	}))
+
+	profile := model.Profile{
+		ID:   uuid.New(),
+		Tags: []string{"sports_fan"},
+		Segments: []model.Segment{{
+			Type:          model.MorningSegmentType,
+			Categories:    []model.Category{{ID: "sports", Score: 0.9}},
+			TopCategories: []string{"sports"},
+			CreatedAt:     time.Now(),
+		}},
+	}
+
+	t.Run("BatchWrite", func(t *testing.T) {
+		// throttled once by the repository's retries, then left unprocessed once and resent by the batch
+		require.NoError(t, db.UpsertProfile(ctx, profile))
+		require.Equal(t, 3, transport.count("BatchWriteItem"))
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		got, err := db.GetProfileByID(ctx, profile.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, profile.Tags, got.Tags)
+		require.Len(t, got.Segments, 1)
+		require.Equal(t, 3, transport.count("Query"))
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		blob, err := json.Marshal(profile)
+		require.NoError(t, err)
+		require.NoError(t, db.UpsertBlob(ctx, profile.ID.String(), blob))
+		require.Equal(t, 2, transport.count("PutItem"))
+	})
+
+	t.Run("PatchIsNotRetriedOnConnectionErrors", func(t *testing.T) {
+		err := db.PatchBlob(ctx, profile.ID.String(), []repository.PatchOperation{
+			{Op: "add", Path: "/tags/-", Value: json.RawMessage(`"vip"`)},
+		})
+		require.ErrorIs(t, err, repository.ErrUnavailable)
+		require.Equal(t, 1, transport.count("UpdateItem"))
+
+		// the dropped attempt was applied, a retry would have appended the tag twice
+		data, err := db.GetBlobPaths(ctx, profile.ID.String(), []string{"tags"})
+		require.NoError(t, err)
+		require.JSONEq(t, `{"tags":["sports_fan","vip"]}`, string(data))
+	})
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		transport.mu.Lock()
+		transport.faults["GetItem"] = []fault{faultThrottle, faultThrottle, faultThrottle}
+		transport.mu.Unlock()
+
+		_, err := db.GetUserTags(ctx, profile.ID.String())
+		require.ErrorIs(t, err, repository.ErrThrottled)
+	})
+}
@@ -0,0 +1,79 @@
+package ddb
+
+import (
+	"context"
+	"fmt"
+	"personalisation-poc/repository"
+
+	"github.com/guregu/dynamo/v2"
+)
+
+// scanPageSize is the number of items evaluated by each request of a scan.
+const scanPageSize = 1000
+
+func (d *DB) ScanProfileIDs(ctx context.Context, rep repository.Representation, fn func(id string) error) error {
+	switch rep {
+	case repository.RepresentationProfile:
+		return d.scanIDs(ctx, userItemKeyPrefix, nil, fn)
+	case repository.RepresentationBlob:
+		return d.ScanBlobIDs(ctx, "", fn)
+	default:
+		return fmt.Errorf("unknown representation %q", rep)
+	}
+}
+
+func (d *DB) ScanBlobIDs(ctx context.Context, blobType string, fn func(id string) error) error {
+	filter := func(scan *dynamo.Scan) {
+		if blobType == "" {
+			scan.Filter("attribute_not_exists(btype)") // blobs of other types aren't profiles
+		} else {
+			scan.Filter("btype = ?", blobType)
+		}
+	}
+	return d.scanIDs(ctx, blobItemKeyPrefix, filter, fn)
+}
+
+// scanIDs calls fn with the ID of every item of type typ kept by filter, when set.
+func (d *DB) scanIDs(ctx context.Context, typ string, filter func(scan *dynamo.Scan), fn func(id string) error) error {
+	// the table is scanned a page at a time, so that a failed page is retried on its own
+	// and fn is never called twice for the same item
+	var start dynamo.PagingKey
+	for {
+		var (
+			items []struct {
+				ID string `dynamo:"id"`
+			}
+			next dynamo.PagingKey
+		)
+		err := d.do(ctx, func(ctx context.Context) error {
+			items = items[:0]
+			scan := d.table.Scan().Filter("$ = ?", itemType, typ).Project("id").SearchLimit(scanPageSize)
+			if filter != nil {
+				filter(scan)
+			}
+			if !repository.ExpiredIncluded(ctx) {
+				expr, args := notExpiredFilter()
+				scan.Filter(expr, args...)
+			}
+			if start != nil {
+				scan.StartFrom(start)
+			}
+			var err error
+			next, err = scan.AllWithLastEvaluatedKey(ctx, &items)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if err := fn(item.ID); err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		start = next
+	}
+}
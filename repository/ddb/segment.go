@@ -18,7 +18,8 @@ type segment struct {
 	TopCategories []string   `dynamo:"top_cats,set,omitempty"`
 	CreatedAt     time.Time  `dynamo:"created_at"`
 	UpdatedAt     time.Time  `dynamo:"updated_at"`
-	TTL           int64      `dynamo:"ttl,unixtime"` // TTL for the segment
+	TTL           int64      `dynamo:"ttl,omitempty"`       // TTL for the segment, zero when it doesn't expire
+	FixedTTL      bool       `dynamo:"ttl_fixed,omitempty"` // TTL set from an explicit expiry date, never slid
 }
 
 type category struct {
@@ -38,11 +39,11 @@ func toCanonicalSegment(dbModel segment) *model.Segment {
 		TopCategories: dbModel.TopCategories,
 		CreatedAt:     dbModel.CreatedAt,
 		UpdatedAt:     dbModel.UpdatedAt,
-		ExpiresAt:     time.Unix(dbModel.TTL, 0),
+		ExpiresAt:     expiresAt(dbModel.TTL),
 	}
 }
 
-func toDBSegment(seg model.Segment, profileID, segmentType string) segment {
+func toDBSegment(seg model.Segment, profileID, segmentType string, ttl time.Duration) segment {
 	return segment{
 		PK:          buildPK(profileID),
 		SK:          buildSK(segmentItemKeyPrefix, segmentType, &seg.CreatedAt),
@@ -57,6 +58,7 @@ func toDBSegment(seg model.Segment, profileID, segmentType string) segment {
 		TopCategories: seg.TopCategories,
 		CreatedAt:     seg.CreatedAt,
 		UpdatedAt:     seg.UpdatedAt,
-		TTL:           seg.ExpiresAt.Unix(),
+		TTL:           expiry(seg.ExpiresAt, ttl),
+		FixedTTL:      !seg.ExpiresAt.IsZero(),
 	}
 }
@@ -2,18 +2,25 @@ package ddb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"personalisation-poc/model"
+	"personalisation-poc/repository"
+
+	"github.com/guregu/dynamo/v2"
 )
 
 func (d *DB) UpsertProfile(ctx context.Context, profile model.Profile) error {
-	user, segments := toDBItems(profile)
+	user, segments := toDBItems(profile, d.userTTL, d.segmentTTL)
 
 	bw := d.table.Batch().Write().Put(user)
 	for _, segment := range segments {
 		bw.Put(segment)
 	}
-	_, err := bw.Run(ctx)
+	err := d.do(ctx, func(ctx context.Context) error {
+		_, err := bw.Run(ctx)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to write batch: %w", err)
 	}
@@ -21,15 +28,60 @@ func (d *DB) UpsertProfile(ctx context.Context, profile model.Profile) error {
 	return nil
 }
 
-func (d *DB) UpsertBlob(ctx context.Context, profileID string, data []byte) error {
-	blob, err := toDBBlob(profileID, data)
+func (d *DB) UpsertBlob(ctx context.Context, profileID string, data []byte, opts ...repository.BlobOption) error {
+	options := repository.BlobOptions{Encoding: repository.BlobEncodingMap}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	head, chunks, err := toDBBlob(profileID, data, options.Encoding, options.ExpiresAt, d.blobTTL)
 	if err != nil {
 		return fmt.Errorf("failed to parse blob data: %w", err)
 	}
+	head.Type = options.Type
 
-	err = d.table.Put(blob).Run(ctx)
+	// the chunks are written before the head item referencing them, under a version of their own,
+	// so that the reads of the previous version keep finding its chunks until they are deleted
+	if len(chunks) > 0 {
+		bw := d.table.Batch().Write()
+		for _, chunk := range chunks {
+			bw.Put(chunk)
+		}
+		err = d.do(ctx, func(ctx context.Context) error {
+			_, err := bw.Run(ctx)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write blob chunks: %w", err)
+		}
+	}
+
+	var old blob
+	err = d.do(ctx, func(ctx context.Context) error {
+		return d.table.Put(head).OldValue(ctx, &old)
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to write blob: %w", err)
 	}
+
+	// the chunks of the version replaced, unless a retry returned this write as the old value
+	if old.Chunks == 0 || (old.Version == head.Version && old.Version != "") {
+		return nil
+	}
+	bw := d.table.Batch(partitionKey, sortKey).Write()
+	for n := range old.Chunks {
+		bw.Delete(dynamo.Keys{buildPK(profileID), buildBlobChunkSK(profileID, old.Version, n)})
+	}
+	err = d.do(ctx, func(ctx context.Context) error {
+		_, err := bw.Run(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete previous blob chunks: %w", err)
+	}
+
 	return nil
 }
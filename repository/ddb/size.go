@@ -0,0 +1,139 @@
+package ddb
+
+import (
+	"encoding/json"
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/guregu/dynamo/v2"
+)
+
+// ItemSizes are the sizes in bytes of the items a profile is stored as, as DynamoDB accounts for them
+// when computing the capacity consumed by reads and writes.
+type ItemSizes struct {
+	// User is the size of the USER item.
+	User int
+	// Segments are the sizes of the SEG items, in the order of the profile's segments.
+	Segments []int
+	// Blob is the size of the BLOB item, and BlobChunks the sizes of its chunks when it's compressed and split.
+	Blob       int
+	BlobChunks []int
+}
+
+// ItemSizes encodes profile the way UpsertProfile and UpsertBlob with enc store it, and returns the sizes of the items.
+func (d *DB) ItemSizes(profile model.Profile, enc repository.BlobEncoding) (ItemSizes, error) {
+	var sizes ItemSizes
+
+	user, segments := toDBItems(profile, d.userTTL, d.segmentTTL)
+	var err error
+	if sizes.User, err = marshalledSize(user); err != nil {
+		return ItemSizes{}, err
+	}
+	for _, seg := range segments {
+		size, err := marshalledSize(seg)
+		if err != nil {
+			return ItemSizes{}, err
+		}
+		sizes.Segments = append(sizes.Segments, size)
+	}
+
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return ItemSizes{}, err
+	}
+	blob, chunks, err := toDBBlob(profile.ID.String(), data, enc, time.Time{}, d.blobTTL)
+	if err != nil {
+		return ItemSizes{}, err
+	}
+	if sizes.Blob, err = marshalledSize(blob); err != nil {
+		return ItemSizes{}, err
+	}
+	for _, chunk := range chunks {
+		size, err := marshalledSize(chunk)
+		if err != nil {
+			return ItemSizes{}, err
+		}
+		sizes.BlobChunks = append(sizes.BlobChunks, size)
+	}
+
+	return sizes, nil
+}
+
+func marshalledSize(v any) (int, error) {
+	item, err := dynamo.MarshalItem(v)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal item: %w", err)
+	}
+	return itemSize(item), nil
+}
+
+// itemSize returns the size of an item: the lengths of its attribute names plus the sizes of their values.
+// See https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/CapacityUnitCalculations.html
+func itemSize(item map[string]types.AttributeValue) int {
+	size := 0
+	for name, v := range item {
+		size += len(name) + valueSize(v)
+	}
+	return size
+}
+
+func valueSize(v types.AttributeValue) int {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return len(v.Value)
+	case *types.AttributeValueMemberN:
+		return numberSize(v.Value)
+	case *types.AttributeValueMemberB:
+		return len(v.Value)
+	case *types.AttributeValueMemberBOOL, *types.AttributeValueMemberNULL:
+		return 1
+	case *types.AttributeValueMemberSS:
+		size := 0
+		for _, s := range v.Value {
+			size += len(s)
+		}
+		return size
+	case *types.AttributeValueMemberNS:
+		size := 0
+		for _, n := range v.Value {
+			size += numberSize(n)
+		}
+		return size
+	case *types.AttributeValueMemberBS:
+		size := 0
+		for _, b := range v.Value {
+			size += len(b)
+		}
+		return size
+	case *types.AttributeValueMemberL:
+		// 3 bytes for the list, and 1 byte per element
+		size := 3
+		for _, e := range v.Value {
+			size += 1 + valueSize(e)
+		}
+		return size
+	case *types.AttributeValueMemberM:
+		// 3 bytes for the map, and 1 byte per element besides its name
+		size := 3
+		for name, e := range v.Value {
+			size += 1 + len(name) + valueSize(e)
+		}
+		return size
+	default:
+		return 0
+	}
+}
+
+// numberSize is the size of a number: one byte per two significant digits, leading and trailing zeros trimmed, plus one byte.
+func numberSize(n string) int {
+	mantissa, _, _ := strings.Cut(strings.ToLower(n), "e")
+	digits := strings.Trim(strings.NewReplacer("+", "", "-", "", ".", "").Replace(mantissa), "0")
+	if digits == "" {
+		digits = "0"
+	}
+	return (len(digits)+1)/2 + 1
+}
@@ -0,0 +1,70 @@
+package ddb
+
+import (
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestItemSize(t *testing.T) {
+	for name, tc := range map[string]struct {
+		item map[string]types.AttributeValue
+		size int
+	}{
+		"string": {item: map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "USER#1"}}, size: 2 + 6},
+		"number": {item: map[string]types.AttributeValue{"n": &types.AttributeValueMemberN{Value: "-12345.600"}}, size: 1 + 4},
+		"zero":   {item: map[string]types.AttributeValue{"n": &types.AttributeValueMemberN{Value: "0"}}, size: 1 + 2},
+		"binary": {item: map[string]types.AttributeValue{"b": &types.AttributeValueMemberB{Value: make([]byte, 10)}}, size: 1 + 10},
+		"bool":   {item: map[string]types.AttributeValue{"ok": &types.AttributeValueMemberBOOL{Value: true}}, size: 2 + 1},
+		"list": {item: map[string]types.AttributeValue{"tags": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+			&types.AttributeValueMemberS{Value: "a"}, &types.AttributeValueMemberS{Value: "bc"},
+		}}}, size: 4 + 3 + (1 + 1) + (1 + 2)},
+		"map": {item: map[string]types.AttributeValue{"cat": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: "sports"},
+		}}}, size: 3 + 3 + (1 + 2 + 6)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.size, itemSize(tc.item))
+		})
+	}
+}
+
+func TestItemSizes(t *testing.T) {
+	db := NewDB(newTestTable("http://localhost", testTableName, nil), WithTTL(time.Hour))
+	profile := model.Profile{
+		ID:   uuid.New(),
+		Tags: []string{"sports_fan"},
+		Segments: []model.Segment{
+			{Type: model.MorningSegmentType, Categories: []model.Category{{ID: "sports", Score: 0.5}}, TopCategories: []string{"sports"}, CreatedAt: time.Now()},
+			{Type: model.EveningSegmentType, Categories: []model.Category{{ID: "sports", Score: 0.5}}, TopCategories: []string{"sports"}, CreatedAt: time.Now()},
+		},
+	}
+
+	sizes, err := db.ItemSizes(profile, repository.BlobEncodingMap)
+	require.NoError(t, err)
+	require.Len(t, sizes.Segments, 2)
+	require.Positive(t, sizes.User)
+	require.Positive(t, sizes.Segments[0])
+	require.Empty(t, sizes.BlobChunks)
+	// the blob holds the whole profile
+	require.Greater(t, sizes.Blob, sizes.Segments[0]+sizes.Segments[1])
+
+	// a compressed blob too large for an item is split into chunks
+	vocabulary := make([]string, 2000)
+	for i := range vocabulary {
+		vocabulary[i] = strings.Repeat("x", 8) + uuid.NewString()
+	}
+	large := model.NewGenerator(model.WithCategories(vocabulary...), model.WithCategoriesPerSegment(2000, 2000), model.WithHistory(20, time.Hour)).Profile()
+	sizes, err = db.ItemSizes(*large, repository.BlobEncodingGzip)
+	require.NoError(t, err)
+	require.NotEmpty(t, sizes.BlobChunks)
+	for _, size := range sizes.BlobChunks {
+		require.Less(t, size, 400*1024)
+	}
+}
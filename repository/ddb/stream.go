@@ -0,0 +1,204 @@
+package ddb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo/v2"
+)
+
+// ChangeOp is the kind of write recorded by a stream record.
+type ChangeOp string
+
+const (
+	ChangeInsert ChangeOp = "INSERT"
+	ChangeModify ChangeOp = "MODIFY"
+	ChangeRemove ChangeOp = "REMOVE"
+)
+
+// The item types of the changes.
+const (
+	UserItemType    = userItemKeyPrefix
+	SegmentItemType = segmentItemKeyPrefix
+	BlobItemType    = blobItemKeyPrefix
+)
+
+// Change is a write to an item of a profile, decoded from a DynamoDB Streams record.
+//
+// USER items are decoded as a profile without segments, SEG items as a segment,
+// and BLOB items as the profile they hold. The images are nil when the item didn't exist before or after the change,
+// and the profiles of blobs split across chunks are only decoded from their chunks, so they are nil too.
+// A blob replacing one of another type, or replaced by one, is an insert or a removal of the profile blob.
+type Change struct {
+	Op             ChangeOp
+	Representation repository.Representation
+	// ItemType is UserItemType, SegmentItemType or BlobItemType.
+	ItemType  string
+	ProfileID string
+	// BlobType is the type of the blob after the change, or before it when removed, empty for profiles.
+	BlobType string
+	// At is the approximate time of the change, and SequenceNumber its position in its shard.
+	At             time.Time
+	SequenceNumber string
+	// Expired is set when the item was removed by the TTL process.
+	Expired bool
+
+	OldProfile, NewProfile *model.Profile
+	OldSegment, NewSegment *model.Segment
+}
+
+// StreamARN returns the ARN of the latest stream of the table, enabled by Migrate.
+func StreamARN(ctx context.Context, db *dynamo.DB, tableName string) (string, error) {
+	desc, err := db.Table(tableName).Describe().Run(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !desc.StreamEnabled || desc.LatestStreamARN == "" {
+		return "", fmt.Errorf("table %s has no stream, it needs to be migrated", tableName)
+	}
+	return desc.LatestStreamARN, nil
+}
+
+// DecodeStreamRecord decodes a record of the table's stream. It returns false for the items that aren't part of a profile
+// on their own, e.g. the blob chunks and the schema version, and for the blobs of other types than profiles.
+func DecodeStreamRecord(r streamtypes.Record) (Change, bool, error) {
+	if r.Dynamodb == nil {
+		return Change{}, false, errors.New("stream record without data")
+	}
+	oldImage, err := streamImage(r.Dynamodb.OldImage)
+	if err != nil {
+		return Change{}, false, err
+	}
+	newImage, err := streamImage(r.Dynamodb.NewImage)
+	if err != nil {
+		return Change{}, false, err
+	}
+
+	c := Change{
+		Op:             ChangeOp(r.EventName),
+		SequenceNumber: aws.ToString(r.Dynamodb.SequenceNumber),
+		// removals by the TTL process are made by the DynamoDB service itself
+		Expired: r.UserIdentity != nil && aws.ToString(r.UserIdentity.Type) == "Service" &&
+			aws.ToString(r.UserIdentity.PrincipalId) == "dynamodb.amazonaws.com",
+	}
+	if r.Dynamodb.ApproximateCreationDateTime != nil {
+		c.At = *r.Dynamodb.ApproximateCreationDateTime
+	}
+
+	image := newImage
+	if image == nil {
+		image = oldImage
+	}
+	if typ, ok := image[itemType].(*types.AttributeValueMemberS); ok {
+		c.ItemType = typ.Value
+	}
+	if pk, ok := image[partitionKey].(*types.AttributeValueMemberS); ok {
+		c.ProfileID = strings.TrimPrefix(pk.Value, userItemKeyPrefix+keySeparator)
+	}
+	c.BlobType = imageBlobType(image)
+
+	switch c.ItemType {
+	case UserItemType:
+		c.Representation = repository.RepresentationProfile
+		c.OldProfile, err = decodeImage(oldImage, func(u user) (*model.Profile, error) { return toCanonicalProfile(u, nil), nil })
+		if err == nil {
+			c.NewProfile, err = decodeImage(newImage, func(u user) (*model.Profile, error) { return toCanonicalProfile(u, nil), nil })
+		}
+	case SegmentItemType:
+		c.Representation = repository.RepresentationProfile
+		c.OldSegment, err = decodeImage(oldImage, func(s segment) (*model.Segment, error) { return toCanonicalSegment(s), nil })
+		if err == nil {
+			c.NewSegment, err = decodeImage(newImage, func(s segment) (*model.Segment, error) { return toCanonicalSegment(s), nil })
+		}
+	case BlobItemType:
+		c.Representation = repository.RepresentationBlob
+		oldTyped := oldImage != nil && imageBlobType(oldImage) != ""
+		newTyped := newImage != nil && imageBlobType(newImage) != ""
+		switch {
+		case (oldImage == nil || oldTyped) && (newImage == nil || newTyped):
+			return Change{}, false, nil // not a profile, before or after
+		case oldTyped:
+			c.Op, oldImage = ChangeInsert, nil
+		case newTyped:
+			c.Op, newImage = ChangeRemove, nil
+		}
+		c.OldProfile, err = decodeImage(oldImage, decodeStreamBlob)
+		if err == nil {
+			c.NewProfile, err = decodeImage(newImage, decodeStreamBlob)
+		}
+	default:
+		return Change{}, false, nil
+	}
+	if err != nil {
+		return Change{}, false, fmt.Errorf("failed to decode %s item of profile %s: %w", c.ItemType, c.ProfileID, err)
+	}
+
+	return c, true, nil
+}
+
+// streamImage converts an image of the stream to the attribute values of the DynamoDB API, nil when there's no image.
+func streamImage(image map[string]streamtypes.AttributeValue) (map[string]types.AttributeValue, error) {
+	if len(image) == 0 {
+		return nil, nil
+	}
+	return attributevalue.FromDynamoDBStreamsMap(image)
+}
+
+// imageBlobType returns the type of the blob of an image, empty for profiles and the other items.
+func imageBlobType(image map[string]types.AttributeValue) string {
+	if typ, ok := image["btype"].(*types.AttributeValueMemberS); ok {
+		return typ.Value
+	}
+	return ""
+}
+
+// decodeImage unmarshals the image of an item into its database model, then converts it. A nil image is decoded as nil.
+func decodeImage[I any, T any](image map[string]types.AttributeValue, convert func(I) (*T, error)) (*T, error) {
+	if image == nil {
+		return nil, nil
+	}
+	var item I
+	if err := dynamo.UnmarshalItem(image, &item); err != nil {
+		return nil, err
+	}
+	return convert(item)
+}
+
+// decodeStreamBlob returns the profile held by a blob, nil when it's split across chunks or isn't a profile.
+func decodeStreamBlob(b blob) (*model.Profile, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch enc := b.blobEncoding(); {
+	case b.Chunks > 0 || b.Type != "":
+		return nil, nil
+	case enc == repository.BlobEncodingMap:
+		data, err = json.Marshal(b.Data)
+	default:
+		data, err = decompress(enc, b.Payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p model.Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if p.ID == uuid.Nil {
+		p.ID, err = uuid.Parse(b.ID)
+	}
+	return &p, err
+}
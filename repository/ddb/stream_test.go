@@ -0,0 +1,228 @@
+package ddb
+
+import (
+	"fmt"
+	"net/http"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeStreamRecord(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	profile := model.Profile{
+		ID:        uuid.New(),
+		Tags:      []string{"sports_fan"},
+		CreatedAt: now,
+		UpdatedAt: now,
+		Segments: []model.Segment{{
+			Type:          model.MorningSegmentType,
+			Categories:    []model.Category{{ID: "sports", Score: 0.9}},
+			TopCategories: []string{"sports"},
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}},
+	}
+	id := profile.ID.String()
+	user, segments := toDBItems(profile, 0, 0)
+
+	change, ok, err := DecodeStreamRecord(streamRecord(t, "INSERT", nil, user))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, ChangeInsert, change.Op)
+	require.Equal(t, UserItemType, change.ItemType)
+	require.Equal(t, repository.RepresentationProfile, change.Representation)
+	require.Equal(t, id, change.ProfileID)
+	require.Nil(t, change.OldProfile)
+	require.Equal(t, profile.Tags, change.NewProfile.Tags)
+	require.Empty(t, change.NewProfile.Segments)
+
+	change, ok, err = DecodeStreamRecord(streamRecord(t, "MODIFY", segments[0], segments[0]))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, SegmentItemType, change.ItemType)
+	require.Equal(t, id, change.ProfileID)
+	require.Equal(t, profile.Segments[0], *change.OldSegment)
+	require.Equal(t, profile.Segments[0], *change.NewSegment)
+
+	data := []byte(fmt.Sprintf(`{"id":%q,"tags":["sports_fan"]}`, id))
+	for _, enc := range []repository.BlobEncoding{repository.BlobEncodingMap, repository.BlobEncodingZstd} {
+		blob, _, err := toDBBlob(id, data, enc, time.Time{}, 0)
+		require.NoError(t, err)
+		change, ok, err = DecodeStreamRecord(streamRecord(t, "REMOVE", blob, nil))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, ChangeRemove, change.Op)
+		require.Equal(t, repository.RepresentationBlob, change.Representation)
+		require.Equal(t, []string{"sports_fan"}, change.OldProfile.Tags)
+		require.Nil(t, change.NewProfile)
+	}
+
+	// the blobs of other types aren't profiles
+	order, _, err := toDBBlob(id, []byte(fmt.Sprintf(`{"id":%q,"total":42}`, id)), repository.BlobEncodingMap, time.Time{}, 0)
+	require.NoError(t, err)
+	order.Type = "order"
+	for _, r := range []streamtypes.Record{
+		streamRecord(t, "INSERT", nil, order),
+		streamRecord(t, "MODIFY", order, order),
+		streamRecord(t, "REMOVE", order, nil),
+	} {
+		_, ok, err = DecodeStreamRecord(r)
+		require.NoError(t, err)
+		require.False(t, ok, r.EventName)
+	}
+	// unless they replace a profile, or are replaced by one
+	profileBlob, _, err := toDBBlob(id, data, repository.BlobEncodingMap, time.Time{}, 0)
+	require.NoError(t, err)
+	change, ok, err = DecodeStreamRecord(streamRecord(t, "MODIFY", profileBlob, order))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, ChangeRemove, change.Op)
+	require.Equal(t, "order", change.BlobType)
+	require.Equal(t, []string{"sports_fan"}, change.OldProfile.Tags)
+	require.Nil(t, change.NewProfile)
+	change, ok, err = DecodeStreamRecord(streamRecord(t, "MODIFY", order, profileBlob))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, ChangeInsert, change.Op)
+	require.Empty(t, change.BlobType)
+	require.Nil(t, change.OldProfile)
+	require.Equal(t, []string{"sports_fan"}, change.NewProfile.Tags)
+
+	// chunks aren't changes of their own
+	_, ok, err = DecodeStreamRecord(streamRecord(t, "INSERT", nil, blobChunk{PK: buildPK(id), SK: buildBlobChunkSK(id, "v", 0), ItemType: blobChunkItemType}))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// removals by the TTL process
+	r := streamRecord(t, "REMOVE", user, nil)
+	r.UserIdentity = &streamtypes.Identity{Type: aws.String("Service"), PrincipalId: aws.String("dynamodb.amazonaws.com")}
+	change, _, err = DecodeStreamRecord(r)
+	require.NoError(t, err)
+	require.True(t, change.Expired)
+}
+
+func TestStreamDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := testContext(t)
+	client := dynamo.NewFromIface(newTestClient(endpoint, nil))
+	db := NewDB(client.Table(table))
+
+	profile := model.FakeProfile()
+	id := profile.ID.String()
+	require.NoError(t, db.UpsertProfile(ctx, *profile))
+	require.NoError(t, db.DeleteProfile(ctx, id))
+
+	arn, err := StreamARN(ctx, client, table)
+	require.NoError(t, err)
+	streams := dynamodbstreams.New(dynamodbstreams.Options{
+		BaseEndpoint: aws.String(endpoint),
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("dummy", "dummy", ""),
+		HTTPClient:   &http.Client{},
+	})
+	desc, err := streams.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(arn)})
+	require.NoError(t, err)
+
+	var changes []Change
+	for _, shard := range desc.StreamDescription.Shards {
+		it, err := streams.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+			StreamArn:         aws.String(arn),
+			ShardId:           shard.ShardId,
+			ShardIteratorType: streamtypes.ShardIteratorTypeTrimHorizon,
+		})
+		require.NoError(t, err)
+		out, err := streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: it.ShardIterator})
+		require.NoError(t, err)
+		for _, r := range out.Records {
+			c, ok, err := DecodeStreamRecord(r)
+			require.NoError(t, err)
+			if ok && c.ProfileID == id {
+				changes = append(changes, c)
+			}
+		}
+	}
+
+	// a USER and a SEG item per segment are inserted, then removed
+	items := 1 + len(profile.Segments)
+	require.Len(t, changes, 2*items)
+	for i, c := range changes {
+		op := ChangeInsert
+		if i >= items {
+			op = ChangeRemove
+		}
+		require.Equal(t, op, c.Op)
+		require.False(t, c.Expired)
+	}
+}
+
+// streamRecord returns the record of a change of an item, from its images in the stream, nil when it doesn't exist.
+func streamRecord(t *testing.T, op string, oldItem, newItem any) streamtypes.Record {
+	t.Helper()
+	image := func(item any) map[string]streamtypes.AttributeValue {
+		if item == nil {
+			return nil
+		}
+		av, err := dynamo.MarshalItem(item)
+		require.NoError(t, err)
+		return toStreamMap(av)
+	}
+	return streamtypes.Record{
+		EventName: streamtypes.OperationType(op),
+		Dynamodb: &streamtypes.StreamRecord{
+			ApproximateCreationDateTime: aws.Time(time.Now()),
+			SequenceNumber:              aws.String("100"),
+			OldImage:                    image(oldItem),
+			NewImage:                    image(newItem),
+		},
+	}
+}
+
+func toStreamMap(m map[string]types.AttributeValue) map[string]streamtypes.AttributeValue {
+	out := make(map[string]streamtypes.AttributeValue, len(m))
+	for k, v := range m {
+		out[k] = toStreamValue(v)
+	}
+	return out
+}
+
+func toStreamValue(v types.AttributeValue) streamtypes.AttributeValue {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return &streamtypes.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &streamtypes.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &streamtypes.AttributeValueMemberB{Value: v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return &streamtypes.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &streamtypes.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberSS:
+		return &streamtypes.AttributeValueMemberSS{Value: v.Value}
+	case *types.AttributeValueMemberNS:
+		return &streamtypes.AttributeValueMemberNS{Value: v.Value}
+	case *types.AttributeValueMemberBS:
+		return &streamtypes.AttributeValueMemberBS{Value: v.Value}
+	case *types.AttributeValueMemberM:
+		return &streamtypes.AttributeValueMemberM{Value: toStreamMap(v.Value)}
+	case *types.AttributeValueMemberL:
+		l := make([]streamtypes.AttributeValue, len(v.Value))
+		for i, e := range v.Value {
+			l[i] = toStreamValue(e)
+		}
+		return &streamtypes.AttributeValueMemberL{Value: l}
+	default:
+		panic(fmt.Sprintf("unexpected attribute value %T", v))
+	}
+}
@@ -0,0 +1,120 @@
+package ddb
+
+import (
+	"context"
+	"personalisation-poc/repository"
+	"time"
+
+	"github.com/guregu/dynamo/v2"
+)
+
+// ttlAttribute is the attribute holding the expiry time of the items, as a Unix timestamp.
+const ttlAttribute = "ttl"
+
+// fixedTTLAttribute flags the items whose TTL comes from an explicit expiry date, which sliding expiry keeps.
+const fixedTTLAttribute = "ttl_fixed"
+
+// maxSlidingUpdates caps the number of reads whose items are being slid in the background at once.
+// The updates don't count towards WithMaxInFlight, so that they never shed the requests of clients.
+const maxSlidingUpdates = 16
+
+// expiry returns the TTL of an item written now: its explicit expiry time when set,
+// ttl from now otherwise, and zero (no expiry) when ttl isn't positive either.
+func expiry(explicit time.Time, ttl time.Duration) int64 {
+	if !explicit.IsZero() {
+		return explicit.Unix()
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).Unix()
+}
+
+// expiresAt converts a stored TTL back to a time, zero for items that don't expire.
+func expiresAt(ttl int64) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ttl, 0)
+}
+
+// expired reports whether an item with the given TTL must be hidden from the reads made with ctx.
+// DynamoDB deletes expired items up to a few days late, so reads can't rely on it.
+func expired(ctx context.Context, ttl int64) bool {
+	return ttl > 0 && ttl <= time.Now().Unix() && !repository.ExpiredIncluded(ctx)
+}
+
+// notExpiredFilter is a filter expression dropping the expired items server side, and its arguments.
+// Items without a TTL, or stored with a non-positive one by older versions, don't expire.
+func notExpiredFilter() (string, []any) {
+	return "attribute_not_exists($) OR $ <= ? OR $ > ?", []any{ttlAttribute, ttlAttribute, 0, ttlAttribute, time.Now().Unix()}
+}
+
+// filterExpired drops the expired items from the results of q, unless ctx includes them.
+func filterExpired(ctx context.Context, q *dynamo.Query) *dynamo.Query {
+	if repository.ExpiredIncluded(ctx) {
+		return q
+	}
+	expr, args := notExpiredFilter()
+	return q.Filter(expr, args...)
+}
+
+// expiringItem is an item read along with a profile, candidate for a sliding expiry.
+type expiringItem struct {
+	sk    string
+	ttl   int64
+	fixed bool // the TTL comes from an explicit expiry date
+	// typeTTL is the TTL of the item type the expiry is extended by.
+	typeTTL time.Duration
+}
+
+// slideExpiry extends the TTL of the items of a profile that was just read, when sliding expiry is enabled.
+// Items that don't expire or were written with an expiry date are left as they are, and TTLs are never shortened.
+// The items are updated in the background: reads don't wait for it, and a failed or skipped update is retried
+// by the next read. Updates are skipped while maxSlidingUpdates reads are already being slid.
+func (d *DB) slideExpiry(ctx context.Context, profileID string, items ...expiringItem) {
+	if d.sliding <= 0 {
+		return
+	}
+
+	now := time.Now()
+	var stale []expiringItem
+	for _, item := range items {
+		if item.ttl <= 0 || item.fixed || item.typeTTL <= 0 || item.ttl <= now.Unix() {
+			continue // doesn't expire, expires at a date set by the client, or already expired and not to be revived
+		}
+		// an item whose TTL was extended at t expires at t+typeTTL
+		extended := now.Add(item.typeTTL).Unix()
+		if extended-item.ttl < int64(d.sliding/time.Second) {
+			continue
+		}
+		item.ttl = extended
+		stale = append(stale, item)
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	select {
+	case d.slides <- struct{}{}:
+	default:
+		return // too many updates in flight, the next read slides the items
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-d.slides }()
+		for _, item := range stale {
+			_ = d.attempt(ctx, true, func(ctx context.Context) error {
+				return d.table.Update(partitionKey, buildPK(profileID)).
+					Range(sortKey, item.sk).
+					Set(ttlAttribute, item.ttl).
+					// don't recreate deleted items, shorten TTLs extended concurrently,
+					// nor slide the expiry date of items rewritten with one in the meantime
+					If("attribute_exists($) AND $ < ? AND attribute_not_exists($)",
+						partitionKey, ttlAttribute, item.ttl, fixedTTLAttribute).
+					Run(ctx)
+			})
+		}
+	}()
+}
@@ -0,0 +1,178 @@
+package ddb
+
+import (
+	"net/http"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiry(t *testing.T) {
+	explicit := time.Now().Add(time.Minute)
+	require.Equal(t, explicit.Unix(), expiry(explicit, time.Hour))
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), expiry(time.Time{}, time.Hour), 1)
+	require.Zero(t, expiry(time.Time{}, 0))
+
+	require.True(t, expiresAt(0).IsZero())
+	require.Equal(t, explicit.Unix(), expiresAt(explicit.Unix()).Unix())
+}
+
+func TestSlidingExpiryDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := testContext(t)
+
+	// profiles written with a short TTL, read once it's been raised
+	writer := NewDB(newTestTable(endpoint, table, &http.Client{}), WithUserTTL(time.Minute), WithSegmentTTL(0))
+	db := NewDB(newTestTable(endpoint, table, &http.Client{}),
+		WithUserTTL(24*time.Hour),
+		WithSegmentTTL(0),
+		WithSlidingExpiry(time.Hour),
+	)
+
+	sliding := model.Profile{
+		ID:   uuid.New(),
+		Tags: []string{"sports_fan"},
+		Segments: []model.Segment{{
+			Type:          model.MorningSegmentType,
+			TopCategories: []string{"sports"},
+			CreatedAt:     time.Now(),
+		}},
+	}
+	fixed := model.Profile{
+		ID:        uuid.New(),
+		Tags:      []string{"sports_fan"},
+		ExpiresAt: time.Now().Add(time.Minute), // kept on read
+	}
+	require.NoError(t, writer.UpsertProfile(ctx, sliding))
+	require.NoError(t, writer.UpsertProfile(ctx, fixed))
+
+	got, err := db.GetProfileByID(ctx, sliding.ID.String())
+	require.NoError(t, err)
+	require.InDelta(t, time.Now().Add(time.Minute).Unix(), got.ExpiresAt.Unix(), 5)
+	require.True(t, got.Segments[0].ExpiresAt.IsZero()) // segments don't expire
+	_, err = db.GetProfileByID(ctx, fixed.ID.String())
+	require.NoError(t, err)
+
+	extended := time.Now().Add(24 * time.Hour)
+	require.Eventually(t, func() bool {
+		got, err := db.GetProfileByID(ctx, sliding.ID.String())
+		return err == nil && got.ExpiresAt.Unix() >= extended.Unix()
+	}, 5*time.Second, 50*time.Millisecond)
+
+	got, err = db.GetProfileByID(ctx, fixed.ID.String())
+	require.NoError(t, err)
+	require.Equal(t, fixed.ExpiresAt.Unix(), got.ExpiresAt.Unix())
+}
+
+func TestExpiredItemsDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := testContext(t)
+	db := NewDB(newTestTable(endpoint, table, &http.Client{}))
+
+	past, now := time.Now().Add(-time.Hour), time.Now()
+	live := model.Profile{
+		ID:   uuid.New(),
+		Tags: []string{"sports_fan"},
+		Segments: []model.Segment{
+			{Type: model.MorningSegmentType, TopCategories: []string{"sports"}, CreatedAt: now.Add(-time.Minute)},
+			{Type: model.MorningSegmentType, TopCategories: []string{"world"}, CreatedAt: now, ExpiresAt: past},
+		},
+	}
+	expiredProfile := model.Profile{ID: uuid.New(), Tags: []string{"sports_fan"}, ExpiresAt: past}
+	require.NoError(t, db.UpsertProfile(ctx, live))
+	require.NoError(t, db.UpsertProfile(ctx, expiredProfile))
+
+	t.Run("Segments", func(t *testing.T) {
+		got, err := db.GetProfileByID(ctx, live.ID.String())
+		require.NoError(t, err)
+		require.Len(t, got.Segments, 1)
+
+		// the latest segment is expired, the previous one is returned
+		top, err := db.GetTopCategories(ctx, live.ID.String(), model.MorningSegmentType)
+		require.NoError(t, err)
+		require.Equal(t, []string{"sports"}, top)
+
+		_, err = db.GetSegment(ctx, live.ID.String(), model.MorningSegmentType, now)
+		require.ErrorIs(t, err, repository.ErrNoSegmentsFound)
+	})
+
+	t.Run("Profile", func(t *testing.T) {
+		_, err := db.GetProfileByID(ctx, expiredProfile.ID.String())
+		require.ErrorIs(t, err, repository.ErrNoProfileFound)
+		_, err = db.GetUserTags(ctx, expiredProfile.ID.String())
+		require.ErrorIs(t, err, repository.ErrNoProfileFound)
+	})
+
+	t.Run("Blob", func(t *testing.T) {
+		id := expiredProfile.ID.String()
+		require.NoError(t, db.UpsertBlob(ctx, id, []byte(`{"id":"`+id+`","tags":["sports_fan"]}`)))
+		err := db.table.Update(partitionKey, buildPK(id)).Range(sortKey, buildSK(blobItemKeyPrefix, id, nil)).
+			Set(ttlAttribute, past.Unix()).Run(ctx)
+		require.NoError(t, err)
+
+		_, err = db.GetBlob(ctx, id)
+		require.ErrorIs(t, err, repository.ErrNoProfileFound)
+		_, err = db.GetBlobPaths(ctx, id, []string{"tags"})
+		require.ErrorIs(t, err, repository.ErrNoProfileFound)
+		err = db.PatchBlob(ctx, id, []repository.PatchOperation{{Op: "add", Path: "/tags/-", Value: []byte(`"vip"`)}})
+		require.ErrorIs(t, err, repository.ErrNoProfileFound)
+	})
+
+	t.Run("IncludeExpired", func(t *testing.T) {
+		ctx := repository.IncludeExpired(ctx)
+		got, err := db.GetProfileByID(ctx, expiredProfile.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, past.Unix(), got.ExpiresAt.Unix())
+
+		got, err = db.GetProfileByID(ctx, live.ID.String())
+		require.NoError(t, err)
+		require.Len(t, got.Segments, 2)
+
+		_, err = db.GetBlob(ctx, expiredProfile.ID.String())
+		require.NoError(t, err)
+	})
+}
+
+func TestExplicitExpiriesDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := repository.ExplicitExpiries(testContext(t))
+	db := NewDB(newTestTable(endpoint, table, &http.Client{}), WithUserTTL(time.Hour), WithSegmentTTL(time.Hour), WithBlobTTL(time.Hour))
+
+	explicit := time.Now().Add(time.Minute)
+	profile := model.Profile{
+		ID:   uuid.New(),
+		Tags: []string{"sports_fan"},
+		Segments: []model.Segment{
+			{Type: model.MorningSegmentType, TopCategories: []string{"sports"}, CreatedAt: time.Now()},
+			{Type: model.EveningSegmentType, TopCategories: []string{"world"}, CreatedAt: time.Now(), ExpiresAt: explicit},
+		},
+	}
+	require.NoError(t, db.UpsertProfile(ctx, profile))
+	id := profile.ID.String()
+	require.NoError(t, db.UpsertBlob(ctx, id, []byte(`{"id":"`+id+`"}`)))
+
+	// the expiries derived from the TTLs aren't reported
+	got, err := db.GetProfileByID(ctx, id)
+	require.NoError(t, err)
+	require.True(t, got.ExpiresAt.IsZero())
+	require.Len(t, got.Segments, 2)
+	for _, segment := range got.Segments {
+		if segment.Type == model.EveningSegmentType {
+			require.Equal(t, explicit.Unix(), segment.ExpiresAt.Unix())
+		} else {
+			require.True(t, segment.ExpiresAt.IsZero())
+		}
+	}
+	expiresAt, err := db.BlobExpiresAt(ctx, id)
+	require.NoError(t, err)
+	require.True(t, expiresAt.IsZero())
+
+	require.NoError(t, db.UpsertBlob(ctx, id, []byte(`{"id":"`+id+`"}`), repository.WithBlobExpiresAt(explicit)))
+	expiresAt, err = db.BlobExpiresAt(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, explicit.Unix(), expiresAt.Unix())
+}
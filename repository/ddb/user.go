@@ -15,10 +15,11 @@ type user struct {
 	Tags      []string  `dynamo:"tags,set,omitempty"`
 	CreatedAt time.Time `dynamo:"created_at"`
 	UpdatedAt time.Time `dynamo:"updated_at"`
-	TTL       int64     `dynamo:"ttl,unixtime"`
+	TTL       int64     `dynamo:"ttl,omitempty"`       // expiry time, zero when the item doesn't expire
+	FixedTTL  bool      `dynamo:"ttl_fixed,omitempty"` // TTL set from an explicit expiry date, never slid
 }
 
-func toDBUser(p model.Profile) user {
+func toDBUser(p model.Profile, ttl time.Duration) user {
 	id := p.ID.String()
 
 	return user{
@@ -29,6 +30,7 @@ func toDBUser(p model.Profile) user {
 		Tags:      p.Tags,
 		CreatedAt: p.CreatedAt,
 		UpdatedAt: p.UpdatedAt,
-		TTL:       p.ExpiresAt.Unix(),
+		TTL:       expiry(p.ExpiresAt, ttl),
+		FixedTTL:  !p.ExpiresAt.IsZero(),
 	}
 }
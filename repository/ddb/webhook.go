@@ -0,0 +1,227 @@
+package ddb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"time"
+
+	"github.com/guregu/dynamo/v2"
+	"github.com/samber/lo"
+)
+
+var _ repository.WebhooksRepo = &DB{} // compile time check
+
+const (
+	webhookItemKeyPrefix    = "WEBHOOK"
+	deadLetterItemKeyPrefix = "DLQ"
+
+	// deadLetterTimeLayout sorts the dead letters by failure time: unlike RFC3339Nano, it keeps the trailing zeros.
+	deadLetterTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+	// deadLetterTTL is how long the dead letters are kept, for the clients to read their profiles again.
+	deadLetterTTL = 14 * 24 * time.Hour
+)
+
+// webhook is a subscription. The subscriptions share the WEBHOOK partition, so that they are listed with a single query.
+type webhook struct {
+	PK         string    `dynamo:"pk,hash"`  // partition key
+	SK         string    `dynamo:"sk,range"` // sort key
+	ItemType   string    `dynamo:"typ"`      // item type
+	ID         string    `dynamo:"id"`
+	URL        string    `dynamo:"url"`
+	EventTypes []string  `dynamo:"events,set"`
+	Tags       []string  `dynamo:"tags,set,omitempty"`
+	Secret     string    `dynamo:"secret"`
+	Owner      string    `dynamo:"owner,omitempty"`
+	CreatedAt  time.Time `dynamo:"created_at"`
+}
+
+// deadLetter is an undelivered event, in the WEBHOOK#{id} partition of its webhook under SK DLQ#{failed at}#{event id}.
+type deadLetter struct {
+	PK        string    `dynamo:"pk,hash"`  // partition key
+	SK        string    `dynamo:"sk,range"` // sort key
+	ItemType  string    `dynamo:"typ"`      // item type
+	WebhookID string    `dynamo:"webhook_id"`
+	EventID   string    `dynamo:"event_id"`
+	EventType string    `dynamo:"event_typ"`
+	ProfileID string    `dynamo:"profile_id"`
+	Attempts  int       `dynamo:"attempts"`
+	Status    int       `dynamo:"status,omitempty"`
+	Error     string    `dynamo:"error"`
+	FailedAt  time.Time `dynamo:"failed_at"`
+	TTL       int64     `dynamo:"ttl"`
+}
+
+func buildWebhookSK(id string) string {
+	return webhookItemKeyPrefix + keySeparator + id
+}
+
+func buildDeadLetterPK(webhookID string) string {
+	return webhookItemKeyPrefix + keySeparator + webhookID
+}
+
+func toDBWebhook(w model.Webhook) webhook {
+	return webhook{
+		PK:         webhookItemKeyPrefix,
+		SK:         buildWebhookSK(w.ID),
+		ItemType:   webhookItemKeyPrefix,
+		ID:         w.ID,
+		URL:        w.URL,
+		EventTypes: w.EventTypes,
+		Tags:       w.Tags,
+		Secret:     w.Secret,
+		Owner:      w.Owner,
+		CreatedAt:  w.CreatedAt,
+	}
+}
+
+func toCanonicalWebhook(w webhook) model.Webhook {
+	return model.Webhook{
+		ID:         w.ID,
+		URL:        w.URL,
+		EventTypes: w.EventTypes,
+		Tags:       w.Tags,
+		Secret:     w.Secret,
+		Owner:      w.Owner,
+		CreatedAt:  w.CreatedAt,
+	}
+}
+
+func (d *DB) CreateWebhook(ctx context.Context, w model.Webhook) error {
+	// not retried: a retry of a create that succeeded would conflict with it
+	return d.doOnce(ctx, func(ctx context.Context) error {
+		return d.table.Put(toDBWebhook(w)).If("attribute_not_exists($)", partitionKey).Run(ctx)
+	})
+}
+
+func (d *DB) GetWebhook(ctx context.Context, id string) (*model.Webhook, error) {
+	var w webhook
+	err := d.do(ctx, func(ctx context.Context) error {
+		return d.table.Get(partitionKey, webhookItemKeyPrefix).
+			Range(sortKey, dynamo.Equal, buildWebhookSK(id)).
+			One(ctx, &w)
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, repository.ErrNoWebhookFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hook := toCanonicalWebhook(w)
+	return &hook, nil
+}
+
+func (d *DB) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	var items []webhook
+	err := d.do(ctx, func(ctx context.Context) error {
+		items = items[:0]
+		return d.table.Get(partitionKey, webhookItemKeyPrefix).All(ctx, &items)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Map(items, func(w webhook, _ int) model.Webhook {
+		return toCanonicalWebhook(w)
+	}), nil
+}
+
+func (d *DB) DeleteWebhook(ctx context.Context, id string) error {
+	// not retried either: the retry of a delete that succeeded would find nothing to delete
+	err := d.doOnce(ctx, func(ctx context.Context) error {
+		return d.table.Delete(partitionKey, webhookItemKeyPrefix).
+			Range(sortKey, buildWebhookSK(id)).
+			If("attribute_exists($)", partitionKey).
+			Run(ctx)
+	})
+	if errors.Is(err, repository.ErrConflict) {
+		return repository.ErrNoWebhookFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var keys []dynamo.Keyed
+	err = d.do(ctx, func(ctx context.Context) error {
+		keys = keys[:0]
+		var items []struct {
+			PK string `dynamo:"pk"`
+			SK string `dynamo:"sk"`
+		}
+		if err := d.table.Get(partitionKey, buildDeadLetterPK(id)).Project(partitionKey, sortKey).All(ctx, &items); err != nil {
+			return err
+		}
+		for _, item := range items {
+			keys = append(keys, dynamo.Keys{item.PK, item.SK})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read dead letters: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	bw := d.table.Batch(partitionKey, sortKey).Write().Delete(keys...)
+	err = d.do(ctx, func(ctx context.Context) error {
+		_, err := bw.Run(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letters: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DB) PutDeadLetter(ctx context.Context, l model.DeadLetter) error {
+	item := deadLetter{
+		PK:        buildDeadLetterPK(l.WebhookID),
+		SK:        deadLetterItemKeyPrefix + keySeparator + l.FailedAt.UTC().Format(deadLetterTimeLayout) + keySeparator + l.EventID,
+		ItemType:  deadLetterItemKeyPrefix,
+		WebhookID: l.WebhookID,
+		EventID:   l.EventID,
+		EventType: l.EventType,
+		ProfileID: l.ProfileID,
+		Attempts:  l.Attempts,
+		Status:    l.Status,
+		Error:     l.Error,
+		FailedAt:  l.FailedAt,
+		TTL:       expiry(time.Time{}, deadLetterTTL),
+	}
+
+	return d.do(ctx, func(ctx context.Context) error {
+		return d.table.Put(item).Run(ctx)
+	})
+}
+
+func (d *DB) ListDeadLetters(ctx context.Context, webhookID string) ([]model.DeadLetter, error) {
+	var items []deadLetter
+	err := d.do(ctx, func(ctx context.Context) error {
+		items = items[:0]
+		q := d.table.Get(partitionKey, buildDeadLetterPK(webhookID)).
+			Range(sortKey, dynamo.BeginsWith, deadLetterItemKeyPrefix+keySeparator)
+		return filterExpired(ctx, q).All(ctx, &items)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.Map(items, func(l deadLetter, _ int) model.DeadLetter {
+		return model.DeadLetter{
+			WebhookID: l.WebhookID,
+			EventID:   l.EventID,
+			EventType: l.EventType,
+			ProfileID: l.ProfileID,
+			Attempts:  l.Attempts,
+			Status:    l.Status,
+			Error:     l.Error,
+			FailedAt:  l.FailedAt,
+			ExpiresAt: expiresAt(l.TTL),
+		}
+	}), nil
+}
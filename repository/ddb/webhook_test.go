@@ -0,0 +1,72 @@
+package ddb
+
+import (
+	"net/http"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooksDynamoDBLocal(t *testing.T) {
+	endpoint, table := startDynamoDB(t)
+	ctx := testContext(t)
+	db := NewDB(newTestTable(endpoint, table, &http.Client{}))
+
+	hook := model.Webhook{
+		ID:         uuid.NewString(),
+		URL:        "https://example.com/hook",
+		EventTypes: []string{"tags.changed"},
+		Tags:       []string{"sports_fan"},
+		Secret:     "secret",
+		Owner:      "ops",
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	require.NoError(t, db.CreateWebhook(ctx, hook))
+	require.ErrorIs(t, db.CreateWebhook(ctx, hook), repository.ErrConflict)
+
+	got, err := db.GetWebhook(ctx, hook.ID)
+	require.NoError(t, err)
+	require.Equal(t, hook.URL, got.URL)
+	require.Equal(t, hook.EventTypes, got.EventTypes)
+	require.Equal(t, hook.Tags, got.Tags)
+	require.Equal(t, hook.Secret, got.Secret)
+	require.Equal(t, hook.Owner, got.Owner)
+	require.True(t, hook.CreatedAt.Equal(got.CreatedAt))
+
+	hooks, err := db.ListWebhooks(ctx)
+	require.NoError(t, err)
+	require.Len(t, hooks, 1)
+
+	// listed by failure time
+	failedAt := time.Now()
+	for i, eventID := range []string{"seq-2", "seq-1"} {
+		require.NoError(t, db.PutDeadLetter(ctx, model.DeadLetter{
+			WebhookID: hook.ID,
+			EventID:   eventID,
+			EventType: "tags.changed",
+			Attempts:  5,
+			Status:    http.StatusServiceUnavailable,
+			Error:     "unexpected status 503",
+			FailedAt:  failedAt.Add(time.Duration(i) * time.Second),
+		}))
+	}
+	letters, err := db.ListDeadLetters(ctx, hook.ID)
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	require.Equal(t, "seq-2", letters[0].EventID)
+	require.Equal(t, "seq-1", letters[1].EventID)
+	require.WithinDuration(t, time.Now().Add(deadLetterTTL), letters[0].ExpiresAt, time.Minute)
+
+	// deleting the webhook deletes its dead letters
+	require.NoError(t, db.DeleteWebhook(ctx, hook.ID))
+	require.ErrorIs(t, db.DeleteWebhook(ctx, hook.ID), repository.ErrNoWebhookFound)
+	_, err = db.GetWebhook(ctx, hook.ID)
+	require.ErrorIs(t, err, repository.ErrNoWebhookFound)
+	letters, err = db.ListDeadLetters(ctx, hook.ID)
+	require.NoError(t, err)
+	require.Empty(t, letters)
+}
@@ -0,0 +1,37 @@
+package repository
+
+import "errors"
+
+// Error kinds. Every error returned by a repository for one of these reasons wraps the matching kind,
+// so callers can handle it with errors.Is whatever the implementation, e.g. errors.Is(err, ErrNotFound).
+var (
+	// ErrNotFound is returned when the requested item or partition doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a condition on the stored data isn't met.
+	ErrConflict = errors.New("conflict")
+	// ErrValidation is returned when the request can't be applied to the stored data.
+	ErrValidation = errors.New("validation failed")
+	// ErrThrottled is returned when the database rejects the request for exceeding its capacity.
+	ErrThrottled = errors.New("throttled")
+	// ErrUnavailable is returned when the database can't be reached or fails, the request may be retried.
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Error is an error of a given kind.
+type Error struct {
+	Kind error // one of the error kinds, e.g. ErrNotFound
+	Err  error
+}
+
+// NewError returns err tagged with kind.
+func NewError(kind, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
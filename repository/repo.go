@@ -2,33 +2,164 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"personalisation-poc/model"
 )
 
 var (
-	ErrNoSegmentsFound = errors.New("no segments found")
-	ErrNoProfileFound  = errors.New("no profile found")
+	ErrNoSegmentsFound = NewError(ErrNotFound, errors.New("no segments found"))
+	ErrNoProfileFound  = NewError(ErrNotFound, errors.New("no profile found"))
+	ErrNoWebhookFound  = NewError(ErrNotFound, errors.New("no webhook found"))
+	ErrOverloaded      = NewError(ErrUnavailable, errors.New("too many in-flight requests"))
+	ErrInvalidPath     = errors.New("invalid document path") // a malformed request rather than a validation failure
+	ErrInvalidPatch    = NewError(ErrValidation, errors.New("invalid patch"))
+	ErrPatchConflict   = NewError(ErrConflict, errors.New("patch precondition failed"))
 )
 
+// BlobEncoding is the storage format of a blob.
+type BlobEncoding string
+
+const (
+	// BlobEncodingMap stores the JSON document as a native DynamoDB map, which supports projections.
+	BlobEncodingMap BlobEncoding = "map"
+	// BlobEncodingGzip and BlobEncodingZstd store the compressed JSON document as binary,
+	// split across several items when it exceeds the DynamoDB item size limit.
+	BlobEncodingGzip BlobEncoding = "gzip"
+	BlobEncodingZstd BlobEncoding = "zstd"
+)
+
+// ParseBlobEncoding returns the BlobEncoding named s. An empty string selects BlobEncodingMap.
+func ParseBlobEncoding(s string) (BlobEncoding, error) {
+	switch enc := BlobEncoding(s); enc {
+	case "":
+		return BlobEncodingMap, nil
+	case BlobEncodingMap, BlobEncodingGzip, BlobEncodingZstd:
+		return enc, nil
+	default:
+		return "", fmt.Errorf("unknown blob encoding %q", s)
+	}
+}
+
+// BlobOptions are the per-write options of UpsertBlob and PatchBlob.
+type BlobOptions struct {
+	Encoding  BlobEncoding
+	Type      string
+	ExpiresAt time.Time
+}
+
+type BlobOption func(*BlobOptions)
+
+// WithBlobEncoding selects how the blob is stored. By default it's stored as a native map.
+func WithBlobEncoding(enc BlobEncoding) BlobOption {
+	return func(o *BlobOptions) {
+		o.Encoding = enc
+	}
+}
+
+// WithBlobExpiresAt expires the blob at t rather than after the TTL of blobs.
+func WithBlobExpiresAt(t time.Time) BlobOption {
+	return func(o *BlobOptions) {
+		o.ExpiresAt = t
+	}
+}
+
+// WithBlobType sets the type of the blob, stored with it by UpsertBlob, as blobs of every type share a key.
+// PatchBlob only patches blobs of this type. By default blobs have no type, i.e. they are profiles.
+func WithBlobType(blobType string) BlobOption {
+	return func(o *BlobOptions) {
+		o.Type = blobType
+	}
+}
+
+// PatchOperation is a JSON Patch (RFC 6902) operation.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Representation is one of the ways a profile is stored.
+type Representation string
+
+const (
+	// RepresentationProfile is the normalized profile: a USER item and a SEG item per segment.
+	RepresentationProfile Representation = "profile"
+	// RepresentationBlob is the whole profile document in a single BLOB item.
+	RepresentationBlob Representation = "blob"
+)
+
+// ParseRepresentation returns the Representation named s.
+func ParseRepresentation(s string) (Representation, error) {
+	switch rep := Representation(s); rep {
+	case RepresentationProfile, RepresentationBlob:
+		return rep, nil
+	default:
+		return "", fmt.Errorf("unknown representation %q", s)
+	}
+}
+
 type ProfilesRepo interface {
 	GetterProfileRepo
 	UpserterProfileRepo
+	DeleterProfileRepo
+	ScannerProfileRepo
 }
 
 type GetterProfileRepo interface {
 	GetProfileByID(ctx context.Context, id string) (*model.Profile, error)
+	// GetSegment returns the segment of the given type created at createdAt, or the most recent one when createdAt is zero.
 	GetSegment(ctx context.Context, profileID string, segmentType string, createdAt time.Time) (*model.Segment, error)
 	GetCategories(ctx context.Context, profileID string, segmentType string) ([]model.Category, error)
 	GetUserTags(ctx context.Context, profileID string) ([]string, error)
 	GetTopCategories(ctx context.Context, profileID string, segmentType string) ([]string, error)
 	GetBlob(ctx context.Context, profileID string) ([]byte, error)
 	GetRawSegmentsFromBlob(ctx context.Context, profileID string) ([]byte, error)
+	// GetBlobPaths returns the JSON sub-documents of the blob at paths such as "segments[0].top_categories",
+	// nested as in the blob.
+	GetBlobPaths(ctx context.Context, profileID string, paths []string) ([]byte, error)
+	// BlobExpiresAt returns when the blob of the profile expires, zero when it doesn't.
+	BlobExpiresAt(ctx context.Context, profileID string) (time.Time, error)
 }
 
 type UpserterProfileRepo interface {
 	UpsertProfile(ctx context.Context, profile model.Profile) error
-	UpsertBlob(ctx context.Context, profileID string, data []byte) error
+	UpsertBlob(ctx context.Context, profileID string, data []byte, opts ...BlobOption) error
+	// PatchBlob applies ops to the blob atomically. It fails with ErrPatchConflict when a path
+	// targeted by ops doesn't exist, a test operation fails or the blob is of another type,
+	// leaving the blob unchanged, and with ErrNoProfileFound when there's no blob or it's expired.
+	PatchBlob(ctx context.Context, profileID string, ops []PatchOperation, opts ...BlobOption) error
+}
+
+type DeleterProfileRepo interface {
+	// DeleteProfile deletes every item of the profile, in both representations, expired ones included.
+	// It fails with ErrNoProfileFound when the profile has no items.
+	DeleteProfile(ctx context.Context, id string) error
+}
+
+type ScannerProfileRepo interface {
+	// ScanProfileIDs calls fn with the ID of every profile stored as rep, stopping at the first error.
+	// Blobs of other types than profiles aren't scanned.
+	ScanProfileIDs(ctx context.Context, rep Representation, fn func(id string) error) error
+	// ScanBlobIDs calls fn with the profile ID of every blob of blobType, empty for profiles, stopping at the first error.
+	ScanBlobIDs(ctx context.Context, blobType string, fn func(id string) error) error
+}
+
+// WebhooksRepo stores the webhook subscriptions, and the events that couldn't be delivered to them.
+type WebhooksRepo interface {
+	// CreateWebhook fails with ErrConflict when a webhook with the same ID exists.
+	CreateWebhook(ctx context.Context, webhook model.Webhook) error
+	// GetWebhook fails with ErrNoWebhookFound when the webhook doesn't exist.
+	GetWebhook(ctx context.Context, id string) (*model.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	// DeleteWebhook deletes a webhook and its dead letters. It fails with ErrNoWebhookFound when the webhook doesn't exist.
+	DeleteWebhook(ctx context.Context, id string) error
+	// PutDeadLetter records an event that couldn't be delivered. Dead letters expire after a while.
+	PutDeadLetter(ctx context.Context, letter model.DeadLetter) error
+	// ListDeadLetters returns the dead letters of a webhook, oldest first.
+	ListDeadLetters(ctx context.Context, webhookID string) ([]model.DeadLetter, error)
 }
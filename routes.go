@@ -13,11 +13,23 @@ const (
 	segmentPath       = "/profile/{id}/segment/{segmentType}"
 	categoriesPath    = "/profile/{id}/segment/{segmentType}/categories"
 	topCategoriesPath = "/profile/{id}/segment/{segmentType}/topcategories"
+	convertJobPath    = "/admin/convert/{direction}"
+	convertPath       = "/admin/convert/{direction}/{id}"
+	jobsPath          = "/admin/jobs"
+	jobPath           = "/admin/jobs/{jobID}"
+	consistencyPath   = "/admin/consistency"
+	checkProfilePath  = "/admin/consistency/{id}"
+	adminProfilePath  = "/admin/profile/{id}"
+	adminBlobPath     = "/admin/blob/{id}"
+	webhooksPath      = "/webhooks"
+	webhookPath       = "/webhooks/{id}"
+	deadLettersPath   = "/webhooks/{id}/dead-letters"
 )
 
 func (s *server) setupRoutes() {
 	s.router.HandleFunc(fmt.Sprintf("PUT %s%s", apiBasePath, profileCreatePath), handleUpsertProfile(s.db, s.log))
-	s.router.HandleFunc(fmt.Sprintf("PUT %s%s", apiBasePath, blobCreatePath), handleUpsertBlob(s.db, s.log))
+	s.router.HandleFunc(fmt.Sprintf("PUT %s%s", apiBasePath, blobCreatePath), handleUpsertBlob(s.db, s.schemas, s.log))
+	s.router.HandleFunc(fmt.Sprintf("PATCH %s%s", apiBasePath, blobPath), handlePatchBlob(s.db, s.schemas, s.log))
 	s.router.HandleFunc(fmt.Sprintf("GET %s%s", apiBasePath, profilePath), handleGetProfile(s.db, s.log))
 	s.router.HandleFunc(fmt.Sprintf("GET %s%s", apiBasePath, segmentPath), handleGetSegment(s.db, s.log))
 	s.router.HandleFunc(fmt.Sprintf("GET %s%s", apiBasePath, categoriesPath), handleGetCategories(s.db, s.log))
@@ -25,4 +37,25 @@ func (s *server) setupRoutes() {
 	s.router.HandleFunc(fmt.Sprintf("GET %s%s", apiBasePath, topCategoriesPath), handleGetTopCategories(s.db, s.log))
 	s.router.HandleFunc(fmt.Sprintf("GET %s%s", apiBasePath, blobPath), handleGetBlob(s.db, s.log))
 	s.router.HandleFunc(fmt.Sprintf("GET %s%s", apiBasePath, blobSegmentsPath), handleGetSegmentsFromBlob(s.db, s.log))
+
+	if s.admin != nil {
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, adminProfilePath), s.admin(withExpired(handleGetProfile(s.db, s.log))))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, adminBlobPath), s.admin(withExpired(handleGetBlob(s.db, s.log))))
+		s.router.Handle(fmt.Sprintf("POST %s%s", apiBasePath, convertPath), s.admin(handleConvertProfile(s.converter, s.log)))
+		s.router.Handle(fmt.Sprintf("POST %s%s", apiBasePath, convertJobPath), s.admin(handleStartConvertJob(s.converter, s.log)))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, jobsPath), s.admin(handleListJobs(s.converter)))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, jobPath), s.admin(handleGetJob(s.converter, s.log)))
+		s.router.Handle(fmt.Sprintf("DELETE %s%s", apiBasePath, jobPath), s.admin(handleCancelJob(s.converter, s.log)))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, consistencyPath), s.admin(handleCheckAll(s.checker, s.log)))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, checkProfilePath), s.admin(handleCheckProfile(s.checker, s.log)))
+	}
+
+	// the webhooks belong to the admin client that created them
+	if s.webhooks != nil && s.admin != nil {
+		s.router.Handle(fmt.Sprintf("POST %s%s", apiBasePath, webhooksPath), s.admin(handleCreateWebhook(s.webhooks, s.log)))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, webhooksPath), s.admin(handleListWebhooks(s.webhooks, s.log)))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, webhookPath), s.admin(handleGetWebhook(s.webhooks, s.log)))
+		s.router.Handle(fmt.Sprintf("DELETE %s%s", apiBasePath, webhookPath), s.admin(handleDeleteWebhook(s.webhooks, s.log)))
+		s.router.Handle(fmt.Sprintf("GET %s%s", apiBasePath, deadLettersPath), s.admin(handleListDeadLetters(s.webhooks, s.log)))
+	}
 }
@@ -0,0 +1,179 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"personalisation-poc/repository"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// listIndexToken matches the JSON Pointer tokens addressing list elements, "-" being the end of a list.
+var listIndexToken = regexp.MustCompile(`^(0|[1-9][0-9]*|-)$`)
+
+// ValidatePatch checks that ops only write paths declared by the schema of blobType, with values matching
+// the schema of their path, so that patches can't store fields that PUT would reject. The id field is the key
+// of the blob and can't be written. It returns the operations to apply, without unknown fields in their values
+// when stripping is enabled.
+//
+// Values moved or copied aren't known, so move and copy are only accepted between paths sharing a schema.
+// Each value is checked on its own, so the constraints spanning several fields of a document, such as minItems
+// or dependentRequired, aren't enforced on patches.
+func (r *Registry) ValidatePatch(blobType string, ops []repository.PatchOperation) ([]repository.PatchOperation, error) {
+	if blobType == "" {
+		blobType = DefaultType
+	}
+	sch, ok := r.schemas[blobType]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, blobType)
+	}
+
+	out := make([]repository.PatchOperation, 0, len(ops))
+	for i, op := range ops {
+		invalid := func(format string, args ...any) error {
+			return fmt.Errorf("%w: operation %d: %s", ErrInvalid, i, fmt.Sprintf(format, args...))
+		}
+
+		target, err := resolve(sch, op.Path)
+		if err != nil {
+			return nil, invalid("%v", err)
+		}
+		if op.Op != "test" && isID(op.Path) {
+			return nil, invalid("id can't be patched")
+		}
+
+		switch op.Op {
+		case "add", "replace":
+			if op.Value == nil {
+				break // rejected by the repository
+			}
+			value, err := jsonschema.UnmarshalJSON(bytes.NewReader(op.Value))
+			if err != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrMalformed, i, err)
+			}
+			if r.strip {
+				value = strip(value, target.schema)
+				if op.Value, err = json.Marshal(value); err != nil {
+					return nil, err
+				}
+			}
+			if target.schema != nil {
+				if err := target.schema.Validate(value); err != nil {
+					return nil, invalid("%v", err)
+				}
+			}
+		case "remove":
+			if target.required {
+				return nil, invalid("%s is required", op.Path)
+			}
+		case "move", "copy":
+			source, err := resolve(sch, op.From)
+			if err != nil {
+				return nil, invalid("%v", err)
+			}
+			if op.Op == "move" && (source.required || isID(op.From)) {
+				return nil, invalid("%s can't be removed", op.From)
+			}
+			if !sameSchema(source.schema, target.schema) {
+				return nil, invalid("%s and %s have different schemas", op.From, op.Path)
+			}
+		}
+		out = append(out, op)
+	}
+
+	return out, nil
+}
+
+// pathSchema is the schema of a path within a document.
+type pathSchema struct {
+	schema   *jsonschema.Schema // nil when the path isn't constrained
+	required bool               // the path is a property required by its parent
+}
+
+// resolve returns the schema of the path addressed by the JSON Pointer ptr, failing when the schema doesn't
+// declare it. Objects without declared properties and lists without declared items accept any path, like strip.
+func resolve(sch *jsonschema.Schema, ptr string) (pathSchema, error) {
+	if !strings.HasPrefix(ptr, "/") {
+		return pathSchema{}, fmt.Errorf("pointer %q must start with /", ptr)
+	}
+
+	target := pathSchema{schema: sch}
+	for _, token := range strings.Split(ptr[1:], "/") {
+		parent := deref(target.schema)
+		if parent == nil {
+			return pathSchema{}, nil
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+
+		var ok bool
+		if listIndexToken.MatchString(token) {
+			target, ok = itemSchema(parent, token)
+		} else {
+			target, ok = propertySchema(parent, token)
+		}
+		if !ok {
+			return pathSchema{}, fmt.Errorf("%s is not declared by the schema", ptr)
+		}
+	}
+
+	return target, nil
+}
+
+// deref follows the reference of sch when it declares nothing else about its properties and items.
+func deref(sch *jsonschema.Schema) *jsonschema.Schema {
+	for sch != nil && sch.Ref != nil && len(sch.Properties) == 0 && len(sch.PatternProperties) == 0 &&
+		sch.Items == nil && sch.Items2020 == nil && len(sch.PrefixItems) == 0 {
+		sch = sch.Ref
+	}
+	return sch
+}
+
+func propertySchema(sch *jsonschema.Schema, key string) (pathSchema, bool) {
+	if prop, ok := sch.Properties[key]; ok {
+		return pathSchema{schema: prop, required: slices.Contains(sch.Required, key)}, true
+	}
+	for re, prop := range sch.PatternProperties {
+		if re.MatchString(key) {
+			return pathSchema{schema: prop}, true
+		}
+	}
+	switch additional := sch.AdditionalProperties.(type) {
+	case *jsonschema.Schema:
+		return pathSchema{schema: additional}, true
+	case bool:
+		if !additional {
+			return pathSchema{}, false
+		}
+	}
+	return pathSchema{}, len(sch.Properties) == 0 && len(sch.PatternProperties) == 0
+}
+
+func itemSchema(sch *jsonschema.Schema, token string) (pathSchema, bool) {
+	if n, err := strconv.Atoi(token); err == nil && n < len(sch.PrefixItems) {
+		return pathSchema{schema: sch.PrefixItems[n]}, true
+	}
+	if sch.Items2020 != nil {
+		return pathSchema{schema: sch.Items2020}, true
+	}
+	if items, ok := sch.Items.(*jsonschema.Schema); ok {
+		return pathSchema{schema: items}, true
+	}
+	// a list without declared items, or a map key made of digits
+	return propertySchema(sch, token)
+}
+
+// sameSchema reports whether a and b are the same schema, such as the schemas of the elements of a list.
+// Equivalent schemas declared at different locations are told apart, as the values moved aren't known.
+func sameSchema(a, b *jsonschema.Schema) bool {
+	return deref(a) == deref(b)
+}
+
+// isID reports whether the JSON Pointer ptr addresses the id field or a path within it.
+func isID(ptr string) bool {
+	return ptr == "/id" || strings.HasPrefix(ptr, "/id/")
+}
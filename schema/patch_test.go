@@ -0,0 +1,57 @@
+package schema
+
+import (
+	"encoding/json"
+	"personalisation-poc/repository"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePatch(t *testing.T) {
+	r, err := New()
+	require.NoError(t, err)
+
+	patch := `[
+		{"op": "test", "path": "/id", "value": "x"},
+		{"op": "add", "path": "/tags/-", "value": "vip"},
+		{"op": "replace", "path": "/segments/0/categories/1/score", "value": 0.5},
+		{"op": "add", "path": "/segments/-", "value": {"type": "interests"}},
+		{"op": "remove", "path": "/segments/0/top_categories"},
+		{"op": "copy", "from": "/segments/0/categories/0", "path": "/segments/1/categories/-"}
+	]`
+	var ops []repository.PatchOperation
+	require.NoError(t, json.Unmarshal([]byte(patch), &ops))
+	out, err := r.ValidatePatch("", ops)
+	require.NoError(t, err)
+	require.Equal(t, ops, out)
+
+	for name, patch := range map[string]string{
+		"replace id":       `[{"op": "replace", "path": "/id", "value": "00000000-0000-0000-0000-000000000001"}]`,
+		"move id":          `[{"op": "move", "from": "/id", "path": "/segments/0/type"}]`,
+		"undeclared":       `[{"op": "add", "path": "/password", "value": "hunter2"}]`,
+		"undeclared deep":  `[{"op": "add", "path": "/segments/0/internal", "value": 1}]`,
+		"wrong type":       `[{"op": "replace", "path": "/segments/0/categories/0/score", "value": "high"}]`,
+		"invalid item":     `[{"op": "add", "path": "/segments/-", "value": {"categories": []}}]`,
+		"remove required":  `[{"op": "remove", "path": "/segments/0/type"}]`,
+		"different schema": `[{"op": "copy", "from": "/tags", "path": "/segments"}]`,
+	} {
+		var ops []repository.PatchOperation
+		require.NoError(t, json.Unmarshal([]byte(patch), &ops), name)
+		_, err := r.ValidatePatch(DefaultType, ops)
+		require.ErrorIs(t, err, ErrInvalid, name)
+	}
+
+	_, err = r.ValidatePatch("order", ops)
+	require.ErrorIs(t, err, ErrUnknownType)
+}
+
+func TestValidatePatchStripUnknown(t *testing.T) {
+	r, err := New(WithStripUnknown(true))
+	require.NoError(t, err)
+
+	ops := []repository.PatchOperation{{Op: "add", Path: "/segments/-", Value: json.RawMessage(`{"type": "x", "internal": 1}`)}}
+	out, err := r.ValidatePatch(DefaultType, ops)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type": "x"}`, string(out[0].Value))
+}
@@ -0,0 +1,201 @@
+// Package schema validates blob documents against JSON Schemas, one per blob type.
+package schema
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// DefaultType is the blob type of documents stored without an explicit type: a model.Profile.
+const DefaultType = "profile"
+
+// schemaFileSuffix is the suffix of the schema files loaded from the schema directory.
+const schemaFileSuffix = ".schema.json"
+
+var (
+	ErrMalformed   = errors.New("malformed document")
+	ErrInvalid     = errors.New("document does not match the schema")
+	ErrUnknownType = errors.New("unknown blob type")
+)
+
+//go:embed profile.schema.json
+var profileSchema []byte
+
+var typeName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+type Option func(*Registry)
+
+// WithDir loads a schema per blob type from the {type}.schema.json files of dir.
+// A profile.schema.json file replaces the built-in profile schema.
+func WithDir(dir string) Option {
+	return func(r *Registry) {
+		r.dir = dir
+	}
+}
+
+// WithStripUnknown removes the fields not declared by the schema before validating documents,
+// instead of rejecting them when the schema disallows additional properties.
+func WithStripUnknown(strip bool) Option {
+	return func(r *Registry) {
+		r.strip = strip
+	}
+}
+
+// Registry holds the compiled schemas of the blob types.
+type Registry struct {
+	dir     string
+	strip   bool
+	schemas map[string]*jsonschema.Schema
+}
+
+// New compiles the built-in profile schema and the schemas of the optional schema directory.
+func New(opts ...Option) (*Registry, error) {
+	r := &Registry{schemas: make(map[string]*jsonschema.Schema)}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	sources := map[string][]byte{DefaultType: profileSchema}
+	if r.dir != "" {
+		files, err := filepath.Glob(filepath.Join(r.dir, "*"+schemaFileSuffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), schemaFileSuffix)
+			if !typeName.MatchString(name) {
+				return nil, fmt.Errorf("invalid blob type name %q in %s", name, file)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			sources[name] = data
+		}
+	}
+
+	c := jsonschema.NewCompiler()
+	c.AssertFormat()
+	for name, data := range sources {
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s schema: %w", name, err)
+		}
+		if err := c.AddResource(name+schemaFileSuffix, doc); err != nil {
+			return nil, fmt.Errorf("failed to add %s schema: %w", name, err)
+		}
+	}
+	for name := range sources {
+		sch, err := c.Compile(name + schemaFileSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile %s schema: %w", name, err)
+		}
+		r.schemas[name] = sch
+	}
+
+	return r, nil
+}
+
+// Default returns a Registry with the built-in profile schema only.
+func Default() *Registry {
+	r, err := New()
+	if err != nil {
+		panic(err) // the built-in schema is compiled by the tests
+	}
+	return r
+}
+
+// Validate checks that data is a valid document of blobType with a non-zero "id" field.
+// It returns the ID and the document to store, without unknown fields when stripping is enabled.
+func (r *Registry) Validate(blobType string, data []byte) (uuid.UUID, []byte, error) {
+	if blobType == "" {
+		blobType = DefaultType
+	}
+	sch, ok := r.schemas[blobType]
+	if !ok {
+		return uuid.Nil, nil, fmt.Errorf("%w %q", ErrUnknownType, blobType)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if r.strip {
+		doc = strip(doc, sch)
+		if data, err = json.Marshal(doc); err != nil {
+			return uuid.Nil, nil, err
+		}
+	}
+	if err := sch.Validate(doc); err != nil {
+		return uuid.Nil, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	// user-supplied schemas may not require the ID, which is the key of the blob
+	obj, _ := doc.(map[string]any)
+	rawID, _ := obj["id"].(string)
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("%w: id must be a UUID", ErrInvalid)
+	}
+	if id == uuid.Nil {
+		return uuid.Nil, nil, fmt.Errorf("%w: id must not be the zero UUID", ErrInvalid)
+	}
+
+	return id, data, nil
+}
+
+// strip removes from v the object fields that sch doesn't declare, following properties and items.
+// Objects without declared properties are kept as is.
+func strip(v any, sch *jsonschema.Schema) any {
+	if sch == nil {
+		return v
+	}
+	if sch.Ref != nil {
+		v = strip(v, sch.Ref)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		if len(sch.Properties) == 0 && len(sch.PatternProperties) == 0 {
+			return v
+		}
+		for key, value := range v {
+			if prop, ok := sch.Properties[key]; ok {
+				v[key] = strip(value, prop)
+				continue
+			}
+			if !matchesPattern(sch, key) {
+				delete(v, key)
+			}
+		}
+	case []any:
+		items := sch.Items2020
+		if items == nil {
+			items, _ = sch.Items.(*jsonschema.Schema)
+		}
+		for i := range v {
+			v[i] = strip(v[i], items)
+		}
+	}
+
+	return v
+}
+
+func matchesPattern(sch *jsonschema.Schema, key string) bool {
+	for re := range sch.PatternProperties {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
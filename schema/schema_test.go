@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"personalisation-poc/model"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateProfile(t *testing.T) {
+	r, err := New()
+	require.NoError(t, err)
+
+	profile := model.Profile{
+		ID:        uuid.New(),
+		Tags:      []string{"sports_fan"},
+		CreatedAt: time.Now(),
+		Segments: []model.Segment{{
+			Type:       "interests",
+			Categories: []model.Category{{ID: "finance", Score: 0.8}},
+		}},
+	}
+	data, err := json.Marshal(profile)
+	require.NoError(t, err)
+
+	id, out, err := r.Validate("", data)
+	require.NoError(t, err)
+	require.Equal(t, profile.ID, id)
+	require.Equal(t, data, out)
+
+	for name, doc := range map[string]string{
+		"zero id":       `{"id": "00000000-0000-0000-0000-000000000000"}`,
+		"missing id":    `{"tags": []}`,
+		"unknown field": `{"id": "` + uuid.NewString() + `", "password": "hunter2"}`,
+		"wrong type":    `{"id": "` + uuid.NewString() + `", "segments": [{"type": "x", "categories": [{"id": "a", "score": "high"}]}]}`,
+	} {
+		_, _, err := r.Validate(DefaultType, []byte(doc))
+		require.ErrorIs(t, err, ErrInvalid, name)
+	}
+
+	_, _, err = r.Validate(DefaultType, []byte(`{"id":`))
+	require.ErrorIs(t, err, ErrMalformed)
+	_, _, err = r.Validate("order", data)
+	require.ErrorIs(t, err, ErrUnknownType)
+}
+
+func TestValidateStripUnknown(t *testing.T) {
+	r, err := New(WithStripUnknown(true))
+	require.NoError(t, err)
+
+	id := uuid.NewString()
+	doc := `{"id": "` + id + `", "debug": true, "segments": [{"type": "x", "internal": 1, "categories": [{"id": "a", "score": 1, "raw": {}}]}]}`
+	_, out, err := r.Validate(DefaultType, []byte(doc))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id": "`+id+`", "segments": [{"type": "x", "categories": [{"id": "a", "score": 1}]}]}`, string(out))
+}
+
+func TestValidateSchemaDir(t *testing.T) {
+	dir := t.TempDir()
+	orderSchema := `{
+		"type": "object",
+		"required": ["id", "total"],
+		"properties": {"id": {"type": "string"}, "total": {"type": "number", "minimum": 0}}
+	}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "order.schema.json"), []byte(orderSchema), 0o600))
+
+	r, err := New(WithDir(dir))
+	require.NoError(t, err)
+
+	_, _, err = r.Validate("order", []byte(`{"id": "`+uuid.NewString()+`", "total": 12.5}`))
+	require.NoError(t, err)
+	_, _, err = r.Validate("order", []byte(`{"id": "`+uuid.NewString()+`", "total": -1}`))
+	require.ErrorIs(t, err, ErrInvalid)
+	// the ID is checked even when the schema doesn't require a UUID
+	_, _, err = r.Validate("order", []byte(`{"id": "order-1", "total": 1}`))
+	require.ErrorIs(t, err, ErrInvalid)
+}
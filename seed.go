@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"personalisation-poc/model"
+	"strings"
+	"time"
+)
+
+// runSeed generates fake profiles and writes them through the repository, printing their IDs one per line,
+// or prints them as NDJSON, in the format of export and import.
+func runSeed(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	n := fs.Int("n", 10, "number of profiles to generate")
+	seed := fs.Int64("seed", 0, "seed of the generator, for reproducible datasets (random when 0)")
+	now := fs.String("now", "", "RFC 3339 time the profiles are generated at, for reproducible datasets (current time when empty)")
+	segmentTypes := fs.String("segment-types", model.MorningSegmentType+","+model.EveningSegmentType, "comma-separated segment types of each profile")
+	categories := fs.String("categories", strings.Join(model.DefaultCategories(), ","), "comma-separated category vocabulary")
+	minCategories := fs.Int("min-categories", 1, "minimum number of categories per segment")
+	maxCategories := fs.Int("max-categories", 0, "maximum number of categories per segment (the whole vocabulary when 0)")
+	scores := fs.String("scores", string(model.ScoresUniform), "distribution of the category scores: uniform, normal or skewed")
+	history := fs.Int("history", 1, "number of versions of each segment type")
+	historyInterval := fs.Duration("history-interval", 24*time.Hour, "time between two versions of a segment")
+	expiresIn := fs.Duration("expires-in", 0, "expiry of the profiles and segments after their creation (the configured TTLs when 0)")
+	emit := fs.Bool("ndjson", false, "print the profiles as NDJSON instead of writing them")
+	blob := fs.Bool("blob", false, "also write the profiles as blobs (ignored with -ndjson)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *n <= 0 {
+		return errors.New("-n must be positive")
+	}
+	if *minCategories <= 0 || (*maxCategories > 0 && *maxCategories < *minCategories) {
+		return errors.New("-min-categories must be positive and at most -max-categories")
+	}
+	if *history <= 0 {
+		return errors.New("-history must be positive")
+	}
+	distribution, err := model.ParseScoreDistribution(*scores)
+	if err != nil {
+		return err
+	}
+
+	opts := []model.GeneratorOption{
+		model.WithSegmentTypes(splitList(*segmentTypes)...),
+		model.WithCategories(splitList(*categories)...),
+		model.WithCategoriesPerSegment(*minCategories, *maxCategories),
+		model.WithScoreDistribution(distribution),
+		model.WithHistory(*history, *historyInterval),
+		model.WithExpiry(*expiresIn),
+	}
+	if *seed != 0 {
+		opts = append(opts, model.WithSeed(*seed))
+	}
+	if *now != "" {
+		t, err := time.Parse(time.RFC3339, *now)
+		if err != nil {
+			return fmt.Errorf("invalid -now: %w", err)
+		}
+		opts = append(opts, model.WithNow(t))
+	}
+	gen := model.NewGenerator(opts...)
+
+	if *emit {
+		enc := json.NewEncoder(out)
+		for range *n {
+			if err := enc.Encode(gen.Profile()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	repo, err := newRepo(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	for range *n {
+		profile := gen.Profile()
+		if err := repo.UpsertProfile(ctx, *profile); err != nil {
+			return err
+		}
+		if *blob {
+			data, err := json.Marshal(profile)
+			if err != nil {
+				return err
+			}
+			if err := repo.UpsertBlob(ctx, profile.ID.String(), data); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(out, profile.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping the empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
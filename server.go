@@ -4,21 +4,93 @@ import (
 	"log/slog"
 	"net/http"
 	"personalisation-poc/repository"
+	"personalisation-poc/repository/consistency"
+	"personalisation-poc/repository/convert"
+	"personalisation-poc/repository/ddb"
+	"personalisation-poc/schema"
 )
 
 type server struct {
-	router *http.ServeMux
-	db     repository.ProfilesRepo
-	log    *slog.Logger
+	router  *http.ServeMux
+	handler http.Handler
+	db      repository.ProfilesRepo
+	log     *slog.Logger
+	limiter *rateLimiter
+	schemas *schema.Registry
+	// admin only lets the admin clients through, nil when the admin endpoints aren't served
+	admin func(http.Handler) http.Handler
+	// converter runs the conversions between the profile and blob representations
+	converter *convert.Converter
+	// checker compares both representations of the profiles
+	checker *consistency.Checker
+	// webhooks stores the webhook subscriptions, their endpoints are only served when it's set
+	webhooks repository.WebhooksRepo
 }
 
-func newServer(db repository.ProfilesRepo, log *slog.Logger) *server {
+type serverOption func(*server)
+
+// withRateLimit enables per-client rate limiting.
+func withRateLimit(conf RateLimitConfig) serverOption {
+	return func(s *server) {
+		if conf.Enabled {
+			s.limiter = newRateLimiter(conf)
+		}
+	}
+}
+
+// withBlobSchemas validates blobs against the schemas of registry instead of the built-in profile schema.
+func withBlobSchemas(registry *schema.Registry) serverOption {
+	return func(s *server) {
+		s.schemas = registry
+	}
+}
+
+// withAdmin serves the admin endpoints to the clients of conf, backed by the table itself rather than a cache.
+func withAdmin(db *ddb.DB, conf AdminConfig) serverOption {
+	return func(s *server) {
+		s.admin = adminAuth(conf.APIKeys, s.log)
+		s.converter = convert.New(db, convert.WithRate(conf.ConvertRPS))
+		s.checker = consistency.New(db)
+	}
+}
+
+// withWebhooks serves the webhook subscription endpoints to the admin clients of withAdmin, backed by repo.
+func withWebhooks(repo repository.WebhooksRepo) serverOption {
+	return func(s *server) {
+		s.webhooks = repo
+	}
+}
+
+func newServer(db repository.ProfilesRepo, log *slog.Logger, opts ...serverOption) *server {
 	s := &server{
 		router: http.NewServeMux(),
 		db:     db,
-		log:    log,
+		log:    slog.New(newContextHandler(log.Handler())),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.schemas == nil {
+		s.schemas = schema.Default()
 	}
 	s.setupRoutes()
+	s.setupMiddleware()
 
 	return s
 }
+
+// setupMiddleware wraps the router, innermost first.
+func (s *server) setupMiddleware() {
+	s.handler = s.router
+	if s.limiter != nil {
+		s.handler = rateLimit(s.limiter, s.log)(s.handler)
+	}
+	s.handler = accessLog(s.log)(s.handler)
+	// outside of the access log, which logs the capacity
+	s.handler = consumedCapacity(s.handler)
+	s.handler = requestID(s.handler)
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
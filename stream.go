@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/signal"
+	"personalisation-poc/repository/ddb"
+	"personalisation-poc/stream"
+	"personalisation-poc/webhook"
+	"syscall"
+	"time"
+)
+
+// runStream reads the stream of the table and sends the events of the changes to the profiles to the sinks, until interrupted.
+func runStream(conf *Config, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("stream", flag.ContinueOnError)
+	from := fs.String("from", string(stream.StartLatest), "where to start reading the stream: latest or trim-horizon")
+	poll := fs.Duration("poll", time.Second, "how long to wait before polling a shard without new records again")
+	sinkNames := fs.String("sinks", "stdout", "comma separated sinks of the events: stdout (as NDJSON), log or webhooks")
+	attempts := fs.Int("webhook-attempts", 5, "number of attempts of a webhook delivery before it's dead-lettered")
+	timeout := fs.Duration("webhook-timeout", 10*time.Second, "timeout of a webhook delivery attempt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	start, err := stream.ParseStartingPosition(*from)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		sinks      []stream.Sink
+		dispatcher *webhook.Dispatcher
+	)
+	for _, name := range splitList(*sinkNames) {
+		switch name {
+		case "stdout":
+			sinks = append(sinks, stream.NewWriterSink(out))
+		case "log":
+			sinks = append(sinks, stream.NewLogSink(slog.Default()))
+		case "webhooks":
+			repo, err := newRepo(ctx, conf)
+			if err != nil {
+				return err
+			}
+			dispatcher = webhook.NewDispatcher(repo,
+				webhook.WithMaxAttempts(*attempts),
+				webhook.WithHTTPClient(webhook.NewClient(*timeout)),
+				webhook.WithLogger(slog.Default()),
+			)
+			sinks = append(sinks, dispatcher)
+		default:
+			return fmt.Errorf("unknown sink %q, expected stdout, log or webhooks", name)
+		}
+	}
+
+	db, err := newDynamoDB(ctx, conf)
+	if err != nil {
+		return err
+	}
+	arn, err := ddb.StreamARN(ctx, db, conf.TableName)
+	if err != nil {
+		return err
+	}
+	streams, err := newDynamoDBStreams(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("reading stream", "stream_arn", arn, "from", start)
+	err = stream.NewProcessor(streams, arn,
+		stream.WithSinks(sinks...),
+		stream.WithStartingPosition(start),
+		stream.WithPollInterval(*poll),
+	).Run(ctx)
+	if dispatcher != nil {
+		// the deliveries in flight are dead-lettered once interrupted
+		dispatcher.Wait()
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"fmt"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/repository/ddb"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// EventType is the type of an event, as named in its JSON encoding.
+type EventType string
+
+const (
+	ProfileCreatedEvent EventType = "profile.created"
+	SegmentUpdatedEvent EventType = "segment.updated"
+	TagsChangedEvent    EventType = "tags.changed"
+	ProfileDeletedEvent EventType = "profile.deleted"
+)
+
+// EventTypes are all the event types.
+var EventTypes = []EventType{ProfileCreatedEvent, SegmentUpdatedEvent, TagsChangedEvent, ProfileDeletedEvent}
+
+// ParseEventType returns the EventType named s.
+func ParseEventType(s string) (EventType, error) {
+	if t := EventType(s); slices.Contains(EventTypes, t) {
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown event type %q", s)
+}
+
+// Event is a change to a profile. It's one of ProfileCreated, SegmentUpdated, TagsChanged and ProfileDeleted.
+type Event interface {
+	EventHeader() Header
+}
+
+// Header are the fields common to all the events. A profile stored in both designs changes in both:
+// its events are sent once per representation.
+type Header struct {
+	Type           EventType                 `json:"type"`
+	ProfileID      string                    `json:"profile_id"`
+	Representation repository.Representation `json:"representation"`
+	// At is the approximate time of the change.
+	At time.Time `json:"at"`
+	// ID identifies the event, e.g. to deduplicate the events sent more than once.
+	ID string `json:"id"`
+}
+
+func (h Header) EventHeader() Header {
+	return h
+}
+
+// ProfileCreated is sent when a profile is written for the first time. The profile of a blob split across chunks isn't sent.
+// Normalized profiles are created without their segments, which are sent as SegmentUpdated events.
+type ProfileCreated struct {
+	Header
+	Profile *model.Profile `json:"profile,omitempty"`
+}
+
+// SegmentUpdated is sent when a segment is written, or rewritten with different categories.
+type SegmentUpdated struct {
+	Header
+	Segment model.Segment `json:"segment"`
+}
+
+// TagsChanged is sent when the tags of a profile change, including when it's created with tags.
+type TagsChanged struct {
+	Header
+	Tags    []string `json:"tags"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// ProfileDeleted is sent when a profile is deleted, or expired when Expired is set.
+type ProfileDeleted struct {
+	Header
+	Expired bool `json:"expired"`
+}
+
+// Events returns the events of a change, none when it doesn't change the profile as seen by its readers,
+// e.g. when only the expiry date of its items is extended.
+func Events(c ddb.Change) []Event {
+	header := func(typ EventType, n int) Header {
+		return Header{
+			Type:           typ,
+			ProfileID:      c.ProfileID,
+			Representation: c.Representation,
+			At:             c.At,
+			// a record can have several events, e.g. a blob changing several segments
+			ID: fmt.Sprintf("%s-%d", c.SequenceNumber, n),
+		}
+	}
+	var events []Event
+
+	if c.Op == ddb.ChangeRemove {
+		// the segments of a profile are removed along with it, and expire on their own
+		if c.ItemType == ddb.SegmentItemType {
+			return nil
+		}
+		return []Event{ProfileDeleted{Header: header(ProfileDeletedEvent, 0), Expired: c.Expired}}
+	}
+
+	if c.ItemType == ddb.SegmentItemType {
+		if c.NewSegment != nil && (c.OldSegment == nil || segmentChanged(*c.OldSegment, *c.NewSegment)) {
+			events = append(events, SegmentUpdated{Header: header(SegmentUpdatedEvent, 0), Segment: *c.NewSegment})
+		}
+		return events
+	}
+
+	var oldProfile model.Profile
+	if c.OldProfile != nil {
+		oldProfile = *c.OldProfile
+	}
+	if c.Op == ddb.ChangeInsert {
+		events = append(events, ProfileCreated{Header: header(ProfileCreatedEvent, len(events)), Profile: c.NewProfile})
+	}
+	// the content of the blobs split across chunks isn't known
+	if c.NewProfile == nil {
+		return events
+	}
+
+	// the segments of a blob are changed along with it
+	for _, seg := range c.NewProfile.Segments {
+		old, found := lo.Find(oldProfile.Segments, func(s model.Segment) bool {
+			return s.Type == seg.Type && s.CreatedAt.Equal(seg.CreatedAt)
+		})
+		if !found || segmentChanged(old, seg) {
+			events = append(events, SegmentUpdated{Header: header(SegmentUpdatedEvent, len(events)), Segment: seg})
+		}
+	}
+
+	added, removed := lo.Difference(lo.Uniq(c.NewProfile.Tags), lo.Uniq(oldProfile.Tags))
+	if len(added) > 0 || len(removed) > 0 {
+		events = append(events, TagsChanged{
+			Header:  header(TagsChangedEvent, len(events)),
+			Tags:    c.NewProfile.Tags,
+			Added:   added,
+			Removed: removed,
+		})
+	}
+
+	return events
+}
+
+// segmentChanged reports whether the content of a segment changed, regardless of its expiry date.
+func segmentChanged(a, b model.Segment) bool {
+	return a.Type != b.Type || !a.UpdatedAt.Equal(b.UpdatedAt) ||
+		!slices.Equal(a.Categories, b.Categories) || !slices.Equal(a.TopCategories, b.TopCategories)
+}
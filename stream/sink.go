@@ -0,0 +1,44 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// Sink receives the events of the stream. Events are sent in the order of the changes to a profile's items,
+// but the sinks are called concurrently for the items stored in different shards.
+// An error is logged and the event skipped: sinks retry on their own.
+type Sink interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, e Event) error
+
+func (f SinkFunc) Send(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// NewWriterSink returns a Sink writing the events to w as NDJSON.
+func NewWriterSink(w io.Writer) Sink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return SinkFunc(func(_ context.Context, e Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(e)
+	})
+}
+
+// NewLogSink returns a Sink logging a line per event.
+func NewLogSink(log *slog.Logger) Sink {
+	return SinkFunc(func(ctx context.Context, e Event) error {
+		h := e.EventHeader()
+		log.InfoContext(ctx, "profile changed", "event", h.Type, "profile_id", h.ProfileID,
+			"representation", h.Representation, "event_id", h.ID)
+		return nil
+	})
+}
@@ -0,0 +1,294 @@
+// Package stream pushes the changes to the profiles to downstream consumers: it reads the DynamoDB Streams records of the table,
+// decodes them into typed events, and sends them to sinks.
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"personalisation-poc/repository/ddb"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// StreamsAPI is the part of the DynamoDB Streams client used by the Processor.
+type StreamsAPI interface {
+	DescribeStream(ctx context.Context, in *dynamodbstreams.DescribeStreamInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error)
+	GetShardIterator(ctx context.Context, in *dynamodbstreams.GetShardIteratorInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error)
+	GetRecords(ctx context.Context, in *dynamodbstreams.GetRecordsInput, optFns ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error)
+}
+
+// StartingPosition is where the Processor starts reading the stream.
+type StartingPosition string
+
+const (
+	// StartLatest only reads the changes made after the Processor started.
+	StartLatest StartingPosition = "latest"
+	// StartTrimHorizon reads the changes of the last 24 hours still held by the stream, then the new ones.
+	StartTrimHorizon StartingPosition = "trim-horizon"
+)
+
+// ParseStartingPosition returns the StartingPosition named s.
+func ParseStartingPosition(s string) (StartingPosition, error) {
+	switch p := StartingPosition(s); p {
+	case StartLatest, StartTrimHorizon:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown starting position %q, expected latest or trim-horizon", s)
+	}
+}
+
+type Option func(*Processor)
+
+// WithSinks adds sinks to send the events to.
+func WithSinks(sinks ...Sink) Option {
+	return func(p *Processor) {
+		p.sinks = append(p.sinks, sinks...)
+	}
+}
+
+// WithStartingPosition sets where to start reading the stream. By default it's StartLatest.
+func WithStartingPosition(start StartingPosition) Option {
+	return func(p *Processor) {
+		p.start = start
+	}
+}
+
+// WithPollInterval sets how long to wait before polling a shard without new records again,
+// and before looking for new shards. By default it's a second.
+func WithPollInterval(d time.Duration) Option {
+	return func(p *Processor) {
+		if d > 0 {
+			p.poll = d
+		}
+	}
+}
+
+// WithLogger sets the logger of the decoding and sink errors. By default it's slog.Default.
+func WithLogger(log *slog.Logger) Option {
+	return func(p *Processor) {
+		p.log = log
+	}
+}
+
+// Processor reads a stream and sends the events of its records to sinks. It doesn't checkpoint its position:
+// a restarted Processor starts again from its starting position.
+type Processor struct {
+	streams   StreamsAPI
+	streamARN string
+	sinks     []Sink
+	start     StartingPosition
+	poll      time.Duration
+	log       *slog.Logger
+}
+
+// NewProcessor returns a Processor of the stream streamARN, as returned by ddb.StreamARN.
+func NewProcessor(streams StreamsAPI, streamARN string, opts ...Option) *Processor {
+	p := &Processor{
+		streams:   streams,
+		streamARN: streamARN,
+		start:     StartLatest,
+		poll:      time.Second,
+		log:       slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Run reads the stream until ctx is done or a shard can't be read. The shards are read concurrently, each after its parent,
+// so that the changes to an item are sent in order. New shards are picked up as the stream splits them.
+func (p *Processor) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		done    = map[string]bool{}
+		started = map[string]bool{}
+		failed  error
+	)
+	first := true
+	for {
+		shards, err := p.describeShards(ctx)
+		if err != nil && ctx.Err() == nil {
+			cancel()
+			wg.Wait()
+			return fmt.Errorf("failed to describe stream: %w", err)
+		}
+
+		if first && p.start == StartLatest {
+			// the closed shards hold the changes made before the start
+			for _, s := range shards {
+				if s.SequenceNumberRange != nil && s.SequenceNumberRange.EndingSequenceNumber != nil {
+					started[*s.ShardId], done[*s.ShardId] = true, true
+				}
+			}
+		}
+
+		known := make(map[string]bool, len(shards))
+		for _, s := range shards {
+			known[*s.ShardId] = true
+		}
+		for _, s := range shards {
+			id, parent := *s.ShardId, aws.ToString(s.ParentShardId)
+			mu.Lock()
+			ready := !started[id] && (parent == "" || !known[parent] || done[parent])
+			mu.Unlock()
+			if !ready {
+				continue
+			}
+
+			typ := types.ShardIteratorTypeTrimHorizon
+			if first && p.start == StartLatest {
+				typ = types.ShardIteratorTypeLatest
+			}
+			started[id] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := p.readShard(ctx, id, typ)
+
+				mu.Lock()
+				defer mu.Unlock()
+				done[id] = true
+				if err != nil && failed == nil {
+					failed = err
+					cancel()
+				}
+			}()
+		}
+		first = false
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return failed
+		case <-time.After(p.poll):
+		}
+	}
+}
+
+func (p *Processor) describeShards(ctx context.Context) ([]types.Shard, error) {
+	var (
+		shards []types.Shard
+		start  *string
+	)
+	for {
+		out, err := p.streams.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             aws.String(p.streamARN),
+			ExclusiveStartShardId: start,
+		})
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+		if start = out.StreamDescription.LastEvaluatedShardId; start == nil {
+			return shards, nil
+		}
+	}
+}
+
+// readShard sends the events of the records of a shard, until it's closed and read to the end or ctx is done.
+func (p *Processor) readShard(ctx context.Context, shardID string, typ types.ShardIteratorType) error {
+	var last string
+	iterator, err := p.shardIterator(ctx, shardID, typ, last)
+	for err == nil && iterator != nil {
+		var out *dynamodbstreams.GetRecordsOutput
+		out, err = p.streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+
+		var (
+			expired   *types.ExpiredIteratorException
+			throttled *types.LimitExceededException
+		)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.As(err, &expired):
+			// iterators expire after 15 minutes, e.g. while a sink is slow
+			if last != "" {
+				typ = types.ShardIteratorTypeAfterSequenceNumber
+			}
+			iterator, err = p.shardIterator(ctx, shardID, typ, last)
+			continue
+		case errors.As(err, &throttled):
+			err = nil
+			if !sleep(ctx, p.poll) {
+				return nil
+			}
+			continue
+		case err != nil:
+			continue
+		}
+
+		for _, r := range out.Records {
+			p.dispatch(ctx, r)
+			if r.Dynamodb != nil {
+				last = aws.ToString(r.Dynamodb.SequenceNumber)
+			}
+		}
+		iterator = out.NextShardIterator
+		if len(out.Records) == 0 && iterator != nil && !sleep(ctx, p.poll) {
+			return nil
+		}
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read shard %s: %w", shardID, err)
+	}
+	return nil
+}
+
+func (p *Processor) shardIterator(ctx context.Context, shardID string, typ types.ShardIteratorType, after string) (*string, error) {
+	in := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(p.streamARN),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: typ,
+	}
+	if typ == types.ShardIteratorTypeAfterSequenceNumber {
+		in.SequenceNumber = aws.String(after)
+	}
+	out, err := p.streams.GetShardIterator(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out.ShardIterator, nil
+}
+
+// dispatch sends the events of a record to every sink, logging the records that can't be decoded and the failed sends.
+func (p *Processor) dispatch(ctx context.Context, r types.Record) {
+	change, ok, err := ddb.DecodeStreamRecord(r)
+	if err != nil {
+		p.log.ErrorContext(ctx, "failed to decode stream record", "event_id", aws.ToString(r.EventID), "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	for _, e := range Events(change) {
+		for _, sink := range p.sinks {
+			if err := sink.Send(ctx, e); err != nil {
+				h := e.EventHeader()
+				p.log.ErrorContext(ctx, "failed to send event", "event", h.Type, "event_id", h.ID, "profile_id", h.ProfileID, "error", err)
+			}
+		}
+	}
+}
+
+// sleep waits for d, returning false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
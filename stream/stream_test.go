@@ -0,0 +1,163 @@
+package stream
+
+import (
+	"context"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/repository/ddb"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	now := time.Now()
+	seg := model.Segment{Type: model.MorningSegmentType, TopCategories: []string{"sports"}, CreatedAt: now, UpdatedAt: now}
+	extended := seg
+	extended.ExpiresAt = now.Add(time.Hour)
+	profile := &model.Profile{ID: uuid.New(), Tags: []string{"sports_fan"}, Segments: []model.Segment{seg}}
+	retagged := *profile
+	retagged.Tags = []string{"sports_fan", "tech_geek"}
+
+	for name, tc := range map[string]struct {
+		change ddb.Change
+		events []EventType
+	}{
+		"user created": {
+			change: ddb.Change{Op: ddb.ChangeInsert, ItemType: ddb.UserItemType, NewProfile: &model.Profile{Tags: profile.Tags}},
+			events: []EventType{ProfileCreatedEvent, TagsChangedEvent},
+		},
+		"user expiry extended": {
+			change: ddb.Change{Op: ddb.ChangeModify, ItemType: ddb.UserItemType, OldProfile: &model.Profile{Tags: profile.Tags}, NewProfile: &model.Profile{Tags: profile.Tags}},
+		},
+		"user expired": {
+			change: ddb.Change{Op: ddb.ChangeRemove, ItemType: ddb.UserItemType, Expired: true},
+			events: []EventType{ProfileDeletedEvent},
+		},
+		"segment created": {
+			change: ddb.Change{Op: ddb.ChangeInsert, ItemType: ddb.SegmentItemType, NewSegment: &seg},
+			events: []EventType{SegmentUpdatedEvent},
+		},
+		"segment expiry extended": {
+			change: ddb.Change{Op: ddb.ChangeModify, ItemType: ddb.SegmentItemType, OldSegment: &seg, NewSegment: &extended},
+		},
+		"segment removed": {
+			change: ddb.Change{Op: ddb.ChangeRemove, ItemType: ddb.SegmentItemType, OldSegment: &seg},
+		},
+		"blob created": {
+			change: ddb.Change{Op: ddb.ChangeInsert, ItemType: ddb.BlobItemType, NewProfile: profile},
+			events: []EventType{ProfileCreatedEvent, SegmentUpdatedEvent, TagsChangedEvent},
+		},
+		"blob retagged": {
+			change: ddb.Change{Op: ddb.ChangeModify, ItemType: ddb.BlobItemType, OldProfile: profile, NewProfile: &retagged},
+			events: []EventType{TagsChangedEvent},
+		},
+		"chunked blob created": {
+			change: ddb.Change{Op: ddb.ChangeInsert, ItemType: ddb.BlobItemType},
+			events: []EventType{ProfileCreatedEvent},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var types []EventType
+			for _, e := range Events(tc.change) {
+				types = append(types, e.EventHeader().Type)
+			}
+			require.Equal(t, tc.events, types)
+		})
+	}
+
+	tags := Events(ddb.Change{Op: ddb.ChangeModify, ItemType: ddb.BlobItemType, OldProfile: profile, NewProfile: &retagged})[0].(TagsChanged)
+	require.Equal(t, []string{"tech_geek"}, tags.Added)
+	require.Empty(t, tags.Removed)
+}
+
+func TestProcessor(t *testing.T) {
+	// the child shard is listed first, but is read after its parent
+	parentID, childID := uuid.NewString(), uuid.NewString()
+	streams := &fakeStreams{
+		shards: []types.Shard{
+			{ShardId: aws.String("child"), ParentShardId: aws.String("parent"), SequenceNumberRange: &types.SequenceNumberRange{}},
+			{ShardId: aws.String("parent"), SequenceNumberRange: &types.SequenceNumberRange{EndingSequenceNumber: aws.String("2")}},
+		},
+		records: map[string][]types.Record{
+			"parent": {userRecord(parentID, "1"), {EventName: types.OperationTypeInsert, Dynamodb: &types.StreamRecord{}}},
+			"child":  {userRecord(childID, "3")},
+		},
+	}
+
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewProcessor(streams, "arn", WithStartingPosition(StartTrimHorizon), WithPollInterval(time.Millisecond),
+		WithSinks(SinkFunc(func(_ context.Context, e Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if events = append(events, e); len(events) == 2 {
+				cancel()
+			}
+			return nil
+		})))
+	require.NoError(t, p.Run(ctx))
+
+	require.Len(t, events, 2)
+	created := events[0].(ProfileCreated)
+	require.Equal(t, parentID, created.ProfileID)
+	require.Equal(t, repository.RepresentationProfile, created.Representation)
+	require.Equal(t, "1-0", created.ID)
+	require.Equal(t, childID, events[1].EventHeader().ProfileID)
+}
+
+// fakeStreams serves the records of its shards, closing the shards with an ending sequence number once read.
+type fakeStreams struct {
+	shards  []types.Shard
+	records map[string][]types.Record
+}
+
+func (f *fakeStreams) DescribeStream(context.Context, *dynamodbstreams.DescribeStreamInput, ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &types.StreamDescription{Shards: f.shards}}, nil
+}
+
+func (f *fakeStreams) GetShardIterator(_ context.Context, in *dynamodbstreams.GetShardIteratorInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: in.ShardId}, nil
+}
+
+func (f *fakeStreams) GetRecords(_ context.Context, in *dynamodbstreams.GetRecordsInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	shard := aws.ToString(in.ShardIterator)
+	if shard == "" {
+		// the open shard is read again until the end of the test
+		return &dynamodbstreams.GetRecordsOutput{NextShardIterator: aws.String("")}, nil
+	}
+
+	out := &dynamodbstreams.GetRecordsOutput{Records: f.records[shard]}
+	for _, s := range f.shards {
+		if *s.ShardId == shard && s.SequenceNumberRange.EndingSequenceNumber == nil {
+			out.NextShardIterator = aws.String("")
+		}
+	}
+	return out, nil
+}
+
+func userRecord(id, seq string) types.Record {
+	return types.Record{
+		EventName: types.OperationTypeInsert,
+		Dynamodb: &types.StreamRecord{
+			SequenceNumber: aws.String(seq),
+			NewImage: map[string]types.AttributeValue{
+				"pk":         &types.AttributeValueMemberS{Value: "USER#" + id},
+				"sk":         &types.AttributeValueMemberS{Value: "USER#" + id},
+				"typ":        &types.AttributeValueMemberS{Value: "USER"},
+				"id":         &types.AttributeValueMemberS{Value: id},
+				"created_at": &types.AttributeValueMemberS{Value: "2025-01-01T00:00:00Z"},
+				"updated_at": &types.AttributeValueMemberS{Value: "2025-01-01T00:00:00Z"},
+			},
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for the webhook URLs resolving to an address of a private network.
+var ErrForbiddenAddress = errors.New("forbidden address")
+
+// checkAddr fails for the addresses that webhooks can't be delivered to, so that they can't reach the services
+// of the host or of its network: loopback, private, link-local, unspecified and multicast addresses.
+func checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("%w %s", ErrForbiddenAddress, addr)
+	}
+	return nil
+}
+
+// CheckURL checks that the host of u only resolves to addresses webhooks can be delivered to.
+// As the host may resolve to other addresses later, the clients of NewClient check them again on every connection.
+func CheckURL(ctx context.Context, u *url.URL) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", u.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if err := checkAddr(addr); err != nil {
+			return fmt.Errorf("%s resolves to a %w", u.Hostname(), err)
+		}
+	}
+	return nil
+}
+
+// NewClient returns a client of the deliveries timing out after timeout, which refuses to connect to the addresses
+// rejected by CheckURL. They are checked once resolved, on every connection, redirects included.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			return checkAddr(addrPort.Addr())
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would connect to the addresses unchecked
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
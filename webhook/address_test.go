@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAddr(t *testing.T) {
+	for _, addr := range []string{"203.0.113.10", "2001:db8::1", "8.8.8.8"} {
+		require.NoError(t, checkAddr(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{
+		"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "fd00::1",
+		"169.254.169.254", "fe80::1", "0.0.0.0", "::", "224.0.0.1", "::ffff:127.0.0.1",
+	} {
+		require.ErrorIs(t, checkAddr(netip.MustParseAddr(addr)), ErrForbiddenAddress, addr)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	for _, raw := range []string{"https://203.0.113.10/hook", "http://[2001:db8::1]:8080/hook"} {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		require.NoError(t, CheckURL(ctx, u), raw)
+	}
+	for _, raw := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]/", "http://localhost/"} {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		require.ErrorIs(t, CheckURL(ctx, u), ErrForbiddenAddress, raw)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// the loopback address of the server is refused once resolved
+	_, err := NewClient(time.Second).Get(srv.URL)
+	require.ErrorIs(t, err, ErrForbiddenAddress)
+}
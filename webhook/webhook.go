@@ -0,0 +1,326 @@
+// Package webhook delivers the events of the stream to the webhooks subscribed to them, as HMAC signed HTTP requests.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/stream"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The headers of the deliveries.
+const (
+	// SignatureHeader is the signature of the payload, see Sign.
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader is the Unix time of the delivery, signed along with the payload so that deliveries can't be replayed.
+	TimestampHeader = "X-Webhook-Timestamp"
+	IDHeader        = "X-Webhook-Id"
+	EventIDHeader   = "X-Event-Id"
+	EventTypeHeader = "X-Event-Type"
+)
+
+// Sign returns the signature of a payload delivered at timestamp: the HMAC-SHA256 of "{timestamp}.{payload}"
+// keyed by the secret of the webhook, hex encoded and prefixed with "sha256=".
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of a payload delivered at timestamp, in constant time.
+// Receivers should also reject the deliveries whose timestamp is too old.
+func Verify(secret, signature string, timestamp int64, payload []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, payload)))
+}
+
+type Option func(*Dispatcher)
+
+// WithHTTPClient sets the client of the deliveries, which should be made by NewClient so that the webhooks
+// can't reach private networks. By default it's NewClient(10 * time.Second).
+func WithHTTPClient(c *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = c
+	}
+}
+
+// WithMaxAttempts sets the number of attempts of a delivery, including the first one, before it's dead-lettered.
+// By default it's 5.
+func WithMaxAttempts(n int) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = max(n, 1)
+	}
+}
+
+// WithBackoff sets the delay before the first retry of a delivery, doubled at every retry up to maxDelay.
+// The actual delay is drawn at random below it. By default it's a second, up to a minute.
+func WithBackoff(base, maxDelay time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.baseDelay, d.maxDelay = base, maxDelay
+	}
+}
+
+// WithConcurrency caps the number of delivery attempts in flight. Send blocks while the cap is reached.
+// The deliveries waiting to be retried don't count, so that a failing webhook doesn't hold up the others. By default it's 16.
+func WithConcurrency(n int) Option {
+	return func(d *Dispatcher) {
+		d.sem = make(chan struct{}, max(n, 1))
+	}
+}
+
+// WithRefreshInterval sets how long the subscriptions are cached before being read again,
+// so that the webhooks created through the API are picked up. By default it's 30 seconds.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.refresh = interval
+	}
+}
+
+// WithLogger sets the logger of the deliveries. By default it's slog.Default.
+func WithLogger(log *slog.Logger) Option {
+	return func(d *Dispatcher) {
+		d.log = log
+	}
+}
+
+// Dispatcher is a stream.Sink delivering the events to the webhooks subscribed to them.
+// Deliveries are retried with an exponential backoff on network errors, timeouts, 429 and 5xx responses,
+// and recorded as dead letters when they fail for good. Every attempt is logged.
+type Dispatcher struct {
+	repo        repository.WebhooksRepo
+	client      *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	refresh     time.Duration
+	sem         chan struct{}
+	log         *slog.Logger
+	wg          sync.WaitGroup
+
+	mu       sync.Mutex
+	webhooks []model.Webhook
+	loadedAt time.Time
+}
+
+var _ stream.Sink = &Dispatcher{} // compile time check
+
+// NewDispatcher returns a Dispatcher to the webhooks of repo.
+func NewDispatcher(repo repository.WebhooksRepo, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		repo:        repo,
+		client:      NewClient(10 * time.Second),
+		maxAttempts: 5,
+		baseDelay:   time.Second,
+		maxDelay:    time.Minute,
+		refresh:     30 * time.Second,
+		sem:         make(chan struct{}, 16),
+		log:         slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Send starts the deliveries of e to the webhooks subscribed to it, without waiting for them:
+// the deliveries of the events of a profile may arrive out of order when retried.
+func (d *Dispatcher) Send(ctx context.Context, e stream.Event) error {
+	webhooks, err := d.subscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	for _, w := range webhooks {
+		if !subscribed(w, e) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(e); err != nil {
+				return err
+			}
+		}
+
+		if !acquire(ctx, d.sem) {
+			return ctx.Err()
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.deliver(ctx, w, e.EventHeader(), payload)
+		}()
+	}
+
+	return nil
+}
+
+// Wait waits for the deliveries in flight. The deliveries interrupted by the cancellation of their context are dead-lettered.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// subscriptions returns the webhooks, read again when the cached ones are stale.
+// The stale ones are kept when they can't be read.
+func (d *Dispatcher) subscriptions(ctx context.Context) ([]model.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.loadedAt.IsZero() && time.Since(d.loadedAt) < d.refresh {
+		return d.webhooks, nil
+	}
+
+	webhooks, err := d.repo.ListWebhooks(ctx)
+	if err != nil {
+		if d.loadedAt.IsZero() {
+			return nil, fmt.Errorf("failed to list webhooks: %w", err)
+		}
+		d.log.WarnContext(ctx, "failed to refresh webhooks", "error", err)
+		return d.webhooks, nil
+	}
+	d.webhooks, d.loadedAt = webhooks, time.Now()
+	return webhooks, nil
+}
+
+// subscribed reports whether w is subscribed to e.
+func subscribed(w model.Webhook, e stream.Event) bool {
+	if !slices.Contains(w.EventTypes, string(e.EventHeader().Type)) {
+		return false
+	}
+	tags, ok := e.(stream.TagsChanged)
+	if !ok || len(w.Tags) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(w.Tags, func(tag string) bool {
+		return slices.Contains(tags.Added, tag) || slices.Contains(tags.Removed, tag)
+	})
+}
+
+// deliver delivers an event to w, retrying it until it succeeds, fails for good or runs out of attempts,
+// in which case it's dead-lettered. It's called with a slot of d.sem for the first attempt, and only holds one
+// during the attempts, not while waiting to retry.
+func (d *Dispatcher) deliver(ctx context.Context, w model.Webhook, h stream.Header, payload []byte) {
+	var (
+		status  int
+		err     error
+		attempt int
+	)
+	for attempt = 1; ; attempt++ {
+		if attempt > 1 && !acquire(ctx, d.sem) {
+			attempt-- // interrupted before the attempt
+			break
+		}
+		start := time.Now()
+		status, err = d.post(ctx, w, h, payload)
+		<-d.sem
+		attrs := []any{"webhook_id", w.ID, "event_id", h.ID, "event", h.Type, "profile_id", h.ProfileID,
+			"attempt", attempt, "status", status, "duration", time.Since(start)}
+		if err == nil {
+			d.log.InfoContext(ctx, "webhook delivered", attrs...)
+			return
+		}
+		d.log.WarnContext(ctx, "webhook delivery failed", append(attrs, "error", err)...)
+
+		if !retryable(status) || attempt >= d.maxAttempts || !sleep(ctx, d.backoff(attempt)) {
+			break
+		}
+	}
+
+	letter := model.DeadLetter{
+		WebhookID: w.ID,
+		EventID:   h.ID,
+		EventType: string(h.Type),
+		ProfileID: h.ProfileID,
+		Attempts:  attempt,
+		Status:    status,
+		Error:     err.Error(),
+		FailedAt:  time.Now(),
+	}
+	// interrupted deliveries are dead-lettered too, rather than lost
+	if err := d.repo.PutDeadLetter(context.WithoutCancel(ctx), letter); err != nil {
+		d.log.ErrorContext(ctx, "failed to record dead letter", "webhook_id", w.ID, "event_id", h.ID, "error", err)
+		return
+	}
+	d.log.ErrorContext(ctx, "webhook delivery dead-lettered", "webhook_id", w.ID, "event_id", h.ID, "attempts", attempt, "error", err)
+}
+
+// post makes a delivery attempt, returning the status of the response, zero when there's none.
+func (d *Dispatcher) post(ctx context.Context, w model.Webhook, h stream.Header, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, w.ID)
+	req.Header.Set(EventIDHeader, h.ID)
+	req.Header.Set(EventTypeHeader, string(h.Type))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(w.Secret, timestamp, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drained so that the connection is reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether a delivery that got a response with status, zero for none, can be attempted again.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// backoff returns the delay before the given retry, starting at 1.
+func (d *Dispatcher) backoff(retry int) time.Duration {
+	ceiling := d.baseDelay
+	for i := 1; i < retry && ceiling < d.maxDelay; i++ {
+		ceiling *= 2
+	}
+	if d.maxDelay > 0 && ceiling > d.maxDelay {
+		ceiling = d.maxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
+
+// acquire takes a slot of sem, returning false when ctx is done first.
+func acquire(ctx context.Context, sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep waits for d, returning false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
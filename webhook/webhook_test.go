@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/stream"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"type":"tags.changed"}`)
+	signature := Sign("secret", 1700000000, payload)
+	require.Regexp(t, "^sha256=[0-9a-f]{64}$", signature)
+	require.True(t, Verify("secret", signature, 1700000000, payload))
+	require.False(t, Verify("other", signature, 1700000000, payload))
+	require.False(t, Verify("secret", signature, 1700000001, payload))
+	require.False(t, Verify("secret", signature, 1700000000, []byte(`{"type":"profile.deleted"}`)))
+}
+
+func TestDispatcher(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received = map[string][]string{} // event types by webhook
+		attempts = map[string]int{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if !Verify("secret", r.Header.Get(SignatureHeader), timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		hook := r.Header.Get(IDHeader)
+		attempts[hook]++
+		switch {
+		case r.URL.Path == "/flaky" && attempts[hook] == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			received[hook] = append(received[hook], r.Header.Get(EventTypeHeader))
+		}
+	}))
+	defer srv.Close()
+
+	repo := &fakeRepo{webhooks: []model.Webhook{
+		{ID: "flaky", URL: srv.URL + "/flaky", Secret: "secret", EventTypes: []string{"profile.created", "tags.changed"}},
+		{ID: "sports", URL: srv.URL + "/sports", Secret: "secret", EventTypes: []string{"tags.changed"}, Tags: []string{"sports_fan"}},
+		{ID: "gone", URL: srv.URL + "/gone", Secret: "secret", EventTypes: []string{"profile.deleted"}},
+		{ID: "down", URL: "http://127.0.0.1:1", Secret: "secret", EventTypes: []string{"profile.deleted"}},
+	}}
+	// the test server listens on the loopback address, which the clients of NewClient refuse
+	d := NewDispatcher(repo, WithHTTPClient(&http.Client{}), WithBackoff(time.Millisecond, 2*time.Millisecond), WithMaxAttempts(3))
+
+	header := func(typ stream.EventType) stream.Header {
+		return stream.Header{Type: typ, ProfileID: "id", Representation: repository.RepresentationProfile, ID: string(typ)}
+	}
+	ctx := context.Background()
+	require.NoError(t, d.Send(ctx, stream.ProfileCreated{Header: header(stream.ProfileCreatedEvent)}))
+	require.NoError(t, d.Send(ctx, stream.TagsChanged{Header: header(stream.TagsChangedEvent), Added: []string{"sports_fan"}}))
+	require.NoError(t, d.Send(ctx, stream.TagsChanged{Header: header(stream.TagsChangedEvent), Added: []string{"tech_geek"}}))
+	require.NoError(t, d.Send(ctx, stream.ProfileDeleted{Header: header(stream.ProfileDeletedEvent)}))
+	d.Wait()
+
+	// the flaky webhook gets every event after a retry, in any order
+	require.ElementsMatch(t, []string{"profile.created", "tags.changed", "tags.changed"}, received["flaky"])
+	// only the tags.changed events adding or removing sports_fan are delivered
+	require.Equal(t, []string{"tags.changed"}, received["sports"])
+
+	// 410 isn't retried, unreachable webhooks are
+	require.Len(t, repo.letters, 2)
+	letters := map[string]model.DeadLetter{}
+	for _, l := range repo.letters {
+		letters[l.WebhookID] = l
+	}
+	require.Equal(t, 1, letters["gone"].Attempts)
+	require.Equal(t, http.StatusGone, letters["gone"].Status)
+	require.Equal(t, "profile.deleted", letters["gone"].EventType)
+	require.Equal(t, "id", letters["gone"].ProfileID)
+	require.Equal(t, 3, letters["down"].Attempts)
+	require.Zero(t, letters["down"].Status)
+}
+
+type fakeRepo struct {
+	repository.WebhooksRepo
+	webhooks []model.Webhook
+
+	mu      sync.Mutex
+	letters []model.DeadLetter
+}
+
+func (f *fakeRepo) ListWebhooks(context.Context) ([]model.Webhook, error) {
+	return f.webhooks, nil
+}
+
+func (f *fakeRepo) PutDeadLetter(_ context.Context, l model.DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.letters = append(f.letters, l)
+	return nil
+}
+
+func TestDispatcherRetryReleasesSlot(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string // the webhooks called, in order
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, r.URL.Path)
+		if r.URL.Path == "/flaky" && len(calls) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	repo := &fakeRepo{webhooks: []model.Webhook{
+		{ID: "flaky", URL: srv.URL + "/flaky", Secret: "secret", EventTypes: []string{"profile.deleted"}},
+		{ID: "ok", URL: srv.URL + "/ok", Secret: "secret", EventTypes: []string{"profile.deleted"}},
+	}}
+	// a single slot, and a backoff long enough for the other webhook to take it
+	d := NewDispatcher(repo, WithHTTPClient(&http.Client{}), WithConcurrency(1), WithBackoff(500*time.Millisecond, 500*time.Millisecond))
+
+	header := stream.Header{Type: stream.ProfileDeletedEvent, ProfileID: "id", ID: "1"}
+	require.NoError(t, d.Send(context.Background(), stream.ProfileDeleted{Header: header}))
+	d.Wait()
+
+	// the other webhook is delivered while the flaky one waits to be retried
+	require.Equal(t, []string{"/flaky", "/ok", "/flaky"}, calls)
+	require.Empty(t, repo.letters)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/stream"
+	"personalisation-poc/webhook"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+)
+
+func handleCreateWebhook(repo repository.WebhooksRepo, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var hook model.Webhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			httpError(w, r, log, err, "error decoding webhook", http.StatusBadRequest)
+			return
+		}
+		if err := validateWebhook(r.Context(), &hook); err != nil {
+			httpError(w, r, log, err, "invalid webhook", http.StatusUnprocessableEntity)
+			return
+		}
+
+		if err := repo.CreateWebhook(r.Context(), hook); err != nil {
+			httpError(w, r, log, err, "error creating webhook", http.StatusInternalServerError)
+			return
+		}
+
+		log.InfoContext(r.Context(), "webhook created", "webhook_id", hook.ID, "url", hook.URL, "events", hook.EventTypes, "owner", hook.Owner)
+		// the secret is only returned now
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", apiBasePath+"/webhooks/"+hook.ID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(hook)
+	}
+}
+
+// validateWebhook checks a webhook to create, and sets its ID, owner, creation time and, unless given, secret.
+// Its URL must only resolve to public addresses, which the deliveries check again.
+func validateWebhook(ctx context.Context, hook *model.Webhook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL, got %q", hook.URL)
+	}
+	if err := webhook.CheckURL(ctx, u); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	if len(hook.EventTypes) == 0 {
+		return errors.New("event_types is required")
+	}
+	for _, typ := range hook.EventTypes {
+		if _, err := stream.ParseEventType(typ); err != nil {
+			return err
+		}
+	}
+	hook.EventTypes = lo.Uniq(hook.EventTypes)
+	hook.Tags = lo.Uniq(hook.Tags)
+
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+	hook.ID = uuid.NewString()
+	hook.Owner = adminClient(ctx)
+	hook.CreatedAt = time.Now().UTC()
+	return nil
+}
+
+func handleListWebhooks(repo repository.WebhooksRepo, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hooks, err := repo.ListWebhooks(r.Context())
+		if err != nil {
+			httpError(w, r, log, err, "error listing webhooks", http.StatusInternalServerError)
+			return
+		}
+
+		owner := adminClient(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lo.FilterMap(hooks, func(hook model.Webhook, _ int) (model.Webhook, bool) {
+			hook.Secret = ""
+			return hook, hook.Owner == owner
+		}))
+	}
+}
+
+// getOwnWebhook returns the webhook of the URL when it's owned by the admin client of the request.
+// The webhooks of other clients are reported as not found, so that their IDs aren't disclosed.
+func getOwnWebhook(r *http.Request, repo repository.WebhooksRepo) (*model.Webhook, error) {
+	hook, err := repo.GetWebhook(r.Context(), r.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	if hook.Owner != adminClient(r.Context()) {
+		return nil, repository.ErrNoWebhookFound
+	}
+	return hook, nil
+}
+
+func handleGetWebhook(repo repository.WebhooksRepo, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, err := getOwnWebhook(r, repo)
+		if err != nil {
+			httpError(w, r, log, err, "error getting webhook", http.StatusInternalServerError)
+			return
+		}
+
+		hook.Secret = ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hook)
+	}
+}
+
+func handleDeleteWebhook(repo repository.WebhooksRepo, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, err := getOwnWebhook(r, repo)
+		if err != nil {
+			httpError(w, r, log, err, "error getting webhook", http.StatusInternalServerError)
+			return
+		}
+		if err := repo.DeleteWebhook(r.Context(), hook.ID); err != nil {
+			httpError(w, r, log, err, "error deleting webhook", http.StatusInternalServerError)
+			return
+		}
+
+		log.InfoContext(r.Context(), "webhook deleted", "webhook_id", hook.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleListDeadLetters(repo repository.WebhooksRepo, log *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hook, err := getOwnWebhook(r, repo)
+		if err != nil {
+			httpError(w, r, log, err, "error getting webhook", http.StatusInternalServerError)
+			return
+		}
+		letters, err := repo.ListDeadLetters(r.Context(), hook.ID)
+		if err != nil {
+			httpError(w, r, log, err, "error listing dead letters", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(letters)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"personalisation-poc/model"
+	"personalisation-poc/repository"
+	"personalisation-poc/repository/ddb"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/guregu/dynamo/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryWebhooks is an in-memory WebhooksRepo.
+type memoryWebhooks struct {
+	mu       sync.Mutex
+	webhooks map[string]model.Webhook
+}
+
+func (m *memoryWebhooks) CreateWebhook(_ context.Context, w model.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[w.ID]; ok {
+		return repository.ErrConflict
+	}
+	m.webhooks[w.ID] = w
+	return nil
+}
+
+func (m *memoryWebhooks) GetWebhook(_ context.Context, id string) (*model.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, repository.ErrNoWebhookFound
+	}
+	return &w, nil
+}
+
+func (m *memoryWebhooks) ListWebhooks(context.Context) ([]model.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var webhooks []model.Webhook
+	for _, w := range m.webhooks {
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+func (m *memoryWebhooks) DeleteWebhook(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[id]; !ok {
+		return repository.ErrNoWebhookFound
+	}
+	delete(m.webhooks, id)
+	return nil
+}
+
+func (m *memoryWebhooks) PutDeadLetter(context.Context, model.DeadLetter) error {
+	return nil
+}
+
+func (m *memoryWebhooks) ListDeadLetters(context.Context, string) ([]model.DeadLetter, error) {
+	return []model.DeadLetter{}, nil
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// the admin endpoints aren't called, their table is never reached
+	db := ddb.NewDB(dynamo.New(aws.Config{Region: "us-east-1"}).Table("unused"))
+	srv := newServer(stubRepo{}, log,
+		withAdmin(db, AdminConfig{APIKeys: map[string]string{"ops": "ops-key", "ci": "ci-key"}}),
+		withWebhooks(&memoryWebhooks{webhooks: map[string]model.Webhook{}}),
+	)
+
+	do := func(apiKey, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, apiBasePath+path, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		rec := httptest.NewRecorder()
+		srv.handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do("", http.MethodGet, "/webhooks", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do("", http.MethodPost, "/webhooks", `{"url":"https://203.0.113.10/hook","event_types":["tags.changed"]}`).Code)
+
+	// the webhooks can't target the host or its networks
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest/meta-data", "http://10.0.0.1/hook", "http://[::1]/hook"} {
+		rec := do("ops-key", http.MethodPost, "/webhooks", `{"url":"`+url+`","event_types":["tags.changed"]}`)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, url)
+	}
+
+	rec := do("ops-key", http.MethodPost, "/webhooks", `{"url":"https://203.0.113.10/hook","event_types":["tags.changed"],"owner":"ci"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created model.Webhook
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&created))
+	require.NotEmpty(t, created.ID)
+	require.NotEmpty(t, created.Secret) // only returned on creation
+	require.Equal(t, "ops", created.Owner)
+	require.Equal(t, apiBasePath+"/webhooks/"+created.ID, rec.Header().Get("Location"))
+
+	rec = do("ops-key", http.MethodGet, "/webhooks", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), "secret")
+	var listed []model.Webhook
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	require.Equal(t, created.ID, listed[0].ID)
+
+	rec = do("ops-key", http.MethodGet, "/webhooks/"+created.ID, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotContains(t, rec.Body.String(), "secret")
+	var got model.Webhook
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	require.Equal(t, created.URL, got.URL)
+
+	// the webhooks of other clients don't exist for them
+	rec = do("ci-key", http.MethodGet, "/webhooks", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[]`, rec.Body.String())
+	require.Equal(t, http.StatusNotFound, do("ci-key", http.MethodGet, "/webhooks/"+created.ID, "").Code)
+	require.Equal(t, http.StatusNotFound, do("ci-key", http.MethodGet, "/webhooks/"+created.ID+"/dead-letters", "").Code)
+	require.Equal(t, http.StatusNotFound, do("ci-key", http.MethodDelete, "/webhooks/"+created.ID, "").Code)
+
+	require.Equal(t, http.StatusOK, do("ops-key", http.MethodGet, "/webhooks/"+created.ID+"/dead-letters", "").Code)
+	require.Equal(t, http.StatusNoContent, do("ops-key", http.MethodDelete, "/webhooks/"+created.ID, "").Code)
+	require.Equal(t, http.StatusNotFound, do("ops-key", http.MethodGet, "/webhooks/"+created.ID, "").Code)
+	require.Equal(t, http.StatusNotFound, do("ops-key", http.MethodDelete, "/webhooks/"+created.ID, "").Code)
+}